@@ -0,0 +1,181 @@
+package boltstore
+
+import (
+	"encoding/binary"
+	"log"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// bucketName is the bucket holding the session data.
+var bucketName = []byte("scs.sessions")
+
+// BoltStore represents the session store.
+type BoltStore struct {
+	db          *bbolt.DB
+	stopCleanup chan bool
+}
+
+// New returns a new BoltStore instance, with a background cleanup goroutine
+// that runs every 5 minutes to remove expired session data.
+func New(db *bbolt.DB) *BoltStore {
+	return NewWithCleanupInterval(db, 5*time.Minute)
+}
+
+// NewWithCleanupInterval returns a new BoltStore instance. The cleanupInterval
+// parameter controls how frequently expired session data is removed by the
+// background cleanup goroutine. Setting it to 0 prevents the cleanup goroutine
+// from running (i.e. expired sessions will not be removed).
+func NewWithCleanupInterval(db *bbolt.DB, cleanupInterval time.Duration) *BoltStore {
+	b := &BoltStore{db: db}
+	if cleanupInterval > 0 {
+		b.StartCleanup(cleanupInterval)
+	}
+	return b
+}
+
+// Find returns the data for a given session token from the BoltStore instance.
+// If the session token is not found or is expired, the returned exists flag will
+// be set to false.
+func (b *BoltStore) Find(token string) ([]byte, bool, error) {
+	var data []byte
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		if bucket == nil {
+			return nil
+		}
+		v := bucket.Get([]byte(token))
+		if v == nil || expired(v) {
+			return nil
+		}
+		// The value is only valid for the life of the transaction.
+		data = append([]byte{}, v[8:]...)
+		return nil
+	})
+	if err != nil || data == nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// Commit adds a session token and data to the BoltStore instance with the
+// given expiry time. If the session token already exists, then the data and expiry
+// time are updated.
+func (b *BoltStore) Commit(token string, data []byte, expiry time.Time) error {
+	// Each value is the expiry time in Unix nanoseconds followed by the data.
+	v := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint64(v, uint64(expiry.UnixNano()))
+	copy(v[8:], data)
+
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(bucketName)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(token), v)
+	})
+}
+
+// Delete removes a session token and corresponding data from the BoltStore
+// instance.
+func (b *BoltStore) Delete(token string) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete([]byte(token))
+	})
+}
+
+// StartCleanup starts a background goroutine which deletes expired session
+// data from the bucket every interval. This is useful when the store was
+// created with NewWithCleanupInterval(db, 0) and the cleanup should begin
+// later. A cleanup goroutine which is already running is stopped first. Use
+// StopCleanup to terminate the goroutine.
+func (b *BoltStore) StartCleanup(interval time.Duration) {
+	b.StopCleanup()
+	b.stopCleanup = make(chan bool)
+	go b.startCleanup(interval, b.stopCleanup)
+}
+
+func (b *BoltStore) startCleanup(interval time.Duration, stop chan bool) {
+	ticker := time.NewTicker(interval)
+	for {
+		select {
+		case <-ticker.C:
+			err := b.deleteExpired()
+			if err != nil {
+				log.Println(err)
+			}
+		case <-stop:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// StopCleanup terminates the background cleanup goroutine for the BoltStore
+// instance. It's rare to terminate this; generally BoltStore instances and
+// their cleanup goroutines are intended to be long-lived and run for the lifetime
+// of your application.
+//
+// There may be occasions though when your use of the BoltStore is transient.
+// An example is creating a new BoltStore instance in a test function. In this
+// scenario, the cleanup goroutine (which will run forever) will prevent the
+// BoltStore object from being garbage collected even after the test function
+// has finished. You can prevent this by manually calling StopCleanup.
+func (b *BoltStore) StopCleanup() {
+	if b.stopCleanup != nil {
+		b.stopCleanup <- true
+		b.stopCleanup = nil
+	}
+}
+
+// deleteExpired collects the expired tokens in a read transaction and then
+// deletes them in a separate write transaction, so the write lock is not held
+// while the whole bucket is scanned.
+func (b *BoltStore) deleteExpired() error {
+	var tokens [][]byte
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			if expired(v) {
+				tokens = append(tokens, append([]byte{}, k...))
+			}
+			return nil
+		})
+	})
+	if err != nil || len(tokens) == 0 {
+		return err
+	}
+
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		if bucket == nil {
+			return nil
+		}
+		for _, token := range tokens {
+			// The session may have been committed again since the scan.
+			if v := bucket.Get(token); v == nil || !expired(v) {
+				continue
+			}
+			if err := bucket.Delete(token); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// expired returns true if the stored value v has expired or is malformed.
+func expired(v []byte) bool {
+	if len(v) < 8 {
+		return true
+	}
+	return time.Now().UnixNano() > int64(binary.BigEndian.Uint64(v[:8]))
+}
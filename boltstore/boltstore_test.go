@@ -0,0 +1,200 @@
+package boltstore
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aberlorn/scs/v2"
+	"go.etcd.io/bbolt"
+)
+
+var _ scs.Store = (*BoltStore)(nil)
+
+func openTestDB(t *testing.T) *bbolt.DB {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "sessions.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestFind(t *testing.T) {
+	b := NewWithCleanupInterval(openTestDB(t), 0)
+
+	err := b.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, found, err := b.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+	if bytes.Equal(data, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", data, []byte("encoded_data"))
+	}
+}
+
+func TestFindMissing(t *testing.T) {
+	b := NewWithCleanupInterval(openTestDB(t), 0)
+
+	_, found, err := b.Find("missing_session_token")
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestSaveUpdated(t *testing.T) {
+	b := NewWithCleanupInterval(openTestDB(t), 0)
+
+	err := b.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Commit("session_token", []byte("new_encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, _, err := b.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(data, []byte("new_encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", data, []byte("new_encoded_data"))
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	b := NewWithCleanupInterval(openTestDB(t), 0)
+
+	err := b.Commit("session_token", []byte("encoded_data"), time.Now().Add(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, found, _ := b.Find("session_token")
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+
+	time.Sleep(101 * time.Millisecond)
+	_, found, _ = b.Find("session_token")
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	b := NewWithCleanupInterval(openTestDB(t), 0)
+
+	// Deleting before anything has been committed is a no-op.
+	err := b.Delete("session_token")
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+
+	err = b.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Delete("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, found, err := b.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func countKeys(t *testing.T, db *bbolt.DB) int {
+	var n int
+	err := db.View(func(tx *bbolt.Tx) error {
+		n = tx.Bucket(bucketName).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestCleanup(t *testing.T) {
+	db := openTestDB(t)
+	b := NewWithCleanupInterval(db, 200*time.Millisecond)
+	defer b.StopCleanup()
+
+	err := b.Commit("session_token", []byte("encoded_data"), time.Now().Add(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Commit("other_session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if n := countKeys(t, db); n != 1 {
+		t.Fatalf("got %d: expected %d", n, 1)
+	}
+	_, found, _ := b.Find("other_session_token")
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+}
+
+func TestStartCleanup(t *testing.T) {
+	db := openTestDB(t)
+	b := NewWithCleanupInterval(db, 0)
+
+	err := b.Commit("session_token", []byte("encoded_data"), time.Now().Add(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.StartCleanup(200 * time.Millisecond)
+	defer b.StopCleanup()
+
+	time.Sleep(300 * time.Millisecond)
+	if n := countKeys(t, db); n != 0 {
+		t.Fatalf("got %d: expected %d", n, 0)
+	}
+}
+
+func TestStopCleanup(t *testing.T) {
+	db := openTestDB(t)
+	b := New(db)
+
+	done := make(chan struct{})
+	go func() {
+		// Starting the cleanup again replaces the goroutine started by New,
+		// and stopping it straight away, or twice, doesn't block.
+		b.StartCleanup(time.Hour)
+		b.StopCleanup()
+		b.StopCleanup()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("StopCleanup blocked")
+	}
+	if b.stopCleanup != nil {
+		t.Fatalf("got %v: expected %v", b.stopCleanup, nil)
+	}
+}
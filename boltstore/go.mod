@@ -0,0 +1,22 @@
+module github.com/aberlorn/scs/boltstore
+
+go 1.17
+
+require go.etcd.io/bbolt v1.3.8
+
+require (
+	github.com/labstack/echo/v4 v4.0.0 // indirect
+	github.com/labstack/gommon v0.2.8 // indirect
+	github.com/mattn/go-colorable v0.0.9 // indirect
+	github.com/mattn/go-isatty v0.0.4 // indirect
+	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	github.com/valyala/fasttemplate v0.0.0-20170224212429-dcecefd839c4 // indirect
+	golang.org/x/crypto v0.0.0-20190130090550-b01c7a725664 // indirect
+)
+
+require (
+	github.com/aberlorn/scs/v2 v2.0.0
+	golang.org/x/sys v0.4.0 // indirect
+)
+
+replace github.com/aberlorn/scs/v2 => ../
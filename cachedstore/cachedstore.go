@@ -0,0 +1,181 @@
+package cachedstore
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aberlorn/scs/v2"
+)
+
+type entry struct {
+	token    string
+	b        []byte
+	expiry   time.Time // The session expiry, as committed.
+	cachedAt time.Time
+}
+
+// CachedStore represents the session store. It wraps another store, keeping
+// recently used session data in memory so that repeated reads of the same
+// session within the TTL don't reach the inner store.
+type CachedStore struct {
+	inner      scs.Store
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // Most recently used at the front.
+
+	// writes counts the commits and deletes, so that Find doesn't cache data
+	// read from the inner store if it may have been replaced meanwhile.
+	writes uint64
+}
+
+var (
+	_ scs.Store    = (*CachedStore)(nil)
+	_ scs.Pingable = (*CachedStore)(nil)
+)
+
+// New returns a new CachedStore instance wrapping inner. Session data is
+// cached for at most ttl after it was read from or committed to inner, and
+// at most maxEntries sessions are cached, evicting the least recently used.
+// A maxEntries of 0 means no limit.
+//
+// Changes made to inner by other processes (e.g. other instances of your
+// application) are not seen until the cached data expires, so ttl should be
+// short.
+//
+// Only Find, Commit, Delete and Ping are passed on to inner. The optional
+// interfaces scs.VersionedStore, scs.ExpiryStore, scs.TouchableStore,
+// scs.CountableStore and scs.GarbageCollectable are not implemented by
+// CachedStore, so the session works as if inner didn't implement them. New
+// panics if inner implements scs.ContextStore, as such a store needs the
+// request context for every operation.
+func New(inner scs.Store, ttl time.Duration, maxEntries int) *CachedStore {
+	if _, ok := inner.(scs.ContextStore); ok {
+		panic(fmt.Errorf("cachedstore: cannot wrap %T, which implements scs.ContextStore", inner))
+	}
+	return &CachedStore{
+		inner:      inner,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// Find returns the data for a given session token from the cache, or from the
+// inner store if it isn't cached. If the session token is not found or is
+// expired, the returned exists flag will be set to false.
+func (cs *CachedStore) Find(token string) ([]byte, bool, error) {
+	b, ok, writes := cs.get(token)
+	if ok {
+		return b, true, nil
+	}
+
+	b, found, err := cs.inner.Find(token)
+	if err != nil || !found {
+		return b, found, err
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.writes == writes {
+		// The expiry isn't known, so the data is only cached for the ttl.
+		cs.set(token, b, time.Time{})
+	}
+	return b, true, nil
+}
+
+// Commit adds a session token and data to the inner store with the given
+// expiry time, and then to the cache. If the commit fails, the token is
+// removed from the cache.
+func (cs *CachedStore) Commit(token string, b []byte, expiry time.Time) error {
+	err := cs.inner.Commit(token, b, expiry)
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.writes++
+	if err != nil {
+		cs.remove(token)
+		return err
+	}
+	cs.set(token, b, expiry)
+	return nil
+}
+
+// Delete removes a session token and corresponding data from the cache and
+// the inner store.
+func (cs *CachedStore) Delete(token string) error {
+	err := cs.inner.Delete(token)
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.writes++
+	cs.remove(token)
+	return err
+}
+
+// Ping checks that the inner store is reachable, if it implements
+// scs.Pingable.
+func (cs *CachedStore) Ping(ctx context.Context) error {
+	if ps, ok := cs.inner.(scs.Pingable); ok {
+		return ps.Ping(ctx)
+	}
+	return nil
+}
+
+// get returns the cached data for token, if it is cached and fresh, along
+// with the current count of writes.
+func (cs *CachedStore) get(token string) ([]byte, bool, uint64) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	el, ok := cs.entries[token]
+	if !ok {
+		return nil, false, cs.writes
+	}
+	e := el.Value.(*entry)
+	now := time.Now()
+	if now.Sub(e.cachedAt) >= cs.ttl || (!e.expiry.IsZero() && now.After(e.expiry)) {
+		cs.remove(token)
+		return nil, false, cs.writes
+	}
+	cs.lru.MoveToFront(el)
+	return e.b, true, cs.writes
+}
+
+// set caches b for token, evicting the least recently used entry if there
+// are more than maxEntries. The caller must hold cs.mu.
+func (cs *CachedStore) set(token string, b []byte, expiry time.Time) {
+	// The caller may reuse b, so the cache keeps its own copy.
+	e := &entry{
+		token:    token,
+		b:        append([]byte(nil), b...),
+		expiry:   expiry,
+		cachedAt: time.Now(),
+	}
+	if el, ok := cs.entries[token]; ok {
+		el.Value = e
+		cs.lru.MoveToFront(el)
+		return
+	}
+	cs.entries[token] = cs.lru.PushFront(e)
+
+	if cs.maxEntries > 0 && cs.lru.Len() > cs.maxEntries {
+		oldest := cs.lru.Back()
+		cs.lru.Remove(oldest)
+		delete(cs.entries, oldest.Value.(*entry).token)
+	}
+}
+
+// remove removes token from the cache. The caller must hold cs.mu.
+func (cs *CachedStore) remove(token string) {
+	if el, ok := cs.entries[token]; ok {
+		cs.lru.Remove(el)
+		delete(cs.entries, token)
+	}
+}
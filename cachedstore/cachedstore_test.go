@@ -0,0 +1,336 @@
+package cachedstore
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aberlorn/scs/v2/cookiestore"
+	"github.com/aberlorn/scs/v2/memstore"
+)
+
+// countingStore wraps a MemStore, counting the calls to Find and optionally
+// failing commits.
+type countingStore struct {
+	*memstore.MemStore
+	finds      int64
+	failCommit bool
+}
+
+func newCountingStore() *countingStore {
+	return &countingStore{MemStore: memstore.NewWithCleanupInterval(0)}
+}
+
+func (s *countingStore) Find(token string) ([]byte, bool, error) {
+	atomic.AddInt64(&s.finds, 1)
+	return s.MemStore.Find(token)
+}
+
+func (s *countingStore) Commit(token string, b []byte, expiry time.Time) error {
+	if s.failCommit {
+		return errors.New("commit failed")
+	}
+	return s.MemStore.Commit(token, b, expiry)
+}
+
+func (s *countingStore) findCount() int64 {
+	return atomic.LoadInt64(&s.finds)
+}
+
+func TestFind(t *testing.T) {
+	inner := newCountingStore()
+	cs := New(inner, time.Minute, 0)
+
+	err := inner.MemStore.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		b, found, err := cs.Find("session_token")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != true {
+			t.Fatalf("got %v: expected %v", found, true)
+		}
+		if bytes.Equal(b, []byte("encoded_data")) == false {
+			t.Fatalf("got %v: expected %v", b, []byte("encoded_data"))
+		}
+	}
+	if n := inner.findCount(); n != 1 {
+		t.Fatalf("got %d: expected %d", n, 1)
+	}
+}
+
+func TestFindMissing(t *testing.T) {
+	inner := newCountingStore()
+	cs := New(inner, time.Minute, 0)
+
+	_, found, err := cs.Find("missing_session_token")
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+
+	// Missing sessions aren't cached, so a session committed to the inner
+	// store directly is found.
+	err = inner.MemStore.Commit("missing_session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, found, err = cs.Find("missing_session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+}
+
+func TestCommit(t *testing.T) {
+	inner := newCountingStore()
+	cs := New(inner, time.Minute, 0)
+
+	err := cs.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cs.Commit("session_token", []byte("new_encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _, err := cs.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(b, []byte("new_encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("new_encoded_data"))
+	}
+	if n := inner.findCount(); n != 0 {
+		t.Fatalf("got %d: expected %d", n, 0)
+	}
+	b, _, err = inner.MemStore.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(b, []byte("new_encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("new_encoded_data"))
+	}
+}
+
+func TestCommitFailure(t *testing.T) {
+	inner := newCountingStore()
+	cs := New(inner, time.Minute, 0)
+
+	err := cs.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed commit removes the token from the cache rather than caching
+	// data the inner store doesn't have.
+	inner.failCommit = true
+	err = cs.Commit("session_token", []byte("new_encoded_data"), time.Now().Add(time.Minute))
+	if err == nil {
+		t.Fatal("expected commit to fail")
+	}
+	b, _, err := cs.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(b, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("encoded_data"))
+	}
+	if n := inner.findCount(); n != 1 {
+		t.Fatalf("got %d: expected %d", n, 1)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	inner := newCountingStore()
+	cs := New(inner, time.Minute, 0)
+
+	err := cs.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cs.Delete("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, found, err := cs.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+	_, found, err = inner.MemStore.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	inner := newCountingStore()
+	cs := New(inner, 50*time.Millisecond, 0)
+
+	err := cs.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A change made to the inner store by another process.
+	err = inner.MemStore.Commit("session_token", []byte("new_encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	b, _, err := cs.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(b, []byte("new_encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("new_encoded_data"))
+	}
+	if n := inner.findCount(); n != 1 {
+		t.Fatalf("got %d: expected %d", n, 1)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	cs := New(newCountingStore(), time.Minute, 0)
+
+	err := cs.Commit("session_token", []byte("encoded_data"), time.Now().Add(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	_, found, err := cs.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+	inner := newCountingStore()
+	cs := New(inner, time.Minute, 2)
+
+	for _, token := range []string{"a", "b", "c"} {
+		err := cs.Commit(token, []byte("encoded_data"), time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token == "b" {
+			// Using "a" makes "b" the least recently used.
+			if _, _, err = cs.Find("a"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if cs.lru.Len() != 2 {
+		t.Fatalf("got %d: expected %d", cs.lru.Len(), 2)
+	}
+	for token, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, cached := cs.entries[token]; cached != want {
+			t.Fatalf("%s: got %v: expected %v", token, cached, want)
+		}
+	}
+
+	// The evicted session is read from the inner store.
+	_, found, err := cs.Find("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+	if n := inner.findCount(); n != 1 {
+		t.Fatalf("got %d: expected %d", n, 1)
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	cs := New(newCountingStore(), time.Minute, 10)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token := strconv.Itoa(i % 5)
+			for j := 0; j < 100; j++ {
+				if err := cs.Commit(token, []byte(strconv.Itoa(j)), time.Now().Add(time.Minute)); err != nil {
+					t.Error(err)
+				}
+				if _, _, err := cs.Find(token); err != nil {
+					t.Error(err)
+				}
+				if j%10 == 0 {
+					if err := cs.Delete(token); err != nil {
+						t.Error(err)
+					}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func benchmarkFind(b *testing.B, cached bool) {
+	inner := newCountingStore()
+	var store interface {
+		Find(string) ([]byte, bool, error)
+		Commit(string, []byte, time.Time) error
+	} = inner
+	if cached {
+		store = New(inner, time.Minute, 0)
+	}
+
+	err := store.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Hour))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := store.Find("session_token"); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(inner.findCount())/float64(b.N), "inner-finds/op")
+}
+
+func BenchmarkFindInner(b *testing.B) {
+	benchmarkFind(b, false)
+}
+
+func BenchmarkFindCached(b *testing.B) {
+	benchmarkFind(b, true)
+}
+
+func TestContextStoreRejected(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatal("got no panic: expected New to panic")
+		}
+	}()
+	New(cookiestore.New([]byte("secret")), time.Second, 0)
+}
@@ -0,0 +1,27 @@
+package scs
+
+import "time"
+
+// Clock is the interface for the source of the current time used by a
+// Session for deadlines, idle timeouts and token rotation. Setting a fake
+// Clock lets tests check expiry without sleeping. Implementations must be
+// safe for concurrent use.
+//
+// Session stores check expiry against their own clock, so a fake Clock
+// should not be set far from the real time when a store is used.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is a Clock implementation which returns the real current
+// time. It is the default for new sessions.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time { return time.Now() }
+
+func (s *Session) clock() Clock {
+	if s.Clock == nil {
+		return SystemClock{}
+	}
+	return s.Clock
+}
@@ -0,0 +1,138 @@
+package scs
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+func init() {
+	// Register common types which gob does not know about already, so they
+	// can be stored as session values without calling RegisterType.
+	gob.Register(time.Duration(0))
+	gob.Register([]string(nil))
+	gob.Register(map[string]string(nil))
+}
+
+// Codec is the interface for encoding/decoding session data to and from a byte
+// slice for use by the session store.
+type Codec interface {
+	Encode(deadline time.Time, values map[string]interface{}) ([]byte, error)
+	Decode(b []byte) (deadline time.Time, values map[string]interface{}, err error)
+}
+
+// GobCodec is used for encoding/decoding session data to and from a byte
+// slice using the encoding/gob package. It is the default codec.
+type GobCodec struct{}
+
+// Encode converts a session deadline and values into a byte slice.
+func (GobCodec) Encode(deadline time.Time, values map[string]interface{}) ([]byte, error) {
+	aux := &struct {
+		Deadline time.Time
+		Values   map[string]interface{}
+	}{
+		Deadline: deadline,
+		Values:   values,
+	}
+
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(&aux)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// Decode converts a byte slice into a session deadline and values.
+func (GobCodec) Decode(b []byte) (time.Time, map[string]interface{}, error) {
+	aux := &struct {
+		Deadline time.Time
+		Values   map[string]interface{}
+	}{}
+
+	r := bytes.NewReader(b)
+	err := gob.NewDecoder(r).Decode(&aux)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+
+	return aux.Deadline, aux.Values, nil
+}
+
+// RegisterType registers the type of v with gob (see gob.Register), so that
+// values of that type can be stored in the session, and records it for
+// ValidateTypes. v should be a representative value of the type, such as its
+// zero value.
+func (s *Session) RegisterType(v interface{}) {
+	gob.Register(v)
+	s.types = append(s.types, v)
+}
+
+// gobRegistered records the types registered with gob by autoRegister. As gob
+// registration is global, so is the record.
+var gobRegistered sync.Map
+
+// autoRegister registers the type of val with gob if AutoRegisterGob is set
+// and the type has not been seen before.
+func (s *Session) autoRegister(val interface{}) {
+	if !s.AutoRegisterGob || val == nil {
+		return
+	}
+	typ := reflect.TypeOf(val)
+	// Predeclared types such as string and int are registered by gob itself.
+	if typ.PkgPath() == "" && typ.Name() != "" {
+		return
+	}
+	if _, seen := gobRegistered.LoadOrStore(typ, struct{}{}); seen {
+		return
+	}
+	defer func() {
+		// gob.Register panics if the type has already been registered under
+		// a different name with gob.RegisterName, in which case it can be
+		// encoded already.
+		recover()
+	}()
+	gob.Register(val)
+}
+
+// ValidateTypes encodes a value of each type registered with RegisterType
+// using the session's Codec, and returns an error naming the first type which
+// can't be encoded (e.g. because it holds an interface value of a type which
+// has not been registered). Calling it at startup catches such
+// misconfiguration before a request fails to commit.
+func (s *Session) ValidateTypes() error {
+	for _, v := range s.types {
+		_, err := s.Codec.Encode(time.Now(), map[string]interface{}{"value": v})
+		if err != nil {
+			return fmt.Errorf("scs: session values of type %T can't be encoded: %v", v, err)
+		}
+	}
+	return nil
+}
+
+// dataHeader is the magic and version prefix written before the encoded
+// session data when Session.DataHeader is enabled.
+var dataHeader = []byte("scs\x01")
+
+func (s *Session) addDataHeader(b []byte) []byte {
+	if !s.DataHeader {
+		return b
+	}
+	return append(append(make([]byte, 0, len(dataHeader)+len(b)), dataHeader...), b...)
+}
+
+// stripDataHeader removes the data header from b. It returns false if
+// Session.DataHeader is enabled and b does not start with the header.
+func (s *Session) stripDataHeader(b []byte) ([]byte, bool) {
+	if !s.DataHeader {
+		return b, true
+	}
+	if !bytes.HasPrefix(b, dataHeader) {
+		return nil, false
+	}
+	return b[len(dataHeader):], true
+}
@@ -0,0 +1,601 @@
+package scs
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestGobCodec(t *testing.T) {
+	deadline := time.Now().Add(time.Hour).UTC()
+
+	b, err := GobCodec{}.Encode(deadline, map[string]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, values, err := GobCodec{}.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Equal(deadline) {
+		t.Errorf("got %v: expected %v", d, deadline)
+	}
+	if values["foo"] != "bar" {
+		t.Errorf("got %v: expected %v", values["foo"], "bar")
+	}
+}
+
+func TestEncryptedCodec(t *testing.T) {
+	codec, err := NewEncryptedCodec(testKey(1), GobCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Hour).UTC()
+	b, err := codec.Encode(deadline, map[string]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, []byte("bar")) {
+		t.Errorf("encoded data contains the plaintext value")
+	}
+
+	d, values, err := codec.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Equal(deadline) {
+		t.Errorf("got %v: expected %v", d, deadline)
+	}
+	if values["foo"] != "bar" {
+		t.Errorf("got %v: expected %v", values["foo"], "bar")
+	}
+}
+
+func TestEncryptedCodecTampered(t *testing.T) {
+	codec, err := NewEncryptedCodec(testKey(1), GobCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := codec.Encode(time.Now().Add(time.Hour), map[string]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b[len(b)-1] ^= 0xff
+	_, _, err = codec.Decode(b)
+	if err != ErrDecryptionFailed {
+		t.Errorf("got %v: expected %v", err, ErrDecryptionFailed)
+	}
+
+	_, _, err = codec.Decode([]byte("short"))
+	if err != ErrDecryptionFailed {
+		t.Errorf("got %v: expected %v", err, ErrDecryptionFailed)
+	}
+}
+
+func TestEncryptedCodecWrongKey(t *testing.T) {
+	codec1, err := NewEncryptedCodec(testKey(1), GobCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec2, err := NewEncryptedCodec(testKey(2), GobCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := codec1.Encode(time.Now().Add(time.Hour), map[string]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = codec2.Decode(b)
+	if err != ErrDecryptionFailed {
+		t.Errorf("got %v: expected %v", err, ErrDecryptionFailed)
+	}
+}
+
+func TestEncryptedCodecKeyRotation(t *testing.T) {
+	oldCodec, err := NewEncryptedCodec(testKey(1), GobCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCodec, err := NewEncryptedCodec(testKey(2), GobCodec{}, testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := oldCodec.Encode(time.Now().Add(time.Hour), map[string]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, values, err := newCodec.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["foo"] != "bar" {
+		t.Errorf("got %v: expected %v", values["foo"], "bar")
+	}
+
+	b, err = newCodec.Encode(time.Now().Add(time.Hour), map[string]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = oldCodec.Decode(b)
+	if err != ErrDecryptionFailed {
+		t.Errorf("got %v: expected %v", err, ErrDecryptionFailed)
+	}
+}
+
+func TestEncryptedCodecWithKeys(t *testing.T) {
+	oldCodec, err := NewEncryptedCodecWithKeys([][]byte{testKey(1)}, GobCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCodec, err := NewEncryptedCodecWithKeys([][]byte{testKey(2), testKey(1)}, GobCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newOnlyCodec, err := NewEncryptedCodecWithKeys([][]byte{testKey(2)}, GobCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCodec, err := NewEncryptedCodecWithKeys([][]byte{testKey(3), testKey(4)}, GobCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Data encrypted with the old key still decrypts after a new key has
+	// been prepended.
+	b, err := oldCodec.Encode(time.Now().Add(time.Hour), map[string]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, values, err := newCodec.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["foo"] != "bar" {
+		t.Errorf("got %v: expected %v", values["foo"], "bar")
+	}
+	_, _, err = otherCodec.Decode(b)
+	if err != ErrDecryptionFailed {
+		t.Errorf("got %v: expected %v", err, ErrDecryptionFailed)
+	}
+
+	// New data is encrypted with the new key only.
+	b, err = newCodec.Encode(time.Now().Add(time.Hour), map[string]interface{}{"foo": "baz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, values, err = newOnlyCodec.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["foo"] != "baz" {
+		t.Errorf("got %v: expected %v", values["foo"], "baz")
+	}
+	_, _, err = oldCodec.Decode(b)
+	if err != ErrDecryptionFailed {
+		t.Errorf("got %v: expected %v", err, ErrDecryptionFailed)
+	}
+
+	_, err = NewEncryptedCodecWithKeys(nil, GobCodec{})
+	if err == nil {
+		t.Errorf("expected an error for no keys")
+	}
+	_, err = NewEncryptedCodecWithKeys([][]byte{testKey(1), []byte("too short")}, GobCodec{})
+	if err == nil {
+		t.Errorf("expected an error for an invalid key length")
+	}
+}
+
+func TestEncryptedCodecInvalidKey(t *testing.T) {
+	_, err := NewEncryptedCodec([]byte("too short"), GobCodec{})
+	if err == nil {
+		t.Errorf("expected an error for an invalid key length")
+	}
+}
+
+func TestDataHeader(t *testing.T) {
+	s := NewSession()
+	s.DataHeader = true
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	token, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _, err := s.Store.Find(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, dataHeader) {
+		t.Errorf("got %v: expected prefix %v", b[:len(dataHeader)], dataHeader)
+	}
+
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if s.GetString(ctx, "foo") != "bar" {
+		t.Errorf("got %q: expected %q", s.GetString(ctx, "foo"), "bar")
+	}
+}
+
+func TestDataHeaderForeignData(t *testing.T) {
+	s := NewSession()
+	s.DataHeader = true
+
+	// Valid gob-encoded session data written without the header, as another
+	// application sharing the store might.
+	b, err := GobCodec{}.Encode(time.Now().Add(time.Hour), map[string]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Store.Commit("foreign_token", b, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := newTestContext()
+	if _, err = s.Load(ctx, "foreign_token"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Token(ctx) != "" {
+		t.Errorf("got %q: expected a fresh session", s.Token(ctx))
+	}
+	if s.Exists(ctx, "foo") {
+		t.Errorf("got %v: expected %v", s.Exists(ctx, "foo"), false)
+	}
+}
+
+type registeredType struct {
+	Name string
+}
+
+type unregisteredType struct {
+	Name string
+}
+
+type wrapperType struct {
+	Value interface{}
+}
+
+func TestRegisterType(t *testing.T) {
+	s := NewSession()
+	s.RegisterType(registeredType{})
+	if err := s.ValidateTypes(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Registered and pre-registered types can be stored.
+	values := map[string]interface{}{
+		"custom":   registeredType{Name: "alice"},
+		"duration": 5 * time.Minute,
+		"slice":    []string{"a", "b"},
+		"map":      map[string]string{"a": "b"},
+	}
+	b, err := s.Codec.Encode(time.Now(), values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, got, err := s.Codec.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, values) {
+		t.Errorf("got %v: expected %v", got, values)
+	}
+
+	// A registered type holding an unregistered one can't be encoded, which
+	// ValidateTypes reports before any request commits.
+	s.RegisterType(wrapperType{Value: unregisteredType{}})
+	err = s.ValidateTypes()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !strings.Contains(err.Error(), "scs.wrapperType") {
+		t.Errorf("got %q: expected the type to be named", err)
+	}
+}
+
+type autoRegisteredType struct {
+	Name string
+}
+
+func TestAutoRegisterGob(t *testing.T) {
+	s := NewSession()
+
+	// Without AutoRegisterGob the type can't be committed.
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "custom", autoRegisteredType{Name: "alice"})
+	if _, _, err := s.Commit(ctx); err == nil {
+		t.Fatal("expected an error committing an unregistered type")
+	}
+
+	s.AutoRegisterGob = true
+	ctx = newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "custom", autoRegisteredType{Name: "alice"})
+	s.PutAll(ctx, map[string]interface{}{"other": []autoRegisteredType{{Name: "bob"}}, "name": "carol"})
+	token, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Get(ctx, "custom"); got != (autoRegisteredType{Name: "alice"}) {
+		t.Errorf("got %v: expected %v", got, autoRegisteredType{Name: "alice"})
+	}
+	if got := s.Get(ctx, "other"); !reflect.DeepEqual(got, []autoRegisteredType{{Name: "bob"}}) {
+		t.Errorf("got %v: expected %v", got, []autoRegisteredType{{Name: "bob"}})
+	}
+	if got := s.GetString(ctx, "name"); got != "carol" {
+		t.Errorf("got %q: expected %q", got, "carol")
+	}
+}
+
+type point struct {
+	X, Y int
+}
+
+type label struct {
+	Text string
+}
+
+func TestRegistryCodec(t *testing.T) {
+	registry := NewTypeRegistry()
+	if err := registry.Register("point", point{}); err != nil {
+		t.Fatal(err)
+	}
+	codec := NewRegistryCodec(registry)
+
+	deadline := time.Now().Add(time.Hour).UTC()
+	values := map[string]interface{}{
+		"point":    point{X: 1, Y: 2},
+		"str":      "bar",
+		"int":      42,
+		"bytes":    []byte("data"),
+		"time":     deadline,
+		"duration": 5 * time.Minute,
+		"nil":      nil,
+	}
+	b, err := codec.Encode(deadline, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, got, err := codec.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Equal(deadline) {
+		t.Errorf("got %v: expected %v", d, deadline)
+	}
+	if !got["time"].(time.Time).Equal(deadline) {
+		t.Errorf("got %v: expected %v", got["time"], deadline)
+	}
+	delete(got, "time")
+	delete(values, "time")
+	if !reflect.DeepEqual(got, values) {
+		t.Errorf("got %v: expected %v", got, values)
+	}
+
+	// The type is not registered with gob.
+	_, err = GobCodec{}.Encode(deadline, map[string]interface{}{"point": point{}})
+	if err == nil {
+		t.Error("expected an error from GobCodec")
+	}
+
+	_, err = codec.Encode(deadline, map[string]interface{}{"label": label{}})
+	if err == nil || !strings.Contains(err.Error(), "scs.label") {
+		t.Errorf("got %v: expected an error naming the type", err)
+	}
+
+	if err := registry.Register("point", label{}); err == nil {
+		t.Error("expected an error registering a duplicate name")
+	}
+	if err := registry.Register("other", point{}); err == nil {
+		t.Error("expected an error registering a duplicate type")
+	}
+}
+
+func TestRegistryCodecCustomFunc(t *testing.T) {
+	registry := NewTypeRegistry()
+	err := registry.RegisterFunc("label", label{},
+		func(v interface{}) ([]byte, error) {
+			return []byte(v.(label).Text), nil
+		},
+		func(b []byte) (interface{}, error) {
+			return label{Text: string(b)}, nil
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec := NewRegistryCodec(registry)
+
+	b, err := codec.Encode(time.Now(), map[string]interface{}{"label": label{Text: "hello"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, got, err := codec.Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["label"] != (label{Text: "hello"}) {
+		t.Errorf("got %v: expected %v", got["label"], label{Text: "hello"})
+	}
+}
+
+func TestRegistryCodecSessions(t *testing.T) {
+	registryA := NewTypeRegistry()
+	if err := registryA.Register("shape", point{}); err != nil {
+		t.Fatal(err)
+	}
+	registryB := NewTypeRegistry()
+	if err := registryB.Register("shape", label{}); err != nil {
+		t.Fatal(err)
+	}
+
+	sessionA := NewSession()
+	sessionA.Codec = NewRegistryCodec(registryA)
+	sessionB := NewSession()
+	sessionB.Codec = NewRegistryCodec(registryB)
+	sessionB.Store = sessionA.Store
+
+	// The same name is used for a different type by each session.
+	ctx := newTestContext()
+	if _, err := sessionA.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	sessionA.Put(ctx, "shape", point{X: 1, Y: 2})
+	tokenA, _, err := sessionA.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx = newTestContext()
+	if _, err = sessionB.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	sessionB.Put(ctx, "shape", label{Text: "square"})
+	tokenB, _, err := sessionB.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx = newTestContext()
+	if _, err = sessionA.Load(ctx, tokenA); err != nil {
+		t.Fatal(err)
+	}
+	if got := sessionA.Get(ctx, "shape"); got != (point{X: 1, Y: 2}) {
+		t.Errorf("got %v: expected %v", got, point{X: 1, Y: 2})
+	}
+
+	ctx = newTestContext()
+	if _, err = sessionB.Load(ctx, tokenB); err != nil {
+		t.Fatal(err)
+	}
+	if got := sessionB.Get(ctx, "shape"); got != (label{Text: "square"}) {
+		t.Errorf("got %v: expected %v", got, label{Text: "square"})
+	}
+
+	// A type registered with one session can't be stored by the other.
+	ctx = newTestContext()
+	if _, err = sessionB.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	sessionB.Put(ctx, "point", point{})
+	if _, _, err = sessionB.Commit(ctx); err == nil {
+		t.Error("expected an error committing an unregistered type")
+	}
+}
+
+func TestCompressingCodec(t *testing.T) {
+	codec := NewCompressingCodec(GobCodec{}, 0)
+	deadline := time.Now().Add(time.Hour).UTC()
+
+	tests := []struct {
+		name       string
+		value      string
+		compressed bool
+	}{
+		{"small", "bar", false},
+		{"large", strings.Repeat("permission:read ", 500), true},
+	}
+	for _, tt := range tests {
+		b, err := codec.Encode(deadline, map[string]interface{}{"foo": tt.value})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := b[0] == gzipMarker; got != tt.compressed {
+			t.Errorf("%s: got compressed %v: expected %v", tt.name, got, tt.compressed)
+		}
+		if tt.compressed && len(b) >= len(tt.value) {
+			t.Errorf("%s: got %d bytes: expected fewer than %d", tt.name, len(b), len(tt.value))
+		}
+
+		d, values, err := codec.Decode(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !d.Equal(deadline) {
+			t.Errorf("%s: got %v: expected %v", tt.name, d, deadline)
+		}
+		if values["foo"] != tt.value {
+			t.Errorf("%s: got %.20q: expected %.20q", tt.name, values["foo"], tt.value)
+		}
+	}
+}
+
+func TestCompressingCodecCorrupt(t *testing.T) {
+	codec := NewCompressingCodec(GobCodec{}, 16)
+
+	b, err := codec.Encode(time.Now().Add(time.Hour), map[string]interface{}{"foo": strings.Repeat("bar", 100)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b[0] != gzipMarker {
+		t.Fatalf("got marker %#x: expected %#x", b[0], gzipMarker)
+	}
+
+	for name, corrupt := range map[string][]byte{
+		"truncated": b[:len(b)/2],
+		"header":    append([]byte{gzipMarker}, []byte("not gzip data")...),
+		"marker":    append([]byte{0x7f}, b[1:]...),
+		"empty":     {},
+	} {
+		_, _, err = codec.Decode(corrupt)
+		if err == nil || !strings.HasPrefix(err.Error(), "scs: ") {
+			t.Errorf("%s: got %v: expected an scs error", name, err)
+		}
+	}
+}
+
+func benchmarkCodec(b *testing.B, codec Codec, value string) {
+	deadline := time.Now().Add(time.Hour)
+	values := map[string]interface{}{"foo": value}
+	for i := 0; i < b.N; i++ {
+		data, err := codec.Encode(deadline, values)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, _, err = codec.Decode(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCompressingCodecSmall(b *testing.B) {
+	benchmarkCodec(b, NewCompressingCodec(GobCodec{}, 0), "bar")
+}
+
+func BenchmarkCompressingCodecLarge(b *testing.B) {
+	benchmarkCodec(b, NewCompressingCodec(GobCodec{}, 0), strings.Repeat("permission:read ", 500))
+}
+
+func BenchmarkGobCodecLarge(b *testing.B) {
+	benchmarkCodec(b, GobCodec{}, strings.Repeat("permission:read ", 500))
+}
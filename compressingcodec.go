@@ -0,0 +1,98 @@
+package scs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// DefaultCompressionThreshold is the size in bytes of encoded session data
+// above which a CompressingCodec created with a threshold of 0 compresses it.
+const DefaultCompressionThreshold = 1024
+
+// Markers prefixed to the data encoded by CompressingCodec.
+const (
+	uncompressedMarker byte = 0
+	gzipMarker         byte = 1
+)
+
+// CompressingCodec wraps another Codec and gzip-compresses the encoded
+// session data when it is larger than a threshold, reducing the size of
+// sessions holding sizable values in the store. Smaller session data is
+// stored uncompressed, as compression would gain little and cost CPU time.
+// The data is prefixed with a one-byte marker saying whether it was
+// compressed, so data written by a CompressingCodec can't be read by the
+// inner codec alone (or vice versa).
+//
+// To combine it with an EncryptedCodec, compress first, i.e. wrap the
+// CompressingCodec in the EncryptedCodec, as encrypted data doesn't compress.
+type CompressingCodec struct {
+	inner     Codec
+	threshold int
+}
+
+// NewCompressingCodec returns a new CompressingCodec which compresses the
+// output of inner when it is longer than threshold bytes. A threshold of 0
+// means DefaultCompressionThreshold.
+func NewCompressingCodec(inner Codec, threshold int) *CompressingCodec {
+	if threshold <= 0 {
+		threshold = DefaultCompressionThreshold
+	}
+	return &CompressingCodec{inner: inner, threshold: threshold}
+}
+
+// Encode encodes the session data with the inner codec, compressing the
+// result if it is longer than the threshold.
+func (cc *CompressingCodec) Encode(deadline time.Time, values map[string]interface{}) ([]byte, error) {
+	b, err := cc.inner.Encode(deadline, values)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) <= cc.threshold {
+		return append([]byte{uncompressedMarker}, b...), nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(gzipMarker)
+	zw := gzip.NewWriter(&buf)
+	_, err = zw.Write(b)
+	if err != nil {
+		return nil, err
+	}
+	err = zw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decode decompresses the session data if it was compressed, and then decodes
+// the result with the inner codec. An error is returned if the data is
+// missing its marker or the compressed data is corrupt or truncated.
+func (cc *CompressingCodec) Decode(b []byte) (time.Time, map[string]interface{}, error) {
+	if len(b) == 0 {
+		return time.Time{}, nil, errors.New("scs: session data is missing its compression marker")
+	}
+
+	switch b[0] {
+	case uncompressedMarker:
+		return cc.inner.Decode(b[1:])
+	case gzipMarker:
+		zr, err := gzip.NewReader(bytes.NewReader(b[1:]))
+		if err != nil {
+			return time.Time{}, nil, fmt.Errorf("scs: compressed session data is corrupt: %v", err)
+		}
+		data, err := ioutil.ReadAll(zr)
+		if err != nil {
+			return time.Time{}, nil, fmt.Errorf("scs: compressed session data is corrupt: %v", err)
+		}
+		return cc.inner.Decode(data)
+	}
+
+	return time.Time{}, nil, fmt.Errorf("scs: session data has an unknown compression marker %#x", b[0])
+}
@@ -0,0 +1,353 @@
+package cookiestore
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aberlorn/scs/v2"
+)
+
+// DefaultMaxCookieSize is the default maximum length of each serialized data
+// cookie, including its name and attributes. Browsers limit cookies to around
+// 4KB.
+const DefaultMaxCookieSize = 4096
+
+// DefaultMaxSize is the default maximum total length of the encoded session
+// data across all data cookies.
+const DefaultMaxSize = 16000
+
+// ErrTooLarge is returned by CommitContext when the encoded session data is
+// longer than MaxSize, or a data cookie can't be kept within MaxCookieSize.
+var ErrTooLarge = errors.New("cookiestore: session data exceeds the maximum size")
+
+var errContextRequired = errors.New("cookiestore: the session data can only be accessed with a request context")
+
+// CookieStore represents the session store. The session data is stored in
+// signed cookies on the client, split across numbered cookies (e.g.
+// "session_0", "session_1") when it doesn't fit in one cookie of
+// MaxCookieSize. The data is
+// signed but not encrypted; use scs.EncryptedCodec if the session values must
+// be kept secret from the client.
+//
+// CookieStore implements scs.ClientSideStore and can only be used through a
+// Session, which passes it the request context.
+type CookieStore struct {
+	// Cookie sets the name prefix and attributes of the data cookies.
+	Cookie scs.SessionCookie
+
+	// MaxSize sets the maximum total length of the encoded session data.
+	// Committing more data than this fails with ErrTooLarge.
+	MaxSize int
+
+	// MaxCookieSize sets the maximum length in bytes of each serialized data
+	// cookie, including its name and attributes. The session data is split
+	// into chunks so that every data cookie fits.
+	MaxCookieSize int
+
+	// secrets are the keys used to verify the data cookies. The first is
+	// also used to sign them.
+	secrets [][]byte
+}
+
+var (
+	_ scs.Store           = (*CookieStore)(nil)
+	_ scs.ContextStore    = (*CookieStore)(nil)
+	_ scs.ClientSideStore = (*CookieStore)(nil)
+)
+
+// New returns a new CookieStore instance which signs the session data with
+// secret. The data cookies are named "session_0", "session_1" and so on.
+//
+// Data cookies signed with any of oldSecrets are also accepted, so the secret
+// can be rotated without resetting every session: pass the previous secret in
+// oldSecrets until the data cookies signed with it have expired.
+func New(secret []byte, oldSecrets ...[]byte) *CookieStore {
+	return &CookieStore{
+		Cookie: scs.SessionCookie{
+			Name:     "session",
+			HttpOnly: true,
+			Path:     "/",
+			Persist:  true,
+			SameSite: http.SameSiteLaxMode,
+		},
+		MaxSize:       DefaultMaxSize,
+		MaxCookieSize: DefaultMaxCookieSize,
+		secrets:       append([][]byte{secret}, oldSecrets...),
+	}
+}
+
+// Find always returns a found value of false, as the session data is held in
+// the request cookies. Use FindContext instead.
+func (cs *CookieStore) Find(token string) ([]byte, bool, error) {
+	return nil, false, nil
+}
+
+// Commit returns an error, as the session data is written to the response
+// cookies. Use CommitContext instead.
+func (cs *CookieStore) Commit(token string, b []byte, expiry time.Time) error {
+	return errContextRequired
+}
+
+// Delete returns an error, as the session data is removed by expiring the
+// response cookies. Use DeleteContext instead.
+func (cs *CookieStore) Delete(token string) error {
+	return errContextRequired
+}
+
+// FindContext reassembles the session data from the request cookies. If the
+// cookies are missing, expired, truncated or have been tampered with, the
+// returned found flag will be set to false.
+func (cs *CookieStore) FindContext(c scs.SessionContext, token string) ([]byte, bool, error) {
+	cookie, err := c.Cookie(cs.chunkName(0))
+	if err != nil {
+		return nil, false, nil
+	}
+	i := strings.IndexByte(cookie.Value, '.')
+	if i < 0 {
+		return nil, false, nil
+	}
+	n, err := strconv.Atoi(cookie.Value[:i])
+	if err != nil || n < 1 {
+		return nil, false, nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString(cookie.Value[i+1:])
+	for i := 1; i < n; i++ {
+		cookie, err := c.Cookie(cs.chunkName(i))
+		if err != nil {
+			return nil, false, nil
+		}
+		sb.WriteString(cookie.Value)
+	}
+
+	parts := strings.Split(sb.String(), ".")
+	if len(parts) != 2 {
+		return nil, false, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || len(data) < 8 {
+		return nil, false, nil
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, false, nil
+	}
+	if !cs.verify(token, data, mac) {
+		return nil, false, nil
+	}
+
+	expiry := int64(binary.BigEndian.Uint64(data[:8]))
+	if time.Now().UnixNano() > expiry {
+		return nil, false, nil
+	}
+	return data[8:], true, nil
+}
+
+// CommitContext signs the session data and writes it to the response in as
+// many data cookies as needed. Any further data cookies which the client may
+// still hold (see chunkCount) are expired. ErrTooLarge is returned if the
+// encoded data is longer than MaxSize, or if a data cookie would be longer
+// than MaxCookieSize.
+func (cs *CookieStore) CommitContext(c scs.SessionContext, token string, b []byte, expiry time.Time) error {
+	data := make([]byte, 8+len(b))
+	binary.BigEndian.PutUint64(data[:8], uint64(expiry.UnixNano()))
+	copy(data[8:], b)
+
+	value := base64.RawURLEncoding.EncodeToString(data) + "." +
+		base64.RawURLEncoding.EncodeToString(cs.sign(cs.secrets[0], token, data))
+	if len(value) > cs.MaxSize {
+		return ErrTooLarge
+	}
+
+	sizes, err := cs.chunkSizes(len(value), expiry)
+	if err != nil {
+		return err
+	}
+	cookies := make([]string, len(sizes))
+	for i, size := range sizes {
+		chunk := value[:size]
+		value = value[size:]
+		if i == 0 {
+			chunk = strconv.Itoa(len(sizes)) + "." + chunk
+		}
+		cookies[i] = cs.cookie(i, chunk, expiry).String()
+		if len(cookies[i]) > cs.MaxCookieSize {
+			return ErrTooLarge
+		}
+	}
+
+	header, err := scs.ResponseHeader(c)
+	if err != nil {
+		return err
+	}
+	stale := cs.chunkCount(c)
+	for _, cookie := range cookies {
+		header.Add("Set-Cookie", cookie)
+	}
+	cs.expireChunks(header, len(sizes), stale)
+
+	c.Set(cs.contextKey(), token)
+	c.Set(cs.chunksKey(), len(sizes))
+	return nil
+}
+
+// DeleteContext expires every data cookie sent with the request. It is a no-op
+// if the session data has already been committed under a different token in
+// the current request cycle (e.g. after RenewToken), as the old data cookies
+// have been replaced.
+func (cs *CookieStore) DeleteContext(c scs.SessionContext, token string) error {
+	if committed, ok := c.Get(cs.contextKey()).(string); ok && committed != token {
+		return nil
+	}
+	header, err := scs.ResponseHeader(c)
+	if err != nil {
+		return err
+	}
+	cs.expireChunks(header, 0, cs.chunkCount(c))
+	c.Set(cs.contextKey(), nil)
+	c.Set(cs.chunksKey(), 0)
+	return nil
+}
+
+// ClientSide implements scs.ClientSideStore: the session data is held by the
+// client, so the data of other sessions can't be reached.
+func (cs *CookieStore) ClientSide() {}
+
+// chunkCount returns the number of data cookies the client may hold: the
+// largest of the count recorded in the first data cookie of the request, the
+// number of data cookies sent with the request, and the number written
+// earlier in the current response. Using the recorded count means chunks are
+// still expired when the request is missing one in the middle.
+func (cs *CookieStore) chunkCount(c scs.SessionContext) int {
+	n, _ := c.Get(cs.chunksKey()).(int)
+
+	if cookie, err := c.Cookie(cs.chunkName(0)); err == nil {
+		if i := strings.IndexByte(cookie.Value, '.'); i > 0 {
+			// The count is not signed, so it is capped at the number of
+			// chunks which could have been written.
+			recorded, err := strconv.Atoi(cookie.Value[:i])
+			if err == nil && recorded > n && recorded <= cs.maxChunks() {
+				n = recorded
+			}
+		}
+	}
+
+	for ; ; n++ {
+		if _, err := c.Cookie(cs.chunkName(n)); err != nil {
+			return n
+		}
+	}
+}
+
+// chunkSizes splits an encoded value of length n into chunks, returning the
+// length of each, so that every data cookie written with expiry is at most
+// MaxCookieSize long once its name, attributes and (for the first cookie) the
+// "<count>." prefix are added.
+func (cs *CookieStore) chunkSizes(n int, expiry time.Time) ([]int, error) {
+	// The width of the count prefix isn't known until the value has been
+	// split, so the split is repeated with a wider prefix until it fits.
+	for width := 1; ; width++ {
+		var sizes []int
+		for i, rest := 0, n; i == 0 || rest > 0; i++ {
+			size := cs.MaxCookieSize - len(cs.cookie(i, "", expiry).String())
+			if i == 0 {
+				size -= width + 1
+			}
+			if size <= 0 {
+				return nil, ErrTooLarge
+			}
+			if size > rest {
+				size = rest
+			}
+			sizes = append(sizes, size)
+			rest -= size
+		}
+		if len(strconv.Itoa(len(sizes))) <= width {
+			return sizes, nil
+		}
+	}
+}
+
+// maxChunks returns the largest number of data cookies CommitContext writes,
+// for data of MaxSize with a distant expiry.
+func (cs *CookieStore) maxChunks() int {
+	sizes, err := cs.chunkSizes(cs.MaxSize, time.Now().AddDate(100, 0, 0))
+	if err != nil {
+		return 0
+	}
+	return len(sizes)
+}
+
+// expireChunks expires the data cookies with indexes from from up to (but not
+// including) to.
+func (cs *CookieStore) expireChunks(header scs.HeaderWriter, from, to int) {
+	for i := from; i < to; i++ {
+		header.Add("Set-Cookie", cs.cookie(i, "", time.Time{}).String())
+	}
+}
+
+func (cs *CookieStore) cookie(i int, value string, expiry time.Time) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     cs.chunkName(i),
+		Value:    value,
+		Path:     cs.Cookie.Path,
+		Domain:   cs.Cookie.Domain,
+		Secure:   cs.Cookie.Secure,
+		HttpOnly: cs.Cookie.HttpOnly,
+	}
+	if cs.Cookie.SameSite != 0 && cs.Cookie.SameSite != http.SameSiteDefaultMode {
+		cookie.SameSite = cs.Cookie.SameSite
+	}
+
+	if expiry.IsZero() {
+		cookie.Expires = time.Unix(1, 0)
+		cookie.MaxAge = -1
+	} else if cs.Cookie.Persist {
+		cookie.Expires = time.Unix(expiry.Unix()+1, 0)        // Round up to the nearest second.
+		cookie.MaxAge = int(time.Until(expiry).Seconds() + 1) // Round up to the nearest second.
+	}
+	return cookie
+}
+
+// sign returns the HMAC of the data, bound to the session token so that data
+// cookies cannot be reused with another session cookie.
+func (cs *CookieStore) sign(secret []byte, token string, data []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(token))
+	h.Write([]byte{0})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// verify reports whether mac is the HMAC of the data signed with any of the
+// secrets.
+func (cs *CookieStore) verify(token string, data, mac []byte) bool {
+	for _, secret := range cs.secrets {
+		if hmac.Equal(mac, cs.sign(secret, token, data)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cs *CookieStore) chunkName(i int) string {
+	return cs.Cookie.Name + "_" + strconv.Itoa(i)
+}
+
+func (cs *CookieStore) contextKey() string {
+	return "cookiestore." + cs.Cookie.Name
+}
+
+// chunksKey is the context key for the number of data cookies written to the
+// current response.
+func (cs *CookieStore) chunksKey() string {
+	return cs.contextKey() + ".chunks"
+}
@@ -0,0 +1,446 @@
+package cookiestore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aberlorn/scs/v2"
+	"github.com/labstack/echo/v4"
+)
+
+var secret = []byte("test-secret")
+
+func newContext(cookies []*http.Cookie) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	return echo.New().NewContext(req, rec), rec
+}
+
+func responseCookies(rec *httptest.ResponseRecorder) []*http.Cookie {
+	return (&http.Response{Header: rec.Header()}).Cookies()
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestFind(t *testing.T) {
+	cs := New(secret)
+
+	c, rec := newContext(nil)
+	err := cs.CommitContext(c, "session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := responseCookies(rec)
+	if len(cookies) != 1 || cookies[0].Name != "session_0" {
+		t.Fatalf("got %v: expected a single session_0 cookie", cookies)
+	}
+
+	c, _ = newContext(cookies)
+	b, found, err := cs.FindContext(c, "session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+	if bytes.Equal(b, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("encoded_data"))
+	}
+}
+
+func TestFindMissing(t *testing.T) {
+	cs := New(secret)
+
+	c, _ := newContext(nil)
+	_, found, err := cs.FindContext(c, "missing_session_token")
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestFindTampered(t *testing.T) {
+	cs := New(secret)
+
+	c, rec := newContext(nil)
+	err := cs.CommitContext(c, "session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := responseCookies(rec)
+
+	// The data is bound to the session token.
+	c, _ = newContext(cookies)
+	_, found, err := cs.FindContext(c, "other_session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+
+	v := []byte(cookies[0].Value)
+	if v[5] == 'A' {
+		v[5] = 'B'
+	} else {
+		v[5] = 'A'
+	}
+	cookies[0].Value = string(v)
+	c, _ = newContext(cookies)
+	_, found, err = cs.FindContext(c, "session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	c, rec := newContext(nil)
+	err := New(secret).CommitContext(c, "session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldCookies := responseCookies(rec)
+
+	cs := New([]byte("new-secret"), secret)
+	c, _ = newContext(oldCookies)
+	b, found, err := cs.FindContext(c, "session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+	if bytes.Equal(b, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("encoded_data"))
+	}
+
+	// New data cookies are signed with the new secret only.
+	c, rec = newContext(nil)
+	err = cs.CommitContext(c, "session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCookies := responseCookies(rec)
+
+	c, _ = newContext(newCookies)
+	_, found, err = New(secret).FindContext(c, "session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+
+	c, _ = newContext(newCookies)
+	_, found, err = New([]byte("new-secret")).FindContext(c, "session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	cs := New(secret)
+
+	c, rec := newContext(nil)
+	err := cs.CommitContext(c, "session_token", []byte("encoded_data"), time.Now().Add(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(101 * time.Millisecond)
+
+	c, _ = newContext(responseCookies(rec))
+	_, found, err := cs.FindContext(c, "session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestChunking(t *testing.T) {
+	cs := New(secret)
+	want := randomBytes(t, 8000)
+
+	c, rec := newContext(nil)
+	err := cs.CommitContext(c, "session_token", want, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := responseCookies(rec)
+	if len(cookies) != 3 {
+		t.Fatalf("got %d: expected %d", len(cookies), 3)
+	}
+	for _, v := range rec.Header()["Set-Cookie"] {
+		if len(v) > DefaultMaxCookieSize {
+			t.Fatalf("got %d: expected at most %d", len(v), DefaultMaxCookieSize)
+		}
+	}
+
+	c, _ = newContext(cookies)
+	b, found, err := cs.FindContext(c, "session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+	if bytes.Equal(b, want) == false {
+		t.Fatal("got different data: expected the committed data")
+	}
+
+	// A missing chunk means the data is not found.
+	c, _ = newContext(cookies[:2])
+	_, found, err = cs.FindContext(c, "session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+
+	// Committing less data expires the chunks which are no longer needed.
+	c, rec = newContext(cookies)
+	err = cs.CommitContext(c, "session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies = responseCookies(rec)
+	if len(cookies) != 3 {
+		t.Fatalf("got %d: expected %d", len(cookies), 3)
+	}
+	for _, cookie := range cookies[1:] {
+		if cookie.MaxAge != -1 {
+			t.Fatalf("got %v: expected %v", cookie.MaxAge, -1)
+		}
+	}
+}
+
+func TestStaleChunks(t *testing.T) {
+	cs := New(secret)
+
+	c, rec := newContext(nil)
+	err := cs.CommitContext(c, "session_token", randomBytes(t, 8000), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := responseCookies(rec)
+
+	// The chunks to expire are found from the count in the first data cookie,
+	// even if the request is missing one of the others.
+	c, rec = newContext([]*http.Cookie{cookies[0], cookies[2]})
+	err = cs.CommitContext(c, "session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := make(map[string]bool)
+	for _, cookie := range responseCookies(rec) {
+		expired[cookie.Name] = cookie.MaxAge == -1
+	}
+	for name, want := range map[string]bool{"session_0": false, "session_1": true, "session_2": true} {
+		if got, ok := expired[name]; !ok || got != want {
+			t.Errorf("%s: got %v, %v: expected expired %v", name, got, ok, want)
+		}
+	}
+
+	// Growing and then shrinking the data in the same response expires the
+	// chunks written by the first commit.
+	c, rec = newContext(nil)
+	err = cs.CommitContext(c, "session_token", randomBytes(t, 8000), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cs.CommitContext(c, "session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Later cookies replace earlier ones with the same name.
+	final := make(map[string]*http.Cookie)
+	for _, cookie := range responseCookies(rec) {
+		final[cookie.Name] = cookie
+	}
+	if len(final) != 3 {
+		t.Fatalf("got %d: expected %d", len(final), 3)
+	}
+	if final["session_0"].MaxAge < 0 {
+		t.Fatalf("got %v: expected an unexpired cookie", final["session_0"])
+	}
+	for _, name := range []string{"session_1", "session_2"} {
+		if final[name].MaxAge != -1 {
+			t.Fatalf("got %v: expected %v", final[name].MaxAge, -1)
+		}
+	}
+
+	c, _ = newContext([]*http.Cookie{final["session_0"]})
+	b, found, err := cs.FindContext(c, "session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true || string(b) != "encoded_data" {
+		t.Fatalf("got %q, %v: expected %q, %v", b, found, "encoded_data", true)
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	cs := New(secret)
+	cs.MaxSize = 8000
+
+	c, rec := newContext(nil)
+	err := cs.CommitContext(c, "session_token", randomBytes(t, 8000), time.Now().Add(time.Minute))
+	if err != ErrTooLarge {
+		t.Fatalf("got %v: expected %v", err, ErrTooLarge)
+	}
+	if len(rec.Header()["Set-Cookie"]) != 0 {
+		t.Fatalf("got %v: expected no cookies", rec.Header()["Set-Cookie"])
+	}
+}
+
+func TestMaxCookieSize(t *testing.T) {
+	cs := New(secret)
+	cs.Cookie.Domain = "sessions.example.com"
+	cs.Cookie.Path = "/" + strings.Repeat("p", 200)
+	cs.Cookie.Secure = true
+	cs.MaxCookieSize = 1000
+
+	// Each data cookie fits within MaxCookieSize, including its attributes.
+	c, rec := newContext(nil)
+	want := randomBytes(t, 4000)
+	err := cs.CommitContext(c, "session_token", want, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range rec.Header()["Set-Cookie"] {
+		if len(v) > cs.MaxCookieSize {
+			t.Fatalf("got %d: expected at most %d", len(v), cs.MaxCookieSize)
+		}
+	}
+
+	c, _ = newContext(responseCookies(rec))
+	b, found, err := cs.FindContext(c, "session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true || bytes.Equal(b, want) == false {
+		t.Fatalf("got %v: expected the committed data", found)
+	}
+
+	// A limit too small for the cookie attributes fails.
+	cs.MaxCookieSize = 200
+	c, rec = newContext(nil)
+	err = cs.CommitContext(c, "session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != ErrTooLarge {
+		t.Fatalf("got %v: expected %v", err, ErrTooLarge)
+	}
+	if len(rec.Header()["Set-Cookie"]) != 0 {
+		t.Fatalf("got %v: expected no cookies", rec.Header()["Set-Cookie"])
+	}
+}
+
+func TestDelete(t *testing.T) {
+	cs := New(secret)
+
+	c, rec := newContext(nil)
+	err := cs.CommitContext(c, "session_token", randomBytes(t, 8000), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, rec = newContext(responseCookies(rec))
+	err = cs.DeleteContext(c, "session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookies := responseCookies(rec)
+	if len(cookies) != 3 {
+		t.Fatalf("got %d: expected %d", len(cookies), 3)
+	}
+	for _, cookie := range cookies {
+		if cookie.MaxAge != -1 {
+			t.Fatalf("got %v: expected %v", cookie.MaxAge, -1)
+		}
+	}
+}
+
+// bareContext is a SessionContext with no response header.
+type bareContext map[string]interface{}
+
+func (c bareContext) Get(key string) interface{}               { return c[key] }
+func (c bareContext) Set(key string, val interface{})          { c[key] = val }
+func (c bareContext) Cookie(name string) (*http.Cookie, error) { return nil, http.ErrNoCookie }
+
+func TestNoResponseHeader(t *testing.T) {
+	cs := New(secret)
+
+	c := bareContext{}
+	err := cs.CommitContext(c, "session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != scs.ErrNoResponseHeader {
+		t.Fatalf("got %v: expected %v", err, scs.ErrNoResponseHeader)
+	}
+	err = cs.DeleteContext(c, "session_token")
+	if err != scs.ErrNoResponseHeader {
+		t.Fatalf("got %v: expected %v", err, scs.ErrNoResponseHeader)
+	}
+}
+
+func TestSession(t *testing.T) {
+	s := scs.NewSession()
+	s.Store = New(secret)
+
+	c, rec := newContext(nil)
+	if err := s.LoadCheck(c); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(c, "foo", "bar")
+	if err := s.SaveCheck(c); err != nil {
+		t.Fatal(err)
+	}
+
+	// Renewing the token must not expire the data cookies just written.
+	c, rec = newContext(responseCookies(rec))
+	if err := s.LoadCheck(c); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RenewToken(c); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveCheck(c); err != nil {
+		t.Fatal(err)
+	}
+	cookies := responseCookies(rec)
+	for _, cookie := range cookies {
+		if cookie.MaxAge < 0 {
+			t.Fatalf("got %v: expected an unexpired cookie", cookie)
+		}
+	}
+
+	c, _ = newContext(cookies)
+	if err := s.LoadCheck(c); err != nil {
+		t.Fatal(err)
+	}
+	if s.GetString(c, "foo") != "bar" {
+		t.Fatalf("got %q: expected %q", s.GetString(c, "foo"), "bar")
+	}
+}
@@ -1,24 +1,112 @@
 package scs
 
 import (
-	"bytes"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
-	"encoding/gob"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/labstack/echo/v4"
+	"io"
 	"net/http"
+	"reflect"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
-// This interface matches the `Get` and `Set` found in echo.Context.
+// This interface matches the `Get`, `Set` and `Cookie` found in echo.Context.
+//
+// Session headers (eg Set-Cookie) are written to the response of an
+// echo.Context. Non-echo frameworks should implement HeaderWriterContext as
+// well so that headers can be written; otherwise writing the session cookie
+// fails with ErrNoResponseHeader.
 type SessionContext interface {
 	Get(key string) interface{}
 	Set(key string, val interface{})
 	Cookie(name string) (*http.Cookie, error)
-	Response() *echo.Response
+}
+
+// HeaderWriter is the part of a response used to write session headers.
+type HeaderWriter interface {
+	Add(key, value string)
+	Values(key string) []string
+}
+
+// HeaderWriterContext is implemented by a SessionContext which does not
+// expose an *echo.Response, such as an adapter for fasthttp/fiber.
+type HeaderWriterContext interface {
+	HeaderWriter() HeaderWriter
+}
+
+// httpHeader adapts an http.Header to HeaderWriter.
+type httpHeader http.Header
+
+func (h httpHeader) Add(key, value string) {
+	http.Header(h).Add(key, value)
+}
+
+func (h httpHeader) Values(key string) []string {
+	return http.Header(h)[http.CanonicalHeaderKey(key)]
+}
+
+// ErrNoResponseHeader is returned when the session needs to write a response
+// header, such as the session cookie, and the SessionContext is neither an
+// echo.Context nor a HeaderWriterContext.
+var ErrNoResponseHeader = errors.New("scs: no response header writer in context")
+
+// ResponseHeader returns the HeaderWriter for the response in c, or
+// ErrNoResponseHeader if c is neither an echo.Context nor a
+// HeaderWriterContext. It is exported for use by session stores which write
+// their own cookies (see ContextStore).
+func ResponseHeader(c SessionContext) (HeaderWriter, error) {
+	switch rc := c.(type) {
+	case HeaderWriterContext:
+		return rc.HeaderWriter(), nil
+	case interface{ Response() *echo.Response }:
+		return httpHeader(rc.Response().Header()), nil
+	}
+	return nil, ErrNoResponseHeader
+}
+
+// Keys with reservedKeyPrefix hold internal scs state. They are stored and
+// persisted with the other session values but are hidden from Keys.
+const reservedKeyPrefix = "__scs."
+
+const (
+	rememberMeKey = reservedKeyPrefix + "rememberMe"
+	stableIDKey   = reservedKeyPrefix + "stableID"
+
+	// lastActiveKey keeps its original name so the last active time of
+	// sessions stored by earlier versions is still read.
+	lastActiveKey = reservedKeyPrefix + "refreshed"
+
+	// The per-session overrides are stored as int64 nanoseconds, which gob
+	// can encode without registering time.Duration.
+	idleTimeoutKey = reservedKeyPrefix + "idleTimeout"
+	lifetimeKey    = reservedKeyPrefix + "lifetime"
+
+	bindingKey = reservedKeyPrefix + "binding"
+
+	// seenKey holds the keys which have been read with Peek, as a []string.
+	seenKey = reservedKeyPrefix + "seen"
+
+	csrfKey = reservedKeyPrefix + "csrf"
+
+	// userKey holds the user ID set by RegisterUserSession.
+	userKey = reservedKeyPrefix + "user"
+
+	// lastRotatedKey holds the time the session token was last rotated, as
+	// int64 nanoseconds, when a RotateInterval is used.
+	lastRotatedKey = reservedKeyPrefix + "lastRotated"
+)
+
+func isReservedKey(key string) bool {
+	return strings.HasPrefix(key, reservedKeyPrefix)
 }
 
 // Status represents the state of the session data during a request cycle.
@@ -38,12 +126,71 @@ const (
 	Destroyed
 )
 
+// String returns the name of the status, such as "Modified".
+func (st Status) String() string {
+	switch st {
+	case Unmodified:
+		return "Unmodified"
+	case Modified:
+		return "Modified"
+	case Destroyed:
+		return "Destroyed"
+	}
+	return "Status(" + strconv.Itoa(int(st)) + ")"
+}
+
 type sessionData struct {
 	Deadline time.Time // Exported for gob encoding.
 	status   Status
 	token    string
 	Values   map[string]interface{} // Exported for gob encoding.
 	mu       sync.Mutex
+
+	// deferredDeletes holds keys which are removed from Values when the
+	// session data is next committed (see PopDeferred).
+	deferredDeletes map[string]struct{}
+
+	// encoded caches the encoded session data as loaded from (or last
+	// committed to) the store, so a session which has only been touched can
+	// be re-committed without re-encoding. It is nil after any change.
+	encoded []byte
+
+	// renewedFrom holds the token (and deadline) which was replaced by
+	// RenewToken. The old token is deleted from the store only after the
+	// new token has been committed successfully.
+	renewedFrom         string
+	renewedFromDeadline time.Time
+
+	// previousToken holds the token the session had before RenewToken was
+	// first called in the current request cycle. Unlike renewedFrom, it is
+	// kept after the commit (see PreviousToken).
+	previousToken string
+
+	// version is the version of the session data in a VersionedStore, as
+	// loaded from (or last committed to) the store. It is 0 for a session
+	// which has not been stored.
+	version int64
+
+	// indexedToken is the token under which the session has been added to
+	// the index of its user (see RegisterUserSession).
+	indexedToken string
+
+	// dirty holds the keys which have been put or deleted since the session
+	// data was loaded or last committed (see DirtyKeys).
+	dirty map[string]struct{}
+
+	// lastActive is the time the session data was last committed while an
+	// idle timeout was in use. It is persisted in Values under lastActiveKey
+	// whenever the session data is encoded, and is zero if it is unknown.
+	lastActive time.Time
+
+	// isNew is true if the session was created in the current request cycle,
+	// rather than loaded from the store with its token (see IsNew).
+	isNew bool
+
+	// unlock releases the lock on the session token taken by LoadCheck when
+	// Session.SerializeByToken is set (see Release).
+	unlock func()
 }
 
 func (sd *sessionData) Token() string {
@@ -54,17 +201,52 @@ func (sd *sessionData) SetStatus(status Status) {
 	sd.status = status
 }
 
-func newSessionData(lifetime time.Duration) *sessionData {
+// unmarkSeen removes key from the keys which have been read with Peek. The
+// caller must hold the lock.
+func (sd *sessionData) unmarkSeen(key string) {
+	seen, _ := sd.Values[seenKey].([]string)
+	for i, k := range seen {
+		if k == key {
+			rest := append(append([]string(nil), seen[:i]...), seen[i+1:]...)
+			if len(rest) == 0 {
+				delete(sd.Values, seenKey)
+			} else {
+				sd.Values[seenKey] = rest
+			}
+			return
+		}
+	}
+}
+
+// markModified sets the status to Modified after a change to the session
+// data, discarding the cached encoding.
+func (sd *sessionData) markModified() {
+	sd.status = Modified
+	sd.encoded = nil
+}
+
+// markDirty records that the value for key has been put or deleted. The
+// caller must hold the lock.
+func (sd *sessionData) markDirty(key string) {
+	if sd.dirty == nil {
+		sd.dirty = make(map[string]struct{})
+	}
+	sd.dirty[key] = struct{}{}
+}
+
+func newSessionData(now time.Time, lifetime time.Duration) *sessionData {
 	return &sessionData{
-		Deadline: time.Now().Add(lifetime).UTC(),
+		Deadline: now.Add(lifetime).UTC(),
 		status:   Unmodified,
 		Values:   make(map[string]interface{}),
+		isNew:    true,
 	}
 }
 
 // Load retrieves the session data for the given token from the session store,
 // and returns a new context.Context containing the session data. If no matching
-// token is found then this will create a new session.
+// token is found then this will create a new session. The same happens if the
+// data found can't be decoded, unless StrictDecode is set.
 //
 // Most applications will use the LoadAndSave() middleware and will not need to
 // use this method.
@@ -77,237 +259,1523 @@ func (s *Session) Load(c SessionContext, token string) (*sessionData, error) {
 		}
 	}
 
-	if token == "" {
-		sd := newSessionData(s.Lifetime)
-		c.Set(string(s.contextKey), sd)
+	start := time.Now()
+	sd, err := s.load(c, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if sd.token != "" {
+		s.metrics().IncLoaded()
+	}
+	s.logger().Debug("scs: session loaded", "token", tokenPrefix(sd.token), "new", sd.token == "", "duration", time.Since(start))
+	if s.OnLoad != nil {
+		// The session data is not shared yet, so no lock is needed.
+		s.OnLoad(sd.token, sd.token == "")
+	}
+	return sd, nil
+}
+
+func (s *Session) load(c SessionContext, token string) (*sessionData, error) {
+	// Reserved tokens hold internal scs data, such as the user index.
+	if token == "" || isReservedKey(token) {
+		sd := newSessionData(s.clock().Now(), s.Lifetime)
+		s.addSessionDataToContext(c, sd)
 		return sd, nil
 	}
 
-	b, found, err := s.Store.Find(token)
+	b, version, found, err := s.storeFind(c, token)
 	if err != nil {
 		return nil, err
-	} else if !found {
-		sd := newSessionData(s.Lifetime)
-		c.Set(string(s.contextKey), sd)
+	}
+	if found {
+		b, found = s.stripDataHeader(b)
+	}
+	if !found {
+		sd := newSessionData(s.clock().Now(), s.Lifetime)
+		s.addSessionDataToContext(c, sd)
 		return sd, nil
 	}
 
 	sd := &sessionData{
-		status: Unmodified,
-		token:  token,
+		status:  Unmodified,
+		token:   token,
+		version: version,
+	}
+	sd.Deadline, sd.Values, err = s.Codec.Decode(b)
+	if err != nil {
+		if s.StrictDecode {
+			return nil, err
+		}
+		return s.discardUndecodable(c, token, err)
+	}
+	if sd.Values == nil {
+		sd.Values = make(map[string]interface{})
+	}
+	sd.encoded = b
+	if _, ok := sd.Values[userKey]; ok {
+		sd.indexedToken = token
+	}
+	if lastActive, ok := sd.Values[lastActiveKey].(int64); ok {
+		sd.lastActive = time.Unix(0, lastActive)
+	}
+
+	// Enforce the absolute expiry, in case the store returned data which has
+	// outlived its deadline (e.g. a store without expiry support).
+	if sd.Deadline.Before(s.clock().Now()) {
+		err = s.storeDelete(c, token)
+		if err != nil {
+			return nil, err
+		}
+		sd = newSessionData(s.clock().Now(), s.Lifetime)
+	}
+
+	s.addSessionDataToContext(c, sd)
+	return sd, nil
+}
+
+// discardUndecodable deletes the session data for token, which couldn't be
+// decoded because of decodeErr, and starts a new session in its place.
+func (s *Session) discardUndecodable(c SessionContext, token string, decodeErr error) (*sessionData, error) {
+	s.logger().Error("scs: session data could not be decoded and was discarded", "token", tokenPrefix(token), "error", decodeErr)
+
+	err := s.storeDelete(c, token)
+	if err != nil {
+		return nil, err
+	}
+
+	sd := newSessionData(s.clock().Now(), s.Lifetime)
+	s.addSessionDataToContext(c, sd)
+	return sd, nil
+}
+
+// Commit saves the session data to the session store and returns the session
+// token and expiry time. ErrSessionTooLarge is returned if the encoded data is
+// longer than MaxEncodedSize.
+//
+// Most applications will use the LoadAndSave() middleware and will not need to
+// use this method.
+func (s *Session) Commit(c SessionContext) (string, time.Time, error) {
+	start := time.Now()
+	token, expiry, err := s.commit(c)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	s.logger().Debug("scs: session committed", "token", tokenPrefix(token), "expiry", expiry, "duration", time.Since(start))
+
+	if s.OnCommit != nil {
+		s.OnCommit(token, expiry)
+	}
+	return token, expiry, nil
+}
+
+func (s *Session) commit(c SessionContext) (string, time.Time, error) {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	created := sd.token == ""
+	if created {
+		var err error
+		sd.token, err = s.newToken()
+		if err != nil {
+			return "", time.Time{}, err
+		}
+	}
+
+	for key := range sd.deferredDeletes {
+		delete(sd.Values, key)
+	}
+	sd.deferredDeletes = nil
+
+	// The last active time is recorded whenever the data is encoded, but a
+	// touched session reuses the cached encoding unless a RefreshInterval
+	// needs it to be up to date.
+	now := s.clock().Now()
+	idleTimeout := s.idleTimeout(sd)
+	if idleTimeout > 0 && (sd.encoded == nil || s.RefreshInterval > 0) {
+		sd.Values[lastActiveKey] = now.UnixNano()
+		sd.encoded = nil
+	}
+	if _, ok := sd.Values[lastRotatedKey]; s.RotateInterval > 0 && !ok {
+		sd.Values[lastRotatedKey] = now.UnixNano()
+		sd.encoded = nil
+	}
+
+	expiry := s.expiryAt(sd, now)
+
+	// Unchanged data which is already stored under the token only needs its
+	// expiry extending, which a TouchableStore can do without rewriting it.
+	b := sd.encoded
+	if b != nil && !created && sd.renewedFrom == "" {
+		touched, err := s.storeTouch(sd.token, expiry)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		if touched {
+			if idleTimeout > 0 {
+				sd.lastActive = now
+			}
+			s.metrics().IncCommitted()
+			if err := s.indexUserSession(sd); err != nil {
+				return "", time.Time{}, err
+			}
+			return sd.token, expiry, nil
+		}
+	}
+
+	if b == nil {
+		var err error
+		b, err = s.Codec.Encode(sd.Deadline, sd.Values)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+	}
+	if s.MaxEncodedSize > 0 && len(b) > s.MaxEncodedSize {
+		return "", time.Time{}, ErrSessionTooLarge
+	}
+
+	// A new or renewed token has not been stored, whatever the version of
+	// the data under the old token.
+	version := sd.version
+	if created || sd.renewedFrom != "" {
+		version = 0
+	}
+
+	version, err := s.storeCommit(c, sd.token, s.addDataHeader(b), expiry, version)
+	if err != nil {
+		if sd.renewedFrom != "" {
+			// Roll back the renewal so the session is still usable under
+			// the old token, which has not been deleted from the store.
+			if sd.previousToken == sd.renewedFrom {
+				sd.previousToken = ""
+			}
+			sd.token = sd.renewedFrom
+			sd.Deadline = sd.renewedFromDeadline
+			sd.renewedFrom = ""
+		}
+		return "", time.Time{}, err
+	}
+	sd.encoded = b
+	sd.version = version
+	sd.dirty = nil
+	if idleTimeout > 0 {
+		sd.lastActive = now
+	}
+
+	s.metrics().IncCommitted()
+	if created {
+		s.metrics().IncCreated()
+	}
+
+	if sd.renewedFrom != "" {
+		err = s.retireToken(c, sd.renewedFrom, sd.renewedFromDeadline, now)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		sd.renewedFrom = ""
+	}
+
+	err = s.indexUserSession(sd)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return sd.token, expiry, nil
+}
+
+// retireToken removes the data stored under a token replaced by RenewToken
+// once the session has been committed under its new token. If a
+// RenewGracePeriod is set, the stored data is instead re-committed to expire
+// at the end of the grace period, or at deadline if that is sooner.
+func (s *Session) retireToken(c SessionContext, token string, deadline time.Time, now time.Time) error {
+	if s.clientSideStore() || s.RenewGracePeriod <= 0 {
+		return s.storeDelete(c, token)
+	}
+
+	b, version, found, err := s.storeFind(c, token)
+	if err != nil || !found {
+		return err
+	}
+	expiry := now.Add(s.RenewGracePeriod)
+	if deadline.Before(expiry) {
+		expiry = deadline
+	}
+	_, err = s.storeCommit(c, token, b, expiry, version)
+	if err == ErrConcurrentModification {
+		// The old token was committed again by another request; leave it.
+		return nil
+	}
+	return err
+}
+
+// Touch marks the session data to be re-committed to the session store with
+// a new expiry time, extending the idle timeout (a sliding window) without
+// changing any values. The session data status will be set to Modified, but
+// if no values have changed Commit reuses the cached encoding of the session
+// data rather than re-encoding it. (It is re-encoded when a RefreshInterval is
+// set, to record the last active time.)
+//
+// Touch is a no-op, at no cost, when no idle timeout is being used or the
+// session has not been stored yet, as there is no expiry to extend. SaveCheck
+// calls Touch for Unmodified sessions when NeedsRefresh returns true.
+func (s *Session) Touch(c SessionContext) {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	if s.idleTimeout(sd) <= 0 || sd.token == "" || sd.status != Unmodified {
+		return
+	}
+	sd.status = Modified
+}
+
+// NeedsRefresh returns true if the session data has a token and should be
+// re-committed to refresh its idle timeout: an idle timeout is being used,
+// and either no RefreshInterval is set or the remaining idle time has dropped
+// below it. It is used by SaveCheck for Unmodified sessions.
+func (s *Session) NeedsRefresh(c SessionContext) bool {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	idleTimeout := s.idleTimeout(sd)
+	if idleTimeout <= 0 || sd.token == "" {
+		return false
+	}
+	if s.RefreshInterval <= 0 || sd.lastActive.IsZero() {
+		return true
+	}
+	return sd.lastActive.Add(idleTimeout).Sub(s.clock().Now()) < s.RefreshInterval
+}
+
+// DeadlineLocal returns the absolute deadline of the session (see Lifetime)
+// in the local time zone, for display purposes. The deadline is held and
+// stored in UTC, and all expiry checks compare instants, so the time zone
+// does not affect when the session expires.
+func (s *Session) DeadlineLocal(c SessionContext) time.Time {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	return sd.Deadline.Local()
+}
+
+// ExpiryTime returns the time at which the session will expire if it is
+// committed now: the earlier of its absolute deadline and the end of its idle
+// timeout. This is the expiry which Commit would use for the session store
+// and the session cookie, so it can be used to tell the client how long the
+// session is valid for without committing it.
+func (s *Session) ExpiryTime(c SessionContext) time.Time {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	return s.expiry(sd)
+}
+
+// LastActive returns the time the session was last committed while an idle
+// timeout was in use. The zero time is returned if the session has not been
+// committed with an idle timeout, or was last stored without recording it.
+//
+// A session which has only been touched (see Touch) is re-committed without
+// updating the stored time, unless a RefreshInterval is set, so after it is
+// next loaded LastActive reports the last commit which changed its data.
+func (s *Session) LastActive(c SessionContext) time.Time {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	return sd.lastActive
+}
+
+// IdleExpiry returns the time at which the session will expire if there is no
+// further activity: its last active time plus the idle timeout, capped at its
+// absolute deadline. If no idle timeout is being used this is the deadline
+// itself, and if the last active time is unknown the session is treated as
+// active now. Unlike ExpiryTime, it does not assume that the session will be
+// committed again.
+func (s *Session) IdleExpiry(c SessionContext) time.Time {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	lastActive := sd.lastActive
+	if lastActive.IsZero() {
+		lastActive = s.clock().Now()
+	}
+	return s.expiryAt(sd, lastActive)
+}
+
+// expiry returns the store expiry time for the session data if it is
+// committed now.
+func (s *Session) expiry(sd *sessionData) time.Time {
+	return s.expiryAt(sd, s.clock().Now())
+}
+
+// expiryAt returns the expiry time for the session data if it was last active
+// at the given time, which is the earlier of its deadline and the end of the
+// idle timeout.
+func (s *Session) expiryAt(sd *sessionData, lastActive time.Time) time.Time {
+	expiry := sd.Deadline
+	if idleTimeout := s.idleTimeout(sd); idleTimeout > 0 {
+		ie := lastActive.Add(idleTimeout)
+		if ie.Before(expiry) {
+			expiry = ie
+		}
+	}
+	return expiry
+}
+
+// idleTimeout returns the idle timeout for the session data: the override set
+// by SetIdleTimeout, or the IdleTimeout field. The session data lock must be
+// held.
+func (s *Session) idleTimeout(sd *sessionData) time.Duration {
+	if d, ok := sd.Values[idleTimeoutKey].(int64); ok {
+		return time.Duration(d)
+	}
+	return s.IdleTimeout
+}
+
+// lifetime returns the lifetime for the session data: the override set by
+// SetLifetime, or the Lifetime field. The session data lock must be held.
+func (s *Session) lifetime(sd *sessionData) time.Duration {
+	if d, ok := sd.Values[lifetimeKey].(int64); ok {
+		return time.Duration(d)
+	}
+	return s.Lifetime
+}
+
+// Destroy deletes the session data from the session store and sets the session
+// status to Destroyed. Any futher operations in the same request cycle will
+// result in a new session being created.
+func (s *Session) Destroy(c SessionContext) error {
+	token, err := s.destroy(c)
+	if err != nil {
+		return err
+	}
+
+	s.metrics().IncDestroyed()
+	s.logger().Debug("scs: session destroyed", "token", tokenPrefix(token))
+	if s.OnDestroy != nil {
+		s.OnDestroy(token)
+	}
+	return nil
+}
+
+// destroy destroys the session data and returns the token it had.
+func (s *Session) destroy(c SessionContext) (string, error) {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	token := sd.token
+	err := s.storeDelete(c, sd.token)
+	if err != nil {
+		return "", err
+	}
+	if sd.renewedFrom != "" {
+		err = s.storeDelete(c, sd.renewedFrom)
+		if err != nil {
+			return "", err
+		}
+		sd.renewedFrom = ""
+	}
+	sd.previousToken = ""
+
+	sd.status = Destroyed
+	sd.encoded = nil
+
+	// Reset everything else to defaults.
+	sd.token = ""
+	sd.version = 0
+	sd.Deadline = s.clock().Now().Add(s.Lifetime).UTC()
+	for key := range sd.Values {
+		delete(sd.Values, key)
+	}
+	sd.deferredDeletes = nil
+	sd.dirty = nil
+
+	return token, nil
+}
+
+// EncodedSize returns the length in bytes of the session data encoded by the
+// Codec, as it would be checked against MaxEncodedSize by Commit, so that a
+// handler can check the size before adding more data. The length is
+// approximate, as Commit may add internal values (such as the last active
+// time) before encoding the data.
+func (s *Session) EncodedSize(c SessionContext) (int, error) {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	values := sd.Values
+	if len(sd.deferredDeletes) > 0 {
+		values = make(map[string]interface{}, len(sd.Values))
+		for key, val := range sd.Values {
+			if _, ok := sd.deferredDeletes[key]; !ok {
+				values[key] = val
+			}
+		}
 	}
-	err = sd.decode(b)
+
+	b, err := s.Codec.Encode(sd.Deadline, values)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Put adds a key and corresponding value to the session data. Any existing
+// value for the key will be replaced. The session data status will be set to
+// Modified. If AutoRegisterGob is set, the type of val is registered with gob.
+func (s *Session) Put(c SessionContext, key string, val interface{}) {
+	sd := s.getSessionDataFromContext(c)
+	s.autoRegister(val)
+
+	sd.mu.Lock()
+	oldVal := sd.Values[key]
+	sd.Values[key] = val
+	delete(sd.deferredDeletes, key)
+	sd.unmarkSeen(key)
+	sd.markDirty(key)
+	sd.markModified()
+	sd.mu.Unlock()
+
+	s.keyChanged(key, oldVal, val)
+}
+
+// PutAll adds all of the given keys and corresponding values to the session
+// data, replacing any existing values for the keys. The values are added under
+// a single lock, so concurrent requests for the session never see only some
+// of them. The session data status will be set to Modified.
+func (s *Session) PutAll(c SessionContext, values map[string]interface{}) {
+	sd := s.getSessionDataFromContext(c)
+	for _, val := range values {
+		s.autoRegister(val)
+	}
+
+	oldVals := make(map[string]interface{}, len(values))
+
+	sd.mu.Lock()
+	for key, val := range values {
+		oldVals[key] = sd.Values[key]
+		sd.Values[key] = val
+		delete(sd.deferredDeletes, key)
+		sd.unmarkSeen(key)
+		sd.markDirty(key)
+	}
+	sd.markModified()
+	sd.mu.Unlock()
+
+	for key, val := range values {
+		s.keyChanged(key, oldVals[key], val)
+	}
+}
+
+// WithValues calls fn with the session data values while holding the session
+// data lock, so a group of changes (including ones which depend on the
+// current values, such as incrementing a counter) is made atomically and no
+// other goroutine sees an intermediate state. fn may add, replace and delete
+// keys in the map, but must not keep a reference to it after returning, and
+// must not call other Session methods for c, which would deadlock. Keys
+// reserved for internal use by scs (prefixed "__scs.") are in the map too and
+// should be left alone. The session data status will be set to Modified, and
+// keys which fn added, replaced or deleted are marked as dirty.
+func (s *Session) WithValues(c SessionContext, fn func(values map[string]interface{})) {
+	sd := s.getSessionDataFromContext(c)
+
+	type change struct {
+		key            string
+		oldVal, newVal interface{}
+	}
+	var changes []change
+
+	func() {
+		sd.mu.Lock()
+		defer sd.mu.Unlock()
+
+		oldVals := make(map[string]interface{}, len(sd.Values))
+		for key, val := range sd.Values {
+			oldVals[key] = val
+		}
+
+		fn(sd.Values)
+
+		for key, val := range sd.Values {
+			if oldVal, ok := oldVals[key]; isReservedKey(key) || (ok && reflect.DeepEqual(oldVal, val)) {
+				continue
+			}
+			changes = append(changes, change{key: key, oldVal: oldVals[key], newVal: val})
+		}
+		for key, oldVal := range oldVals {
+			if _, ok := sd.Values[key]; isReservedKey(key) || ok {
+				continue
+			}
+			changes = append(changes, change{key: key, oldVal: oldVal})
+		}
+
+		for _, ch := range changes {
+			if _, ok := sd.Values[ch.key]; ok {
+				delete(sd.deferredDeletes, ch.key)
+				sd.unmarkSeen(ch.key)
+			}
+			sd.markDirty(ch.key)
+		}
+		sd.markModified()
+	}()
+
+	for _, ch := range changes {
+		s.keyChanged(ch.key, ch.oldVal, ch.newVal)
+	}
+}
+
+// Peek returns the value for a given key from the session data like Get, and
+// also reports whether this is the first time the value has been read with
+// Peek. The value is not deleted, but the key is marked as seen and the
+// session data status will be set to Modified on the first read, so the mark
+// is persisted with the session. Putting a new value for the key clears the
+// mark. This is useful for flash messages which should survive a page reload
+// but only be highlighted once. If the key is not present, Peek returns nil
+// and false.
+func (s *Session) Peek(c SessionContext, key string) (val interface{}, firstSeen bool) {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	val, exists := sd.Values[key]
+	if !exists {
+		return nil, false
+	}
+
+	seen, _ := sd.Values[seenKey].([]string)
+	for _, k := range seen {
+		if k == key {
+			return val, false
+		}
+	}
+	sd.Values[seenKey] = append(seen, key)
+	sd.markModified()
+
+	return val, true
+}
+
+// Get returns the value for a given key from the session data. The return
+// value has the type interface{} so will usually need to be type asserted
+// before you can use it. For example:
+//
+//	foo, ok := session.Get(r, "foo").(string)
+//	if !ok {
+//		return errors.New("type assertion to string failed")
+//	}
+//
+// Also see the GetString(), GetInt(), GetBytes() and other helper methods which
+// wrap the type conversion for common types.
+func (s *Session) Get(c SessionContext, key string) interface{} {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	return sd.Values[key]
+}
+
+// GetOK returns the value for a given key from the session data and a flag
+// reporting whether the key was present, in the same way as a map lookup with
+// the comma-ok idiom. Unlike Get, this lets you tell a stored nil value apart
+// from a key which has not been set.
+func (s *Session) GetOK(c SessionContext, key string) (interface{}, bool) {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	val, ok := sd.Values[key]
+	return val, ok
+}
+
+// GetInto stores the value for a given key from the session data in the
+// value pointed to by dest, and reports whether the key was present. It is a
+// checked alternative to type asserting the result of Get: if the stored
+// value can't be assigned to the type dest points to, an error is returned
+// and dest is left unchanged. A stored nil value sets dest to its zero value.
+// The dest parameter must be a non-nil pointer, such as &obj for a struct.
+func (s *Session) GetInto(c SessionContext, key string, dest interface{}) (bool, error) {
+	rv := reflect.ValueOf(dest)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return false, fmt.Errorf("scs: GetInto destination for key %q must be a non-nil pointer, got %T", key, dest)
+	}
+
+	val, ok := s.GetOK(c, key)
+	if !ok {
+		return false, nil
+	}
+
+	elem := rv.Elem()
+	if val == nil {
+		elem.Set(reflect.Zero(elem.Type()))
+		return true, nil
+	}
+	vv := reflect.ValueOf(val)
+	if !vv.Type().AssignableTo(elem.Type()) {
+		return true, fmt.Errorf("scs: session value for key %q has type %T which can't be assigned to %v", key, val, elem.Type())
+	}
+	elem.Set(vv)
+	return true, nil
+}
+
+// Pop acts like a one-time Get. It returns the value for a given key from the
+// session data and deletes the key and value from the session data. The
+// session data status will be set to Modified. The return value has the type
+// interface{} so will usually need to be type asserted before you can use it.
+func (s *Session) Pop(c SessionContext, key string) interface{} {
+	val, _ := s.PopOK(c, key)
+	return val
+}
+
+// PopOK acts like Pop, also reporting whether the key was present and so
+// removed, in the same way as a map lookup with the comma-ok idiom. Popping
+// a key which is not present leaves the session data unchanged, and its
+// status is not set to Modified.
+func (s *Session) PopOK(c SessionContext, key string) (interface{}, bool) {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	val, exists := sd.Values[key]
+	if !exists {
+		sd.mu.Unlock()
+		return nil, false
+	}
+	delete(sd.Values, key)
+	sd.markDirty(key)
+	sd.markModified()
+	sd.mu.Unlock()
+
+	s.keyChanged(key, val, nil)
+
+	return val, true
+}
+
+// PopDeferred acts like Pop, except that the key and value are not deleted
+// from the session data until it is next committed. This means the value can
+// still be read with Get (or PopDeferred) for the rest of the current request.
+// The session data status will be set to Modified if the key exists. Putting a
+// new value for the key before the commit cancels the deletion.
+func (s *Session) PopDeferred(c SessionContext, key string) interface{} {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	val, exists := sd.Values[key]
+	if !exists {
+		sd.mu.Unlock()
+		return nil
+	}
+	if sd.deferredDeletes == nil {
+		sd.deferredDeletes = make(map[string]struct{})
+	}
+	sd.deferredDeletes[key] = struct{}{}
+	sd.markDirty(key)
+	sd.markModified()
+	sd.mu.Unlock()
+
+	s.keyChanged(key, val, nil)
+
+	return val
+}
+
+// Remove deletes the given key and corresponding value from the session data.
+// The session data status will be set to Modified. If the key is not present
+// this operation is a no-op.
+func (s *Session) Remove(c SessionContext, key string) {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	val, exists := sd.Values[key]
+	if !exists {
+		sd.mu.Unlock()
+		return
+	}
+
+	delete(sd.Values, key)
+	sd.markDirty(key)
+	sd.markModified()
+	sd.mu.Unlock()
+
+	s.keyChanged(key, val, nil)
+}
+
+// Clear deletes all keys and corresponding values from the session data. The
+// session data status will be set to Modified. Unlike Destroy, the session
+// token and deadline are unchanged, so the next Commit stores the emptied
+// session data under the same token. Keys reserved for internal use by scs
+// (such as the StableID and RememberMe setting) are retained.
+func (s *Session) Clear(c SessionContext) {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	cleared := make(map[string]interface{})
+	for key, val := range sd.Values {
+		if isReservedKey(key) {
+			continue
+		}
+		cleared[key] = val
+		delete(sd.Values, key)
+		sd.markDirty(key)
+	}
+	sd.deferredDeletes = nil
+	sd.markModified()
+	sd.mu.Unlock()
+
+	for key, val := range cleared {
+		s.keyChanged(key, val, nil)
+	}
+}
+
+// Exists returns true if the given key is present in the session data.
+func (s *Session) Exists(c SessionContext, key string) bool {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	_, exists := sd.Values[key]
+	sd.mu.Unlock()
+
+	return exists
+}
+
+// Keys returns a slice of all key names present in the session data, sorted
+// alphabetically. If the data contains no data then an empty slice will be
+// returned. Keys reserved for internal use by scs are not included.
+func (s *Session) Keys(c SessionContext) []string {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	keys := make([]string, 0, len(sd.Values))
+	for key := range sd.Values {
+		if isReservedKey(key) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sd.mu.Unlock()
+
+	sort.Strings(keys)
+	return keys
+}
+
+// Len returns the number of keys present in the session data, the same as
+// len(s.Keys(c)) but without allocating or sorting a slice of the keys. Keys
+// reserved for internal use by scs are not counted.
+func (s *Session) Len(c SessionContext) int {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	n := 0
+	for key := range sd.Values {
+		if !isReservedKey(key) {
+			n++
+		}
+	}
+	return n
+}
+
+// DirtyKeys returns a slice of the key names which have been put or deleted
+// (with Put, Pop, Remove, Clear and their variants) since the session data
+// was loaded or last committed, sorted alphabetically. The slice is empty
+// after a successful Commit. Putting a value identical to the stored one
+// still marks the key as dirty. Keys reserved for internal use by scs are
+// not included. This is useful for observing which parts of a large session
+// change, and is the basis for stores which write only changed keys.
+func (s *Session) DirtyKeys(c SessionContext) []string {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	keys := make([]string, 0, len(sd.dirty))
+	for key := range sd.dirty {
+		if isReservedKey(key) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sd.mu.Unlock()
+
+	sort.Strings(keys)
+	return keys
+}
+
+// DebugString returns a one-line description of the session data for use in
+// log lines and test failures, such as
+//
+//	token=0b8f2a… deadline=2024-05-01T12:00:00Z status=Modified keys=[cart([]string) userID(int64)]
+//
+// Only a prefix of the token and the types of the values are included, never
+// the values themselves, so the output is safe to log. Keys reserved for
+// internal use by scs are not included.
+func (s *Session) DebugString(c SessionContext) string {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	keys := make([]string, 0, len(sd.Values))
+	for key := range sd.Values {
+		if isReservedKey(key) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		keys[i] = fmt.Sprintf("%s(%T)", key, sd.Values[key])
+	}
+
+	token := tokenPrefix(sd.token)
+	if token != "" {
+		token += "…"
+	}
+
+	return fmt.Sprintf("token=%s deadline=%s status=%v keys=[%s]", token, sd.Deadline.Format(time.RFC3339), sd.status, strings.Join(keys, " "))
+}
+
+// GetAll returns a copy of all key/value pairs in the session data, taken
+// under a single lock so it is a consistent snapshot. Changes to the returned
+// map (or to []byte values in it, which are also copied) do not affect the
+// session data, but other reference values such as maps, slices and pointers
+// are shared with the session data and must not be modified. Keys reserved
+// for internal use by scs are not included.
+func (s *Session) GetAll(c SessionContext) map[string]interface{} {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	values := make(map[string]interface{}, len(sd.Values))
+	for key, val := range sd.Values {
+		if isReservedKey(key) {
+			continue
+		}
+		if b, ok := val.([]byte); ok {
+			val = append([]byte(nil), b...)
+		}
+		values[key] = val
+	}
+	return values
+}
+
+// Encode stores the exported fields of v, which must be a struct or a pointer
+// to a struct, in the session data as if by PutAll. Each field is stored under
+// the key given by its `session:"key"` struct tag, or under the field name if
+// it has no tag; fields tagged `session:"-"` are skipped. The field values are
+// encoded with the session data on commit, so with the default GobCodec any
+// custom field types must be registered with gob.Register.
+func (s *Session) Encode(c SessionContext, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("scs: Encode requires a struct or a pointer to a struct but got %T", v)
+	}
+
+	values := make(map[string]interface{})
+	for _, f := range sessionFields(rv.Type()) {
+		values[f.key] = rv.Field(f.index).Interface()
+	}
+	s.PutAll(c, values)
+	return nil
+}
+
+// Decode sets the exported fields of the struct pointed to by v from the
+// session data, using the same keys as Encode. Fields whose key is not in the
+// session data are set to their zero value, and session keys without a
+// matching field are ignored. An error is returned if a value cannot be
+// assigned to its field.
+func (s *Session) Decode(c SessionContext, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("scs: Decode requires a non-nil pointer to a struct but got %T", v)
+	}
+	rv = rv.Elem()
+
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	for _, f := range sessionFields(rv.Type()) {
+		field := rv.Field(f.index)
+		val, exists := sd.Values[f.key]
+		if !exists || val == nil {
+			field.Set(reflect.Zero(field.Type()))
+			continue
+		}
+
+		vv := reflect.ValueOf(val)
+		switch {
+		case vv.Type().AssignableTo(field.Type()):
+			field.Set(vv)
+		case vv.Kind() == field.Kind() && vv.Type().ConvertibleTo(field.Type()):
+			// For example a string value for a field of a named string type.
+			field.Set(vv.Convert(field.Type()))
+		default:
+			return fmt.Errorf("scs: cannot decode session key %q of type %T into field %s of type %s", f.key, val, rv.Type().Field(f.index).Name, field.Type())
+		}
+	}
+	return nil
+}
+
+// sessionField is a struct field used by Encode and Decode.
+type sessionField struct {
+	key   string
+	index int
+}
+
+func sessionFields(t reflect.Type) []sessionField {
+	var fields []sessionField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue // Unexported.
+		}
+		key := f.Tag.Get("session")
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = f.Name
+		}
+		fields = append(fields, sessionField{key: key, index: i})
+	}
+	return fields
+}
+
+// Fingerprint returns a hash of the current session values which is suitable
+// for use as an ETag for responses that depend on session state. It is stable
+// across requests while the values are unchanged, and changes when any value
+// is added, changed or removed. The session token and deadline are not part
+// of the fingerprint, so renewing the token does not change it.
+//
+// The encoded session bytes are not hashed directly, because gob encodes maps
+// in a random order (and EncryptedCodec uses a random nonce). Instead the
+// values are hashed in key order using a deterministic representation (see
+// writeFingerprint).
+func (s *Session) Fingerprint(c SessionContext) (string, error) {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	keys := make([]string, 0, len(sd.Values))
+	for key := range sd.Values {
+		// The last active time changes without any change to the values.
+		if key == lastActiveKey || key == lastRotatedKey {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(h, "%q=", key)
+		writeFingerprint(h, reflect.ValueOf(sd.Values[key]), make(map[uintptr]bool))
+		io.WriteString(h, "\n")
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// writeFingerprint writes a representation of v to w which doesn't change
+// when v is encoded and decoded by the session codec. Pointers are followed
+// rather than printed, map entries are sorted, times are written in UTC
+// without their location and only exported struct fields are included. seen
+// holds the pointers being followed, so that cycles are only written once.
+func writeFingerprint(w io.Writer, v reflect.Value, seen map[uintptr]bool) {
+	if !v.IsValid() {
+		io.WriteString(w, "nil")
+		return
+	}
+	if v.Type() == timeType && v.CanInterface() {
+		fmt.Fprintf(w, "time(%s)", v.Interface().(time.Time).UTC().Format(time.RFC3339Nano))
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			io.WriteString(w, "nil")
+			return
+		}
+		if seen[v.Pointer()] {
+			io.WriteString(w, "cycle")
+			return
+		}
+		seen[v.Pointer()] = true
+		defer delete(seen, v.Pointer())
+		io.WriteString(w, "&")
+		writeFingerprint(w, v.Elem(), seen)
+	case reflect.Interface:
+		writeFingerprint(w, v.Elem(), seen)
+	case reflect.Struct:
+		fmt.Fprintf(w, "%s{", v.Type())
+		for i := 0; i < v.NumField(); i++ {
+			// Unexported fields are not encoded by the codec.
+			if f := v.Type().Field(i); f.PkgPath == "" {
+				fmt.Fprintf(w, "%s:", f.Name)
+				writeFingerprint(w, v.Field(i), seen)
+				io.WriteString(w, ",")
+			}
+		}
+		io.WriteString(w, "}")
+	case reflect.Map:
+		entries := make([]string, 0, v.Len())
+		for _, key := range v.MapKeys() {
+			var sb strings.Builder
+			writeFingerprint(&sb, key, seen)
+			sb.WriteString(":")
+			writeFingerprint(&sb, v.MapIndex(key), seen)
+			entries = append(entries, sb.String())
+		}
+		sort.Strings(entries)
+		fmt.Fprintf(w, "%s{%s}", v.Type(), strings.Join(entries, ","))
+	case reflect.Slice, reflect.Array:
+		// A nil slice is written like an empty one, as gob doesn't
+		// distinguish them.
+		fmt.Fprintf(w, "%s[", v.Type())
+		for i := 0; i < v.Len(); i++ {
+			writeFingerprint(w, v.Index(i), seen)
+			io.WriteString(w, ",")
+		}
+		io.WriteString(w, "]")
+	case reflect.String:
+		fmt.Fprintf(w, "%s(%q)", v.Type(), v.String())
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		fmt.Fprintf(w, "%s", v.Type())
+	default:
+		fmt.Fprintf(w, "%s(%v)", v.Type(), v)
+	}
+}
+
+// Merge copies the values from the session data stored under otherToken into
+// the current session data, then deletes otherToken from the session store.
+// Keys which already exist in the current session data are left unchanged; use
+// MergeOverwrite to replace them instead. This is useful for carrying an
+// anonymous session (e.g. a shopping cart) over to the session created at
+// login. The session data status will be set to Modified. If otherToken is not
+// found in the store, Merge is a no-op and returns nil.
+func (s *Session) Merge(c SessionContext, otherToken string) error {
+	return s.merge(c, otherToken, false)
+}
+
+// MergeOverwrite acts like Merge, except that values from the session data
+// stored under otherToken replace any existing values for the same keys.
+func (s *Session) MergeOverwrite(c SessionContext, otherToken string) error {
+	return s.merge(c, otherToken, true)
+}
+
+func (s *Session) merge(c SessionContext, otherToken string, overwrite bool) error {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	token := sd.token
+	sd.mu.Unlock()
+	if otherToken == "" || otherToken == token || isReservedKey(otherToken) {
+		return nil
+	}
+
+	b, _, found, err := s.storeFind(c, otherToken)
+	if err != nil {
+		return err
+	}
+	if found {
+		b, found = s.stripDataHeader(b)
+	}
+	if !found {
+		return nil
+	}
+	_, values, err := s.Codec.Decode(b)
+	if err != nil {
+		return err
+	}
+
+	type change struct {
+		key            string
+		oldVal, newVal interface{}
+	}
+	var changes []change
+
+	sd.mu.Lock()
+	for key, val := range values {
+		// Internal state such as the StableID belongs to each session.
+		if isReservedKey(key) {
+			continue
+		}
+		oldVal, exists := sd.Values[key]
+		if exists && !overwrite {
+			continue
+		}
+		sd.Values[key] = val
+		delete(sd.deferredDeletes, key)
+		sd.markDirty(key)
+		changes = append(changes, change{key, oldVal, val})
+	}
+	sd.markModified()
+	sd.mu.Unlock()
+
+	for _, ch := range changes {
+		s.keyChanged(ch.key, ch.oldVal, ch.newVal)
+	}
+
+	return s.storeDelete(c, otherToken)
+}
+
+// RenewToken updates the session data to have a new session token while
+// retaining the current session data. The session lifetime is also reset and
+// the session data status will be set to Modified.
+//
+// The old session token and accompanying data are deleted from the session
+// store, but only once the session data has been committed successfully under
+// the new token. If that commit fails, the session data reverts to the old
+// token (and deadline) so the session is not lost. Set RenewGracePeriod to
+// keep the old token valid for a short while instead.
+//
+// To mitigate the risk of session fixation attacks, it's important that you call
+// RenewToken before making any changes to privilege levels (e.g. login and
+// logout operations). See https://github.com/OWASP/CheatSheetSeries/blob/master/cheatsheets/Session_Management_Cheat_Sheet.md#renew-the-session-id-after-any-privilege-level-change
+// for additional information.
+func (s *Session) RenewToken(c SessionContext) error {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	err := s.rotateToken(sd)
 	if err != nil {
-		return nil, err
-	}
-	// Mark the session data as modified if an idle timeout is being used. This
-	// will force the session data to be re-committed to the session store with
-	// a new expiry time.
-	if s.IdleTimeout > 0 {
-		sd.status = Modified
+		return err
 	}
+	sd.Deadline = s.clock().Now().Add(s.lifetime(sd)).UTC()
 
-	c.Set(string(s.contextKey), sd)
-	return sd, nil
+	return nil
 }
 
-// Commit saves the session data to the session store and returns the session
-// token and expiry time.
-//
-// Most applications will use the LoadAndSave() middleware and will not need to
-// use this method.
-func (s *Session) Commit(c SessionContext) (string, time.Time, error) {
+// RotateIfDue renews the session token if RotateInterval is set and at least
+// that long has passed since the token was last rotated (or the session was
+// created). Like RenewToken, the old token is deleted from the session store
+// once the session data has been committed under the new token, but the
+// session deadline is kept, so automatic rotation never extends the session
+// lifetime. LoadCheck calls RotateIfDue after loading the session data. It is
+// a no-op for a session which has not been stored yet.
+func (s *Session) RotateIfDue(c SessionContext) error {
+	if s.RotateInterval <= 0 {
+		return nil
+	}
+
 	sd := s.getSessionDataFromContext(c)
 
 	sd.mu.Lock()
 	defer sd.mu.Unlock()
 
 	if sd.token == "" {
-		var err error
-		sd.token, err = generateToken()
-		if err != nil {
-			return "", time.Time{}, err
-		}
+		return nil
+	}
+	// A session stored before RotateInterval was set is rotated straight
+	// away, which records the rotation time.
+	if last, ok := sd.Values[lastRotatedKey].(int64); ok && s.clock().Now().Sub(time.Unix(0, last)) < s.RotateInterval {
+		return nil
 	}
+	return s.rotateToken(sd)
+}
 
-	b, err := sd.encode()
+// rotateToken gives the session data a new token, keeping the replaced token
+// so it can be deleted from the store after the next commit. The session data
+// lock must be held.
+func (s *Session) rotateToken(sd *sessionData) error {
+	newToken, err := s.newToken()
 	if err != nil {
-		return "", time.Time{}, err
+		return err
 	}
 
-	expiry := sd.Deadline
-	if s.IdleTimeout > 0 {
-		ie := time.Now().Add(s.IdleTimeout)
-		if ie.Before(expiry) {
-			expiry = ie
+	// Only the token held in the store needs deleting, so keep the first
+	// replaced token if the token is renewed more than once before a commit.
+	if sd.renewedFrom == "" && sd.token != "" {
+		sd.renewedFrom = sd.token
+		sd.renewedFromDeadline = sd.Deadline
+		if sd.previousToken == "" {
+			sd.previousToken = sd.token
 		}
 	}
 
-	err = s.Store.Commit(sd.token, b, expiry)
-	if err != nil {
-		return "", time.Time{}, err
+	sd.token = newToken
+	if s.RotateInterval > 0 {
+		sd.Values[lastRotatedKey] = s.clock().Now().UnixNano()
 	}
+	sd.markModified()
 
-	return sd.token, expiry, nil
+	return nil
 }
 
-// Destroy deletes the session data from the session store and sets the session
-// status to Destroyed. Any futher operations in the same request cycle will
-// result in a new session being created.
-func (s *Session) Destroy(c SessionContext) error {
+// Renew rotates the session token, resets the absolute deadline to the
+// session lifetime and restarts the idle timeout in one step, which is useful
+// at a privilege elevation such as a re-authentication. Unlike RenewToken,
+// the old token is deleted from the session store immediately and any error
+// from the delete is returned, in which case the session is left unchanged.
+// The session data status will be set to Modified.
+func (s *Session) Renew(c SessionContext) error {
 	sd := s.getSessionDataFromContext(c)
 
 	sd.mu.Lock()
 	defer sd.mu.Unlock()
 
-	err := s.Store.Delete(sd.token)
+	newToken, err := s.newToken()
 	if err != nil {
 		return err
 	}
 
-	sd.status = Destroyed
+	// The token held in the store is the one from before any earlier call
+	// to RenewToken in this request cycle.
+	stored := sd.token
+	if sd.renewedFrom != "" {
+		stored = sd.renewedFrom
+	}
+	if stored != "" {
+		err = s.storeDelete(c, stored)
+		if err != nil {
+			return err
+		}
+		if sd.previousToken == "" {
+			sd.previousToken = stored
+		}
+	}
+	sd.renewedFrom = ""
 
-	// Reset everything else to defaults.
-	sd.token = ""
-	sd.Deadline = time.Now().Add(s.Lifetime).UTC()
-	for key := range sd.Values {
-		delete(sd.Values, key)
+	now := s.clock().Now()
+	sd.token = newToken
+	sd.Deadline = now.Add(s.lifetime(sd)).UTC()
+	if s.RotateInterval > 0 {
+		sd.Values[lastRotatedKey] = now.UnixNano()
 	}
+	sd.markModified()
 
 	return nil
 }
 
-// Put adds a key and corresponding value to the session data. Any existing
-// value for the key will be replaced. The session data status will be set to
-// Modified.
-func (s *Session) Put(c SessionContext, key string, val interface{}) {
+// PreviousToken returns the session token which was replaced by RenewToken in
+// the current request cycle, or an empty string if the token has not been
+// renewed (or the session was new when it was renewed). It remains available
+// after the session data has been committed, so middleware and stores which
+// track old tokens can react to the rotation specifically. If RenewToken is
+// called more than once, the token from before the first call is returned.
+func (s *Session) PreviousToken(c SessionContext) string {
 	sd := s.getSessionDataFromContext(c)
 
 	sd.mu.Lock()
-	sd.Values[key] = val
-	sd.status = Modified
+	defer sd.mu.Unlock()
+
+	return sd.previousToken
+}
+
+// SetIdleTimeout overrides the IdleTimeout for this session only, for example
+// to give administrator sessions a shorter idle timeout. A value of 0 disables
+// the idle timeout for the session. The override is stored with the session
+// data, so it applies to every later request for the session until the
+// session is destroyed. The session data status will be set to Modified.
+func (s *Session) SetIdleTimeout(c SessionContext, d time.Duration) {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	sd.Values[idleTimeoutKey] = int64(d)
+	sd.markModified()
 	sd.mu.Unlock()
 }
 
-// Get returns the value for a given key from the session data. The return
-// value has the type interface{} so will usually need to be type asserted
-// before you can use it. For example:
-//
-//	foo, ok := session.Get(r, "foo").(string)
-//	if !ok {
-//		return errors.New("type assertion to string failed")
-//	}
-//
-// Also see the GetString(), GetInt(), GetBytes() and other helper methods which
-// wrap the type conversion for common types.
-func (s *Session) Get(c SessionContext, key string) interface{} {
+// SetLifetime overrides the Lifetime for this session only. The session
+// deadline is reset to d from now, and later calls to RenewToken also use d.
+// The override is stored with the session data, so it applies to every later
+// request for the session until the session is destroyed. The session data
+// status will be set to Modified.
+func (s *Session) SetLifetime(c SessionContext, d time.Duration) {
 	sd := s.getSessionDataFromContext(c)
 
 	sd.mu.Lock()
-	defer sd.mu.Unlock()
+	sd.Values[lifetimeKey] = int64(d)
+	sd.Deadline = s.clock().Now().Add(d).UTC()
+	sd.markModified()
+	sd.mu.Unlock()
+}
 
-	return sd.Values[key]
+// Bind binds the session to a fingerprint of the client, such as a hash of
+// the User-Agent header, to help detect stolen session cookies. The
+// fingerprint is stored with the session data, and when BindValidator is set
+// LoadCheck destroys the session if the fingerprint for a later request does
+// not match. The fingerprint will usually come from BindValidator itself. The
+// session data status will be set to Modified.
+func (s *Session) Bind(c SessionContext, fingerprint string) {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	sd.Values[bindingKey] = fingerprint
+	sd.markModified()
+	sd.mu.Unlock()
 }
 
-// Pop acts like a one-time Get. It returns the value for a given key from the
-// session data and deletes the key and value from the session data. The
-// session data status will be set to Modified. The return value has the type
-// interface{} so will usually need to be type asserted before you can use it.
-func (s *Session) Pop(c SessionContext, key string) interface{} {
+// VerifyBinding destroys the session if it has been bound with Bind and the
+// fingerprint returned by BindValidator for the current request does not
+// match. The request then continues with a new, empty session rather than
+// failing. It is a no-op if BindValidator is not set, the session is not
+// bound, or c is not an echo.Context. It is called by LoadCheck.
+func (s *Session) VerifyBinding(c SessionContext) error {
+	if s.BindValidator == nil {
+		return nil
+	}
+	ec, ok := c.(echo.Context)
+	if !ok {
+		return nil
+	}
+
 	sd := s.getSessionDataFromContext(c)
 
 	sd.mu.Lock()
-	defer sd.mu.Unlock()
+	fingerprint, bound := sd.Values[bindingKey].(string)
+	sd.mu.Unlock()
 
-	val, exists := sd.Values[key]
-	if !exists {
+	if !bound || s.BindValidator(ec) == fingerprint {
 		return nil
 	}
-	delete(sd.Values, key)
-	sd.status = Modified
+	return s.Destroy(c)
+}
 
-	return val
+// RememberMe sets whether the session cookie for this session should be
+// persistent, overriding Cookie.Persist. When val is false the cookie is a
+// session cookie (no Expires or MaxAge) and is destroyed when the browser is
+// closed; when true it is persistent. The flag is stored with the session data
+// so it applies to every later response for the session. The session data
+// status will be set to Modified.
+func (s *Session) RememberMe(c SessionContext, val bool) {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	sd.Values[rememberMeKey] = val
+	sd.markModified()
+	sd.mu.Unlock()
 }
 
-// Remove deletes the given key and corresponding value from the session data.
-// The session data status will be set to Modified. If the key is not present
-// this operation is a no-op.
-func (s *Session) Remove(c SessionContext, key string) {
+// StableID returns a random identifier for the session which, unlike the
+// session token, is not changed by RenewToken. It is useful as a stable
+// analytics identifier that survives token rotation. The ID is generated and
+// stored in the session data the first time StableID is called (setting the
+// session data status to Modified), and is discarded by Destroy. An empty
+// string is returned if an ID could not be generated.
+func (s *Session) StableID(c SessionContext) string {
 	sd := s.getSessionDataFromContext(c)
 
 	sd.mu.Lock()
 	defer sd.mu.Unlock()
 
-	_, exists := sd.Values[key]
-	if !exists {
-		return
+	id, ok := sd.Values[stableIDKey].(string)
+	if ok {
+		return id
 	}
 
-	delete(sd.Values, key)
-	sd.status = Modified
+	id, err := generateToken(defaultTokenLength, Base64URL)
+	if err != nil {
+		return ""
+	}
+	sd.Values[stableIDKey] = id
+	sd.markModified()
+
+	return id
 }
 
-// Exists returns true if the given key is present in the session data.
-func (s *Session) Exists(c SessionContext, key string) bool {
+// CSRFToken returns a random token for protecting forms in the session
+// against cross-site request forgery. The token is generated and stored in
+// the session data on the first call, and the same token is returned for the
+// rest of the session's life (or until RotateCSRF is called). The session
+// status is set to Modified when a new token is generated. An empty string is
+// returned if a token could not be generated.
+func (s *Session) CSRFToken(c SessionContext) string {
 	sd := s.getSessionDataFromContext(c)
 
 	sd.mu.Lock()
-	_, exists := sd.Values[key]
-	sd.mu.Unlock()
+	defer sd.mu.Unlock()
 
-	return exists
+	token, ok := sd.Values[csrfKey].(string)
+	if ok {
+		return token
+	}
+	return sd.newCSRFToken()
 }
 
-// Keys returns a slice of all key names present in the session data, sorted
-// alphabetically. If the data contains no data then an empty slice will be
-// returned.
-func (s *Session) Keys(c SessionContext) []string {
+// ValidateCSRF reports whether submitted matches the session's CSRF token,
+// using a constant-time comparison. It returns false if no token has been
+// generated for the session.
+func (s *Session) ValidateCSRF(c SessionContext, submitted string) bool {
 	sd := s.getSessionDataFromContext(c)
 
 	sd.mu.Lock()
-	keys := make([]string, len(sd.Values))
-	i := 0
-	for key := range sd.Values {
-		keys[i] = key
-		i++
-	}
+	token, _ := sd.Values[csrfKey].(string)
 	sd.mu.Unlock()
 
-	sort.Strings(keys)
-	return keys
+	if token == "" || submitted == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(submitted)) == 1
 }
 
-// RenewToken updates the session data to have a new session token while
-// retaining the current session data. The session lifetime is also reset and
-// the session data status will be set to Modified.
-//
-// The old session token and accompanying data are deleted from the session store.
-//
-// To mitigate the risk of session fixation attacks, it's important that you call
-// RenewToken before making any changes to privilege levels (e.g. login and
-// logout operations). See https://github.com/OWASP/CheatSheetSeries/blob/master/cheatsheets/Session_Management_Cheat_Sheet.md#renew-the-session-id-after-any-privilege-level-change
-// for additional information.
-func (s *Session) RenewToken(c SessionContext) error {
+// RotateCSRF replaces the session's CSRF token with a new random token and
+// returns it, so that tokens can be used once per request. The session status
+// is set to Modified.
+func (s *Session) RotateCSRF(c SessionContext) string {
 	sd := s.getSessionDataFromContext(c)
 
 	sd.mu.Lock()
 	defer sd.mu.Unlock()
 
-	err := s.Store.Delete(sd.token)
+	return sd.newCSRFToken()
+}
+
+// newCSRFToken generates and stores a new CSRF token. The caller must hold
+// sd.mu.
+func (sd *sessionData) newCSRFToken() string {
+	token, err := generateToken(defaultTokenLength, Base64URL)
 	if err != nil {
-		return err
+		return ""
 	}
+	sd.Values[csrfKey] = token
+	sd.markModified()
 
-	newToken, err := generateToken()
-	if err != nil {
-		return err
+	return token
+}
+
+// persistCookie returns whether the session cookie should be persistent,
+// taking any RememberMe flag into account.
+func (s *Session) persistCookie(c SessionContext) bool {
+	sd, ok := c.Get(string(s.contextKey)).(*sessionData)
+	if !ok {
+		return s.requestCookie(c).Persist
 	}
 
-	sd.token = newToken
-	sd.Deadline = time.Now().Add(s.Lifetime).UTC()
-	sd.status = Modified
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
 
-	return nil
+	rememberMe, ok := sd.Values[rememberMeKey].(bool)
+	if !ok {
+		return s.requestCookie(c).Persist
+	}
+	return rememberMe
 }
 
 // Status returns the current status of the session data.
@@ -320,6 +1788,27 @@ func (s *Session) Status(c SessionContext) Status {
 	return sd.status
 }
 
+// IsDestroyed returns true if the session data has been destroyed in the
+// current request cycle. Handlers can use this to avoid setting new values on
+// a session after logout within the same request.
+func (s *Session) IsDestroyed(c SessionContext) bool {
+	return s.Status(c) == Destroyed
+}
+
+// IsNew returns true if the session was created in the current request cycle
+// because the request had no session token, or none matching a stored
+// session. It stays true after the session is committed, until the session is
+// loaded with its token in a later request. Handlers can use this to set
+// defaults or show a welcome message on the first visit.
+func (s *Session) IsNew(c SessionContext) bool {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	return sd.isNew
+}
+
 // GetString returns the string value for a given key from the session data.
 // The zero value for a string ("") is returned if the key does not exist or the
 // value could not be type asserted to a string.
@@ -380,6 +1869,34 @@ func (s *Session) GetBytes(c SessionContext, key string) []byte {
 	return b
 }
 
+// GetStringSlice returns the []string value for a given key from the session
+// data. The zero value for a slice (nil) is returned if the key does not exist
+// or could not be type asserted to []string. Composite types like this must be
+// registered with gob before they can be stored using the GobCodec; this
+// package registers []string itself, but a named type such as
+// "type Roles []string" must be registered with RegisterType.
+func (s *Session) GetStringSlice(c SessionContext, key string) []string {
+	val := s.Get(c, key)
+	ss, ok := val.([]string)
+	if !ok {
+		return nil
+	}
+	return ss
+}
+
+// GetStringMap returns the map[string]string value for a given key from the
+// session data. The zero value for a map (nil) is returned if the key does not
+// exist or could not be type asserted to map[string]string. As with
+// GetStringSlice, this package registers map[string]string with gob itself.
+func (s *Session) GetStringMap(c SessionContext, key string) map[string]string {
+	val := s.Get(c, key)
+	m, ok := val.(map[string]string)
+	if !ok {
+		return nil
+	}
+	return m
+}
+
 // GetTime returns the time.Time value for a given key from the session data. The
 // zero value for a time.Time object is returned if the key does not exist or the
 // value could not be type asserted to a time.Time. This can be tested with the
@@ -393,6 +1910,101 @@ func (s *Session) GetTime(c SessionContext, key string) time.Time {
 	return t
 }
 
+// GetStringD returns the string value for a given key from the session data.
+// The default value def is returned if the key does not exist or the value
+// could not be type asserted to a string.
+func (s *Session) GetStringD(c SessionContext, key string, def string) string {
+	str, ok := s.Get(c, key).(string)
+	if !ok {
+		return def
+	}
+	return str
+}
+
+// GetBoolD returns the bool value for a given key from the session data. The
+// default value def is returned if the key does not exist or the value could
+// not be type asserted to a bool.
+func (s *Session) GetBoolD(c SessionContext, key string, def bool) bool {
+	b, ok := s.Get(c, key).(bool)
+	if !ok {
+		return def
+	}
+	return b
+}
+
+// GetIntD returns the int value for a given key from the session data. The
+// default value def is returned if the key does not exist or the value could
+// not be type asserted to an int. Unlike GetInt, a stored 0 is returned as it
+// is rather than being indistinguishable from a missing key.
+func (s *Session) GetIntD(c SessionContext, key string, def int) int {
+	i, ok := s.Get(c, key).(int)
+	if !ok {
+		return def
+	}
+	return i
+}
+
+// GetFloatD returns the float64 value for a given key from the session data.
+// The default value def is returned if the key does not exist or the value
+// could not be type asserted to a float64.
+func (s *Session) GetFloatD(c SessionContext, key string, def float64) float64 {
+	f, ok := s.Get(c, key).(float64)
+	if !ok {
+		return def
+	}
+	return f
+}
+
+// GetTimeD returns the time.Time value for a given key from the session data.
+// The default value def is returned if the key does not exist or the value
+// could not be type asserted to a time.Time.
+func (s *Session) GetTimeD(c SessionContext, key string, def time.Time) time.Time {
+	t, ok := s.Get(c, key).(time.Time)
+	if !ok {
+		return def
+	}
+	return t
+}
+
+// GetBoolLenient returns the bool value for a given key from the session data.
+// Unlike GetBool, it also accepts string values (e.g. from external JSON
+// sources) which are parsed with strconv.ParseBool, so "true", "TRUE", "t" and
+// "1" are true while "false", "FALSE", "f" and "0" are false. The zero value
+// for a bool (false) is returned if the key does not exist, or the value is
+// neither a bool nor a parseable string.
+func (s *Session) GetBoolLenient(c SessionContext, key string) bool {
+	switch v := s.Get(c, key).(type) {
+	case bool:
+		return v
+	case string:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false
+		}
+		return b
+	}
+	return false
+}
+
+// GetIntLenient returns the int value for a given key from the session data.
+// Unlike GetInt, it also accepts string values (e.g. from external JSON
+// sources) containing a base 10 integer such as "42" or "-7", which are parsed
+// with strconv.Atoi. The zero value for an int (0) is returned if the key does
+// not exist, or the value is neither an int nor a parseable string.
+func (s *Session) GetIntLenient(c SessionContext, key string) int {
+	switch v := s.Get(c, key).(type) {
+	case int:
+		return v
+	case string:
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return 0
+		}
+		return i
+	}
+	return 0
+}
+
 // PopString returns the string value for a given key and then deletes it from the
 // session data. The session data status will be set to Modified. The zero
 // value for a string ("") is returned if the key does not exist or the value
@@ -458,6 +2070,32 @@ func (s *Session) PopBytes(c SessionContext, key string) []byte {
 	return b
 }
 
+// PopStringSlice returns the []string value for a given key and then deletes
+// it from the session data. The session data status will be set to Modified.
+// The zero value for a slice (nil) is returned if the key does not exist or
+// could not be type asserted to []string.
+func (s *Session) PopStringSlice(c SessionContext, key string) []string {
+	val := s.Pop(c, key)
+	ss, ok := val.([]string)
+	if !ok {
+		return nil
+	}
+	return ss
+}
+
+// PopStringMap returns the map[string]string value for a given key and then
+// deletes it from the session data. The session data status will be set to
+// Modified. The zero value for a map (nil) is returned if the key does not
+// exist or could not be type asserted to map[string]string.
+func (s *Session) PopStringMap(c SessionContext, key string) map[string]string {
+	val := s.Pop(c, key)
+	m, ok := val.(map[string]string)
+	if !ok {
+		return nil
+	}
+	return m
+}
+
 // PopTime returns the time.Time value for a given key and then deletes it from
 // the session data. The session data status will be set to Modified. The zero
 // value for a time.Time object is returned if the key does not exist or the
@@ -484,35 +2122,87 @@ func (s *Session) Token(c SessionContext) string {
 	return sd.token
 }
 
-func (s *Session) getSessionDataFromContext(c SessionContext) *sessionData {
+// keyChanged calls OnKeyChange if key is watched and its value has changed.
+// It must not be called while holding the session data lock.
+func (s *Session) keyChanged(key string, oldVal, newVal interface{}) {
+	if s.OnKeyChange == nil {
+		return
+	}
+	for _, k := range s.WatchKeys {
+		if k == key {
+			if !reflect.DeepEqual(oldVal, newVal) {
+				s.OnKeyChange(key, oldVal, newVal)
+			}
+			return
+		}
+	}
+}
+
+func (s *Session) addSessionDataToContext(c SessionContext, sd *sessionData) SessionContext {
+	c.Set(string(s.contextKey), sd)
+	return c
+}
+
+// Loaded reports whether session data has been loaded into c, for example by
+// LoadCheck. Handlers which may run outside the session middleware (such as
+// an error handler) can use it to check for a session before using it.
+func (s *Session) Loaded(c SessionContext) bool {
+	_, ok := s.lookupSessionData(c)
+	return ok
+}
+
+// lookupSessionData returns the session data in c, if any.
+func (s *Session) lookupSessionData(c SessionContext) (*sessionData, bool) {
 	sd, ok := c.Get(string(s.contextKey)).(*sessionData)
+	return sd, ok
+}
+
+// getSessionDataFromContext returns the session data in c. If no session data
+// has been loaded, a new empty session is added to c rather than panicking,
+// so the accessors return zero values and any changes create a new session.
+func (s *Session) getSessionDataFromContext(c SessionContext) *sessionData {
+	sd, ok := s.lookupSessionData(c)
 	if !ok {
-		panic("scs: no session data in context")
+		sd = newSessionData(s.clock().Now(), s.Lifetime)
+		s.addSessionDataToContext(c, sd)
 	}
 	return sd
 }
 
-func (sd *sessionData) encode() ([]byte, error) {
-	var b bytes.Buffer
-	err := gob.NewEncoder(&b).Encode(sd)
-	if err != nil {
-		return nil, err
-	}
+const (
+	defaultTokenLength = 32
+	minTokenLength     = 16
+)
 
-	return b.Bytes(), nil
-}
+// newToken returns a new session token from the TokenGenerator, or a random
+// token of TokenLength bytes if no generator is set.
+func (s *Session) newToken() (string, error) {
+	if s.TokenGenerator != nil {
+		return s.TokenGenerator()
+	}
 
-func (sd *sessionData) decode(b []byte) error {
-	r := bytes.NewReader(b)
-	return gob.NewDecoder(r).Decode(sd)
+	n := s.TokenLength
+	if n == 0 {
+		n = defaultTokenLength
+	}
+	if n < minTokenLength {
+		return "", fmt.Errorf("scs: TokenLength must be at least %d bytes but is %d", minTokenLength, n)
+	}
+	if err := s.TokenEncoding.Validate(); err != nil {
+		return "", err
+	}
+	return generateToken(n, s.TokenEncoding)
 }
 
-func generateToken() (string, error) {
-	b := make([]byte, 32)
+func generateToken(n int, encoding TokenEncoding) (string, error) {
+	b := make([]byte, n)
 	_, err := rand.Read(b)
 	if err != nil {
 		return "", err
 	}
+	if encoding == Hex {
+		return hex.EncodeToString(b), nil
+	}
 	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
@@ -2,27 +2,60 @@ package scs
 
 import (
 	"bytes"
-	"context"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
 	"reflect"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
 func TestSessionDataFromContext(t *testing.T) {
-	defer func() {
-		if r := recover(); r == nil {
-			t.Errorf("the code did not panic")
-		}
-	}()
-
 	s := NewSession()
-	s.getSessionDataFromContext(context.Background())
+	ctx := newTestContext()
+
+	if s.Loaded(ctx) {
+		t.Errorf("got %v: expected %v", s.Loaded(ctx), false)
+	}
+
+	// The accessors are safe to use without a loaded session.
+	if s.Get(ctx, "foo") != nil {
+		t.Errorf("got %v: expected %v", s.Get(ctx, "foo"), nil)
+	}
+	if s.GetString(ctx, "foo") != "" {
+		t.Errorf("got %q: expected %q", s.GetString(ctx, "foo"), "")
+	}
+	if s.Token(ctx) != "" {
+		t.Errorf("got %q: expected %q", s.Token(ctx), "")
+	}
+
+	s.Put(ctx, "foo", "bar")
+	if s.GetString(ctx, "foo") != "bar" {
+		t.Errorf("got %q: expected %q", s.GetString(ctx, "foo"), "bar")
+	}
+	if s.Status(ctx) != Modified {
+		t.Errorf("got %v: expected %v", s.Status(ctx), Modified)
+	}
+	if !s.Loaded(ctx) {
+		t.Errorf("got %v: expected %v", s.Loaded(ctx), true)
+	}
+
+	ctx = newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Loaded(ctx) {
+		t.Errorf("got %v: expected %v", s.Loaded(ctx), true)
+	}
 }
 
 func TestPut(t *testing.T) {
 	s := NewSession()
-	sd := newSessionData(time.Hour)
-	ctx := s.addSessionDataToContext(context.Background(), sd)
+	sd := newSessionData(time.Now(), time.Hour)
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
 
 	s.Put(ctx, "foo", "bar")
 
@@ -35,11 +68,98 @@ func TestPut(t *testing.T) {
 	}
 }
 
+func TestPutAll(t *testing.T) {
+	s := NewSession()
+	sd := newSessionData(time.Now(), time.Hour)
+	sd.Values["role"] = "guest"
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
+
+	values := map[string]interface{}{
+		"userID":    123,
+		"role":      "admin",
+		"loginTime": time.Now(),
+	}
+
+	// A concurrent reader sees either none or all of the new values.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			all := s.GetAll(ctx)
+			_, hasUserID := all["userID"]
+			_, hasLoginTime := all["loginTime"]
+			if hasUserID != hasLoginTime || hasUserID != (all["role"] == "admin") {
+				t.Errorf("got %v: expected all or none of the new values", all)
+				return
+			}
+		}
+	}()
+	s.PutAll(ctx, values)
+	<-done
+
+	if !reflect.DeepEqual(s.GetAll(ctx), values) {
+		t.Errorf("got %v: expected %v", s.GetAll(ctx), values)
+	}
+	if sd.status != Modified {
+		t.Errorf("got %v: expected %v", sd.status, "modified")
+	}
+}
+
+func TestWithValues(t *testing.T) {
+	s := NewSession()
+	sd := newSessionData(time.Now(), time.Hour)
+	sd.Values["n"] = 0
+	sd.Values["a"] = "unchanged"
+	sd.Values["b"] = "removed"
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
+
+	// Interleaved increments and reads never lose an update or see the two
+	// counters out of step.
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s.WithValues(ctx, func(values map[string]interface{}) {
+				n := values["n"].(int)
+				values["n"] = n + 1
+				values["m"] = n + 1
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			s.WithValues(ctx, func(values map[string]interface{}) {
+				if m, ok := values["m"]; ok && m != values["n"] {
+					t.Errorf("got %v: expected %v", m, values["n"])
+				}
+			})
+		}()
+	}
+	wg.Wait()
+
+	if n := s.GetInt(ctx, "n"); n != 50 {
+		t.Fatalf("got %d: expected %d", n, 50)
+	}
+	if sd.status != Modified {
+		t.Fatalf("got %v: expected %v", sd.status, Modified)
+	}
+
+	sd.dirty = nil
+	s.WithValues(ctx, func(values map[string]interface{}) {
+		values["a"] = "unchanged"
+		delete(values, "b")
+		values["c"] = true
+	})
+	if got := s.DirtyKeys(ctx); !reflect.DeepEqual(got, []string{"b", "c"}) {
+		t.Fatalf("got %v: expected %v", got, []string{"b", "c"})
+	}
+}
+
 func TestGet(t *testing.T) {
 	s := NewSession()
-	sd := newSessionData(time.Hour)
+	sd := newSessionData(time.Now(), time.Hour)
 	sd.Values["foo"] = "bar"
-	ctx := s.addSessionDataToContext(context.Background(), sd)
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
 
 	str, ok := s.Get(ctx, "foo").(string)
 	if !ok {
@@ -53,9 +173,9 @@ func TestGet(t *testing.T) {
 
 func TestPop(t *testing.T) {
 	s := NewSession()
-	sd := newSessionData(time.Hour)
+	sd := newSessionData(time.Now(), time.Hour)
 	sd.Values["foo"] = "bar"
-	ctx := s.addSessionDataToContext(context.Background(), sd)
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
 
 	str, ok := s.Pop(ctx, "foo").(string)
 	if !ok {
@@ -78,9 +198,9 @@ func TestPop(t *testing.T) {
 
 func TestRemove(t *testing.T) {
 	s := NewSession()
-	sd := newSessionData(time.Hour)
+	sd := newSessionData(time.Now(), time.Hour)
 	sd.Values["foo"] = "bar"
-	ctx := s.addSessionDataToContext(context.Background(), sd)
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
 
 	s.Remove(ctx, "foo")
 
@@ -93,11 +213,65 @@ func TestRemove(t *testing.T) {
 	}
 }
 
+func TestClear(t *testing.T) {
+	s := NewSession()
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	s.Put(ctx, "baz", 1)
+	id := s.StableID(ctx)
+
+	token, deadline, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	s.Clear(ctx)
+
+	if len(s.Keys(ctx)) != 0 {
+		t.Errorf("got %v: expected no keys", s.Keys(ctx))
+	}
+	if s.Status(ctx) != Modified {
+		t.Errorf("got %v: expected %v", s.Status(ctx), Modified)
+	}
+	if s.Token(ctx) != token {
+		t.Errorf("got %q: expected %q", s.Token(ctx), token)
+	}
+	if s.StableID(ctx) != id {
+		t.Errorf("got %q: expected %q", s.StableID(ctx), id)
+	}
+
+	newToken, newDeadline, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newToken != token {
+		t.Errorf("got %q: expected %q", newToken, token)
+	}
+	if !newDeadline.Equal(deadline) {
+		t.Errorf("got %v: expected %v", newDeadline, deadline)
+	}
+
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if s.Exists(ctx, "foo") {
+		t.Errorf("got %v: expected %v", s.Exists(ctx, "foo"), false)
+	}
+}
+
 func TestExists(t *testing.T) {
 	s := NewSession()
-	sd := newSessionData(time.Hour)
+	sd := newSessionData(time.Now(), time.Hour)
 	sd.Values["foo"] = "bar"
-	ctx := s.addSessionDataToContext(context.Background(), sd)
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
 
 	if !s.Exists(ctx, "foo") {
 		t.Errorf("got %v: expected %v", s.Exists(ctx, "foo"), true)
@@ -110,10 +284,10 @@ func TestExists(t *testing.T) {
 
 func TestKeys(t *testing.T) {
 	s := NewSession()
-	sd := newSessionData(time.Hour)
+	sd := newSessionData(time.Now(), time.Hour)
 	sd.Values["foo"] = "bar"
 	sd.Values["woo"] = "waa"
-	ctx := s.addSessionDataToContext(context.Background(), sd)
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
 
 	keys := s.Keys(ctx)
 	if !reflect.DeepEqual(keys, []string{"foo", "woo"}) {
@@ -121,11 +295,38 @@ func TestKeys(t *testing.T) {
 	}
 }
 
+func TestLen(t *testing.T) {
+	s := NewSession()
+	sd := newSessionData(time.Now(), time.Hour)
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
+
+	if n := s.Len(ctx); n != 0 {
+		t.Errorf("got %d: expected %d", n, 0)
+	}
+
+	s.Put(ctx, "foo", "bar")
+	s.Put(ctx, "woo", "waa")
+	s.Put(ctx, "foo", "baz")
+	s.RememberMe(ctx, true)
+	if n := s.Len(ctx); n != 2 {
+		t.Errorf("got %d: expected %d", n, 2)
+	}
+
+	s.Remove(ctx, "foo")
+	s.Remove(ctx, "missing")
+	if n := s.Len(ctx); n != 1 {
+		t.Errorf("got %d: expected %d", n, 1)
+	}
+	if n := s.Len(ctx); n != len(s.Keys(ctx)) {
+		t.Errorf("got %d: expected %d", n, len(s.Keys(ctx)))
+	}
+}
+
 func TestGetString(t *testing.T) {
 	s := NewSession()
-	sd := newSessionData(time.Hour)
+	sd := newSessionData(time.Now(), time.Hour)
 	sd.Values["foo"] = "bar"
-	ctx := s.addSessionDataToContext(context.Background(), sd)
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
 
 	str := s.GetString(ctx, "foo")
 	if str != "bar" {
@@ -140,9 +341,9 @@ func TestGetString(t *testing.T) {
 
 func TestGetBool(t *testing.T) {
 	s := NewSession()
-	sd := newSessionData(time.Hour)
+	sd := newSessionData(time.Now(), time.Hour)
 	sd.Values["foo"] = true
-	ctx := s.addSessionDataToContext(context.Background(), sd)
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
 
 	b := s.GetBool(ctx, "foo")
 	if b != true {
@@ -157,9 +358,9 @@ func TestGetBool(t *testing.T) {
 
 func TestGetInt(t *testing.T) {
 	s := NewSession()
-	sd := newSessionData(time.Hour)
+	sd := newSessionData(time.Now(), time.Hour)
 	sd.Values["foo"] = 123
-	ctx := s.addSessionDataToContext(context.Background(), sd)
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
 
 	i := s.GetInt(ctx, "foo")
 	if i != 123 {
@@ -174,9 +375,9 @@ func TestGetInt(t *testing.T) {
 
 func TestGetFloat(t *testing.T) {
 	s := NewSession()
-	sd := newSessionData(time.Hour)
+	sd := newSessionData(time.Now(), time.Hour)
 	sd.Values["foo"] = 123.456
-	ctx := s.addSessionDataToContext(context.Background(), sd)
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
 
 	f := s.GetFloat(ctx, "foo")
 	if f != 123.456 {
@@ -191,9 +392,9 @@ func TestGetFloat(t *testing.T) {
 
 func TestGetBytes(t *testing.T) {
 	s := NewSession()
-	sd := newSessionData(time.Hour)
+	sd := newSessionData(time.Now(), time.Hour)
 	sd.Values["foo"] = []byte("bar")
-	ctx := s.addSessionDataToContext(context.Background(), sd)
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
 
 	b := s.GetBytes(ctx, "foo")
 	if !bytes.Equal(b, []byte("bar")) {
@@ -210,9 +411,9 @@ func TestGetTime(t *testing.T) {
 	now := time.Now()
 
 	s := NewSession()
-	sd := newSessionData(time.Hour)
+	sd := newSessionData(time.Now(), time.Hour)
 	sd.Values["foo"] = now
-	ctx := s.addSessionDataToContext(context.Background(), sd)
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
 
 	tm := s.GetTime(ctx, "foo")
 	if tm != now {
@@ -225,11 +426,85 @@ func TestGetTime(t *testing.T) {
 	}
 }
 
+func TestGetStringSlice(t *testing.T) {
+	s := NewSession()
+	sd := newSessionData(time.Now(), time.Hour)
+	sd.Values["foo"] = []string{"read", "write"}
+	sd.Values["wrong"] = "read"
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
+
+	ss := s.GetStringSlice(ctx, "foo")
+	if !reflect.DeepEqual(ss, []string{"read", "write"}) {
+		t.Errorf("got %v: expected %v", ss, []string{"read", "write"})
+	}
+
+	ss = s.GetStringSlice(ctx, "baz")
+	if ss != nil {
+		t.Errorf("got %v: expected %v", ss, nil)
+	}
+
+	ss = s.GetStringSlice(ctx, "wrong")
+	if ss != nil {
+		t.Errorf("got %v: expected %v", ss, nil)
+	}
+
+	ss = s.PopStringSlice(ctx, "foo")
+	if !reflect.DeepEqual(ss, []string{"read", "write"}) {
+		t.Errorf("got %v: expected %v", ss, []string{"read", "write"})
+	}
+	if _, ok := sd.Values["foo"]; ok {
+		t.Errorf("got %v: expected %v", ok, false)
+	}
+	if sd.status != Modified {
+		t.Errorf("got %v: expected %v", sd.status, "modified")
+	}
+	if ss = s.PopStringSlice(ctx, "wrong"); ss != nil {
+		t.Errorf("got %v: expected %v", ss, nil)
+	}
+}
+
+func TestGetStringMap(t *testing.T) {
+	s := NewSession()
+	sd := newSessionData(time.Now(), time.Hour)
+	sd.Values["foo"] = map[string]string{"beta": "on"}
+	sd.Values["wrong"] = map[string]interface{}{"beta": "on"}
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
+
+	m := s.GetStringMap(ctx, "foo")
+	if !reflect.DeepEqual(m, map[string]string{"beta": "on"}) {
+		t.Errorf("got %v: expected %v", m, map[string]string{"beta": "on"})
+	}
+
+	m = s.GetStringMap(ctx, "baz")
+	if m != nil {
+		t.Errorf("got %v: expected %v", m, nil)
+	}
+
+	m = s.GetStringMap(ctx, "wrong")
+	if m != nil {
+		t.Errorf("got %v: expected %v", m, nil)
+	}
+
+	m = s.PopStringMap(ctx, "foo")
+	if !reflect.DeepEqual(m, map[string]string{"beta": "on"}) {
+		t.Errorf("got %v: expected %v", m, map[string]string{"beta": "on"})
+	}
+	if _, ok := sd.Values["foo"]; ok {
+		t.Errorf("got %v: expected %v", ok, false)
+	}
+	if sd.status != Modified {
+		t.Errorf("got %v: expected %v", sd.status, "modified")
+	}
+	if m = s.PopStringMap(ctx, "wrong"); m != nil {
+		t.Errorf("got %v: expected %v", m, nil)
+	}
+}
+
 func TestPopString(t *testing.T) {
 	s := NewSession()
-	sd := newSessionData(time.Hour)
+	sd := newSessionData(time.Now(), time.Hour)
 	sd.Values["foo"] = "bar"
-	ctx := s.addSessionDataToContext(context.Background(), sd)
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
 
 	str := s.PopString(ctx, "foo")
 	if str != "bar" {
@@ -253,8 +528,8 @@ func TestPopString(t *testing.T) {
 
 func TestStatus(t *testing.T) {
 	s := NewSession()
-	sd := newSessionData(time.Hour)
-	ctx := s.addSessionDataToContext(context.Background(), sd)
+	sd := newSessionData(time.Now(), time.Hour)
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
 
 	status := s.Status(ctx)
 	if status != Unmodified {
@@ -273,3 +548,2094 @@ func TestStatus(t *testing.T) {
 		t.Errorf("got %d: expected %d", status, Destroyed)
 	}
 }
+
+func TestIsDestroyed(t *testing.T) {
+	s := NewSession()
+	sd := newSessionData(time.Now(), time.Hour)
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
+
+	if s.IsDestroyed(ctx) {
+		t.Errorf("got %v: expected %v", s.IsDestroyed(ctx), false)
+	}
+
+	s.Put(ctx, "foo", "bar")
+	if s.IsDestroyed(ctx) {
+		t.Errorf("got %v: expected %v", s.IsDestroyed(ctx), false)
+	}
+
+	err := s.Destroy(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsDestroyed(ctx) {
+		t.Errorf("got %v: expected %v", s.IsDestroyed(ctx), true)
+	}
+}
+
+// failingStore wraps a Store and can be made to fail on Commit or Delete.
+type failingStore struct {
+	Store
+	failCommit bool
+	failDelete bool
+}
+
+func (f *failingStore) Commit(token string, b []byte, expiry time.Time) error {
+	if f.failCommit {
+		return errors.New("commit failed")
+	}
+	return f.Store.Commit(token, b, expiry)
+}
+
+func (f *failingStore) Delete(token string) error {
+	if f.failDelete {
+		return errors.New("delete failed")
+	}
+	return f.Store.Delete(token)
+}
+
+// versionedStore is an in-memory VersionedStore.
+type versionedStore struct {
+	mu    sync.Mutex
+	items map[string]versionedItem
+}
+
+type versionedItem struct {
+	b       []byte
+	expiry  time.Time
+	version int64
+}
+
+func (v *versionedStore) Find(token string) ([]byte, bool, error) {
+	b, _, found, err := v.FindVersion(token)
+	return b, found, err
+}
+
+func (v *versionedStore) FindVersion(token string) ([]byte, int64, bool, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	item, ok := v.items[token]
+	if !ok || time.Now().After(item.expiry) {
+		return nil, 0, false, nil
+	}
+	return item.b, item.version, true, nil
+}
+
+func (v *versionedStore) Commit(token string, b []byte, expiry time.Time) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.items[token] = versionedItem{b, expiry, v.items[token].version + 1}
+	return nil
+}
+
+func (v *versionedStore) CommitIfUnchanged(token string, b []byte, expiry time.Time, expectedVersion int64) (int64, bool, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	item, ok := v.items[token]
+	if ok && time.Now().After(item.expiry) {
+		ok = false
+	}
+	if (ok && item.version != expectedVersion) || (!ok && expectedVersion != 0) {
+		return 0, false, nil
+	}
+	v.items[token] = versionedItem{b, expiry, item.version + 1}
+	return item.version + 1, true, nil
+}
+
+func (v *versionedStore) Delete(token string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.items, token)
+	return nil
+}
+
+func TestConcurrentModification(t *testing.T) {
+	s := NewSession()
+	store := &versionedStore{items: make(map[string]versionedItem)}
+	s.Store = store
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	token, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two requests load the session, and the first commits a change.
+	ctx1, ctx2 := newTestContext(), newTestContext()
+	if _, err = s.Load(ctx1, token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Load(ctx2, token); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx1, "foo", "first")
+	if _, _, err = s.Commit(ctx1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second commit would overwrite the first, so it fails.
+	s.Put(ctx2, "foo", "second")
+	_, _, err = s.Commit(ctx2)
+	if err != ErrConcurrentModification {
+		t.Fatalf("got %v: expected %v", err, ErrConcurrentModification)
+	}
+
+	// The first request can keep committing with the version it committed.
+	s.Put(ctx1, "foo", "first again")
+	if _, _, err = s.Commit(ctx1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Retrying the second request with freshly loaded data succeeds.
+	ctx2 = newTestContext()
+	if _, err = s.Load(ctx2, token); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.GetString(ctx2, "foo"); got != "first again" {
+		t.Errorf("got %q: expected %q", got, "first again")
+	}
+	s.Put(ctx2, "foo", "second")
+	if err = s.RenewToken(ctx2); err != nil {
+		t.Fatal(err)
+	}
+	newToken, _, err := s.Commit(ctx2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := store.Find(token); found {
+		t.Errorf("got %v: expected %v", found, false)
+	}
+	if _, version, _, _ := store.FindVersion(newToken); version != 1 {
+		t.Errorf("got %d: expected %d", version, 1)
+	}
+}
+
+func TestRenewToken(t *testing.T) {
+	s := NewSession()
+	store := &failingStore{Store: s.Store}
+	s.Store = store
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	oldToken, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.RenewToken(ctx); err != nil {
+		t.Fatal(err)
+	}
+	newToken := s.Token(ctx)
+	if newToken == oldToken {
+		t.Fatalf("want tokens to be different")
+	}
+	if s.PreviousToken(ctx) != oldToken {
+		t.Errorf("got %q: expected %q", s.PreviousToken(ctx), oldToken)
+	}
+
+	// The old token is kept until the new one has been committed.
+	_, found, _ := store.Find(oldToken)
+	if !found {
+		t.Errorf("got %v: expected %v", found, true)
+	}
+
+	if _, _, err = s.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_, found, _ = store.Find(oldToken)
+	if found {
+		t.Errorf("got %v: expected %v", found, false)
+	}
+	_, found, _ = store.Find(newToken)
+	if !found {
+		t.Errorf("got %v: expected %v", found, true)
+	}
+
+	// The previous token is still exposed after the commit, and after
+	// renewing again.
+	if s.PreviousToken(ctx) != oldToken {
+		t.Errorf("got %q: expected %q", s.PreviousToken(ctx), oldToken)
+	}
+	if err = s.RenewToken(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s.PreviousToken(ctx) != oldToken {
+		t.Errorf("got %q: expected %q", s.PreviousToken(ctx), oldToken)
+	}
+
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, newToken); err != nil {
+		t.Fatal(err)
+	}
+	if s.PreviousToken(ctx) != "" {
+		t.Errorf("got %q: expected %q", s.PreviousToken(ctx), "")
+	}
+}
+
+func TestRenewTokenGracePeriod(t *testing.T) {
+	s := NewSession()
+	s.RenewGracePeriod = 10 * time.Second
+	store := s.Store.(ExpiryStore)
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "user", "alice")
+	oldToken, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, oldToken); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.RenewToken(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "user", "bob")
+	newToken, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The old token still resolves, to the data it had, until the end of the
+	// grace period.
+	_, expiry, found, err := store.FindWithExpiry(oldToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+	if d := time.Until(expiry); d < 9*time.Second || d > 10*time.Second {
+		t.Fatalf("got %v: expected about %v", d, 10*time.Second)
+	}
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, oldToken); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.GetString(ctx, "user"); got != "alice" {
+		t.Fatalf("got %q: expected %q", got, "alice")
+	}
+
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, newToken); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.GetString(ctx, "user"); got != "bob" {
+		t.Fatalf("got %q: expected %q", got, "bob")
+	}
+
+	// The grace period doesn't keep the old token beyond its deadline.
+	s.RenewGracePeriod = time.Hour
+	ctx = newTestContext()
+	sd, err := s.Load(ctx, newToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Minute)
+	sd.Deadline = deadline
+	if err = s.RenewToken(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = s.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_, expiry, found, _ = store.FindWithExpiry(newToken)
+	if found != true || expiry.Sub(deadline) > time.Second {
+		t.Fatalf("got %v, %v: expected %v, %v", expiry, found, deadline, true)
+	}
+}
+
+// contextStore is a ContextStore which keeps the session data on the server,
+// like pgxstore, rather than on the client.
+type contextStore struct {
+	Store
+}
+
+func (cs contextStore) FindContext(c SessionContext, token string) ([]byte, bool, error) {
+	return cs.Find(token)
+}
+
+func (cs contextStore) CommitContext(c SessionContext, token string, b []byte, expiry time.Time) error {
+	return cs.Commit(token, b, expiry)
+}
+
+func (cs contextStore) DeleteContext(c SessionContext, token string) error {
+	return cs.Delete(token)
+}
+
+func TestRenewTokenGracePeriodContextStore(t *testing.T) {
+	s := NewSession()
+	s.RenewGracePeriod = 10 * time.Second
+	store := s.Store
+	s.Store = contextStore{store}
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "user", "alice")
+	oldToken, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, oldToken); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.RenewToken(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = s.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A server-side ContextStore keeps the old token for the grace period.
+	_, found, err := store.Find(oldToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+}
+
+func TestRenew(t *testing.T) {
+	s := NewSession()
+	s.IdleTimeout = 10 * time.Minute
+	s.Lifetime = time.Hour
+	store := &failingStore{Store: s.Store}
+	s.Store = store
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	token, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Load the session as if it were near the end of its lifetime.
+	ctx = newTestContext()
+	sd, err := s.Load(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd.Deadline = time.Now().Add(time.Minute)
+
+	store.failDelete = true
+	if err = s.Renew(ctx); err == nil {
+		t.Fatal("expected an error")
+	}
+	if s.Token(ctx) != token {
+		t.Errorf("got %q: expected %q", s.Token(ctx), token)
+	}
+
+	store.failDelete = false
+	if err = s.Renew(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s.Token(ctx) == token {
+		t.Errorf("want tokens to be different")
+	}
+	if s.PreviousToken(ctx) != token {
+		t.Errorf("got %q: expected %q", s.PreviousToken(ctx), token)
+	}
+	if _, found, _ := store.Find(token); found {
+		t.Errorf("got %v: expected %v", found, false)
+	}
+	if s.Status(ctx) != Modified {
+		t.Errorf("got %v: expected %v", s.Status(ctx), Modified)
+	}
+
+	// The expiry is the refreshed idle window rather than the old deadline.
+	newToken, expiry, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newToken == token {
+		t.Errorf("want tokens to be different")
+	}
+	if d := time.Until(expiry); d < 9*time.Minute || d > 10*time.Minute {
+		t.Errorf("got %v: expected about %v", d, 10*time.Minute)
+	}
+	if d := time.Until(sd.Deadline); d < 59*time.Minute {
+		t.Errorf("got %v: expected about %v", d, time.Hour)
+	}
+	if got := s.GetString(ctx, "foo"); got != "bar" {
+		t.Errorf("got %q: expected %q", got, "bar")
+	}
+}
+
+func TestRenewTokenCommitFailure(t *testing.T) {
+	s := NewSession()
+	store := &failingStore{Store: s.Store}
+	s.Store = store
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	oldToken, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = s.RenewToken(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	store.failCommit = true
+	if _, _, err = s.Commit(ctx); err == nil {
+		t.Fatal("expected commit to fail")
+	}
+
+	if s.Token(ctx) != oldToken {
+		t.Errorf("got %q: expected %q", s.Token(ctx), oldToken)
+	}
+	if s.PreviousToken(ctx) != "" {
+		t.Errorf("got %q: expected %q", s.PreviousToken(ctx), "")
+	}
+
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, oldToken); err != nil {
+		t.Fatal(err)
+	}
+	if s.GetString(ctx, "foo") != "bar" {
+		t.Errorf("got %q: expected %q", s.GetString(ctx, "foo"), "bar")
+	}
+}
+
+func TestGetBoolLenient(t *testing.T) {
+	s := NewSession()
+	sd := newSessionData(time.Now(), time.Hour)
+	sd.Values["native"] = true
+	sd.Values["str"] = "true"
+	sd.Values["one"] = "1"
+	sd.Values["false"] = "false"
+	sd.Values["bad"] = "yes please"
+	sd.Values["int"] = 1
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
+
+	tests := map[string]bool{
+		"native":  true,
+		"str":     true,
+		"one":     true,
+		"false":   false,
+		"bad":     false,
+		"int":     false,
+		"missing": false,
+	}
+	for key, want := range tests {
+		if got := s.GetBoolLenient(ctx, key); got != want {
+			t.Errorf("%s: got %v: expected %v", key, got, want)
+		}
+	}
+
+	if s.GetBool(ctx, "str") != false {
+		t.Errorf("got %v: expected %v", s.GetBool(ctx, "str"), false)
+	}
+}
+
+func TestGetIntLenient(t *testing.T) {
+	s := NewSession()
+	sd := newSessionData(time.Now(), time.Hour)
+	sd.Values["native"] = 123
+	sd.Values["str"] = "42"
+	sd.Values["negative"] = "-7"
+	sd.Values["bad"] = "forty-two"
+	sd.Values["float"] = "4.2"
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
+
+	tests := map[string]int{
+		"native":   123,
+		"str":      42,
+		"negative": -7,
+		"bad":      0,
+		"float":    0,
+		"missing":  0,
+	}
+	for key, want := range tests {
+		if got := s.GetIntLenient(ctx, key); got != want {
+			t.Errorf("%s: got %v: expected %v", key, got, want)
+		}
+	}
+
+	if s.GetInt(ctx, "str") != 0 {
+		t.Errorf("got %v: expected %v", s.GetInt(ctx, "str"), 0)
+	}
+}
+
+func TestGetOK(t *testing.T) {
+	s := NewSession()
+	sd := newSessionData(time.Now(), time.Hour)
+	sd.Values["nil"] = nil
+	sd.Values["foo"] = "bar"
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
+
+	val, ok := s.GetOK(ctx, "nil")
+	if val != nil || ok != true {
+		t.Errorf("got %v, %v: expected %v, %v", val, ok, nil, true)
+	}
+	if s.Get(ctx, "nil") != nil {
+		t.Errorf("got %v: expected %v", s.Get(ctx, "nil"), nil)
+	}
+
+	val, ok = s.GetOK(ctx, "foo")
+	if val != "bar" || ok != true {
+		t.Errorf("got %v, %v: expected %v, %v", val, ok, "bar", true)
+	}
+
+	val, ok = s.GetOK(ctx, "missing")
+	if val != nil || ok != false {
+		t.Errorf("got %v, %v: expected %v, %v", val, ok, nil, false)
+	}
+}
+
+func TestGetInto(t *testing.T) {
+	type account struct {
+		ID   int
+		Name string
+	}
+
+	s := NewSession()
+	sd := newSessionData(time.Now(), time.Hour)
+	sd.Values["account"] = account{ID: 1, Name: "alice"}
+	sd.Values["nil"] = nil
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
+
+	var acc account
+	found, err := s.GetInto(ctx, "account", &acc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true || acc != (account{ID: 1, Name: "alice"}) {
+		t.Fatalf("got %v, %v: expected %v, %v", acc, found, account{ID: 1, Name: "alice"}, true)
+	}
+
+	var str string
+	found, err = s.GetInto(ctx, "account", &str)
+	if err == nil {
+		t.Fatalf("got %v: expected an error", err)
+	}
+	if found != true || str != "" {
+		t.Fatalf("got %q, %v: expected %q, %v", str, found, "", true)
+	}
+
+	acc = account{ID: 2}
+	found, err = s.GetInto(ctx, "nil", &acc)
+	if err != nil || found != true || acc != (account{}) {
+		t.Fatalf("got %v, %v, %v: expected %v, %v, %v", acc, found, err, account{}, true, nil)
+	}
+
+	found, err = s.GetInto(ctx, "missing", &acc)
+	if err != nil || found != false {
+		t.Fatalf("got %v, %v: expected %v, %v", found, err, false, nil)
+	}
+
+	_, err = s.GetInto(ctx, "account", acc)
+	if err == nil {
+		t.Fatalf("got %v: expected an error", err)
+	}
+}
+
+func TestPopOK(t *testing.T) {
+	s := NewSession()
+	sd := newSessionData(time.Now(), time.Hour)
+	sd.Values["foo"] = "bar"
+	sd.Values["nil"] = nil
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
+
+	for _, key := range []string{"missing", "missing"} {
+		val, ok := s.PopOK(ctx, key)
+		if val != nil || ok != false {
+			t.Errorf("got %v, %v: expected %v, %v", val, ok, nil, false)
+		}
+	}
+	s.Pop(ctx, "missing")
+	s.PopString(ctx, "missing")
+	s.PopDeferred(ctx, "missing")
+	if sd.status != Unmodified {
+		t.Fatalf("got %v: expected %v", sd.status, Unmodified)
+	}
+
+	val, ok := s.PopOK(ctx, "nil")
+	if val != nil || ok != true {
+		t.Errorf("got %v, %v: expected %v, %v", val, ok, nil, true)
+	}
+	val, ok = s.PopOK(ctx, "foo")
+	if val != "bar" || ok != true {
+		t.Errorf("got %v, %v: expected %v, %v", val, ok, "bar", true)
+	}
+	if s.Exists(ctx, "foo") {
+		t.Errorf("got %v: expected %v", true, false)
+	}
+	if sd.status != Modified {
+		t.Fatalf("got %v: expected %v", sd.status, Modified)
+	}
+}
+
+func TestGetWithDefault(t *testing.T) {
+	s := NewSession()
+	sd := newSessionData(time.Now(), time.Hour)
+	now := time.Now()
+	sd.Values["str"] = "bar"
+	sd.Values["bool"] = false
+	sd.Values["int"] = 0
+	sd.Values["float"] = 1.5
+	sd.Values["time"] = now
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
+
+	if got := s.GetStringD(ctx, "str", "def"); got != "bar" {
+		t.Errorf("got %q: expected %q", got, "bar")
+	}
+	if got := s.GetStringD(ctx, "missing", "def"); got != "def" {
+		t.Errorf("got %q: expected %q", got, "def")
+	}
+	if got := s.GetStringD(ctx, "int", "def"); got != "def" {
+		t.Errorf("got %q: expected %q", got, "def")
+	}
+
+	if got := s.GetBoolD(ctx, "bool", true); got != false {
+		t.Errorf("got %v: expected %v", got, false)
+	}
+	if got := s.GetBoolD(ctx, "missing", true); got != true {
+		t.Errorf("got %v: expected %v", got, true)
+	}
+	if got := s.GetBoolD(ctx, "str", true); got != true {
+		t.Errorf("got %v: expected %v", got, true)
+	}
+
+	if got := s.GetIntD(ctx, "int", 10); got != 0 {
+		t.Errorf("got %v: expected %v", got, 0)
+	}
+	if got := s.GetIntD(ctx, "missing", 10); got != 10 {
+		t.Errorf("got %v: expected %v", got, 10)
+	}
+	if got := s.GetIntD(ctx, "float", 10); got != 10 {
+		t.Errorf("got %v: expected %v", got, 10)
+	}
+
+	if got := s.GetFloatD(ctx, "float", 2.5); got != 1.5 {
+		t.Errorf("got %v: expected %v", got, 1.5)
+	}
+	if got := s.GetFloatD(ctx, "missing", 2.5); got != 2.5 {
+		t.Errorf("got %v: expected %v", got, 2.5)
+	}
+	if got := s.GetFloatD(ctx, "int", 2.5); got != 2.5 {
+		t.Errorf("got %v: expected %v", got, 2.5)
+	}
+
+	def := now.Add(time.Hour)
+	if got := s.GetTimeD(ctx, "time", def); !got.Equal(now) {
+		t.Errorf("got %v: expected %v", got, now)
+	}
+	if got := s.GetTimeD(ctx, "missing", def); !got.Equal(def) {
+		t.Errorf("got %v: expected %v", got, def)
+	}
+	if got := s.GetTimeD(ctx, "str", def); !got.Equal(def) {
+		t.Errorf("got %v: expected %v", got, def)
+	}
+}
+
+func TestOnKeyChange(t *testing.T) {
+	type change struct {
+		key            string
+		oldVal, newVal interface{}
+	}
+	var changes []change
+
+	s := NewSession()
+	s.WatchKeys = []string{"role"}
+	s.OnKeyChange = func(key string, oldVal, newVal interface{}) {
+		changes = append(changes, change{key, oldVal, newVal})
+	}
+
+	sd := newSessionData(time.Now(), time.Hour)
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
+
+	s.Put(ctx, "role", "user")
+	s.Put(ctx, "role", "user")
+	s.Put(ctx, "other", "ignored")
+	s.Put(ctx, "role", "admin")
+	s.Remove(ctx, "other")
+	s.Remove(ctx, "role")
+	s.Put(ctx, "role", "guest")
+	s.Pop(ctx, "role")
+	s.Pop(ctx, "role")
+
+	expected := []change{
+		{"role", nil, "user"},
+		{"role", "user", "admin"},
+		{"role", "admin", nil},
+		{"role", nil, "guest"},
+		{"role", "guest", nil},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("got %v: expected %v", changes, expected)
+	}
+}
+
+func TestStableID(t *testing.T) {
+	s := NewSession()
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	id := s.StableID(ctx)
+	if id == "" {
+		t.Fatal("expected a stable ID")
+	}
+	if s.Status(ctx) != Modified {
+		t.Errorf("got %v: expected %v", s.Status(ctx), Modified)
+	}
+	if s.StableID(ctx) != id {
+		t.Errorf("got %q: expected %q", s.StableID(ctx), id)
+	}
+
+	token, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.RenewToken(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Token(ctx) == token {
+		t.Errorf("want tokens to be different")
+	}
+	if s.StableID(ctx) != id {
+		t.Errorf("got %q: expected %q", s.StableID(ctx), id)
+	}
+	if len(s.Keys(ctx)) != 0 {
+		t.Errorf("got %v: expected no keys", s.Keys(ctx))
+	}
+}
+
+func TestCSRFToken(t *testing.T) {
+	s := NewSession()
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.ValidateCSRF(ctx, "") {
+		t.Errorf("got %v: expected %v", true, false)
+	}
+
+	csrf := s.CSRFToken(ctx)
+	if csrf == "" {
+		t.Fatal("expected a CSRF token")
+	}
+	if s.Status(ctx) != Modified {
+		t.Errorf("got %v: expected %v", s.Status(ctx), Modified)
+	}
+	if s.CSRFToken(ctx) != csrf {
+		t.Errorf("got %q: expected %q", s.CSRFToken(ctx), csrf)
+	}
+	if len(s.Keys(ctx)) != 0 {
+		t.Errorf("got %v: expected no keys", s.Keys(ctx))
+	}
+
+	token, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if s.CSRFToken(ctx) != csrf {
+		t.Errorf("got %q: expected %q", s.CSRFToken(ctx), csrf)
+	}
+	if s.Status(ctx) != Unmodified {
+		t.Errorf("got %v: expected %v", s.Status(ctx), Unmodified)
+	}
+
+	if !s.ValidateCSRF(ctx, csrf) {
+		t.Errorf("got %v: expected %v", false, true)
+	}
+	if s.ValidateCSRF(ctx, csrf+"x") {
+		t.Errorf("got %v: expected %v", true, false)
+	}
+	if s.ValidateCSRF(ctx, "") {
+		t.Errorf("got %v: expected %v", true, false)
+	}
+
+	rotated := s.RotateCSRF(ctx)
+	if rotated == "" || rotated == csrf {
+		t.Errorf("got %q: expected a new token", rotated)
+	}
+	if s.Status(ctx) != Modified {
+		t.Errorf("got %v: expected %v", s.Status(ctx), Modified)
+	}
+	if s.ValidateCSRF(ctx, csrf) {
+		t.Errorf("got %v: expected %v", true, false)
+	}
+	if !s.ValidateCSRF(ctx, rotated) {
+		t.Errorf("got %v: expected %v", false, true)
+	}
+}
+
+func TestTokenLength(t *testing.T) {
+	s := NewSession()
+
+	token, err := s.newToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(token) != base64.RawURLEncoding.EncodedLen(32) {
+		t.Errorf("got %d: expected %d", len(token), base64.RawURLEncoding.EncodedLen(32))
+	}
+
+	s.TokenLength = 64
+	token, err = s.newToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(token) != base64.RawURLEncoding.EncodedLen(64) {
+		t.Errorf("got %d: expected %d", len(token), base64.RawURLEncoding.EncodedLen(64))
+	}
+
+	s.TokenLength = 8
+	_, err = s.newToken()
+	if err == nil {
+		t.Errorf("expected an error for a TokenLength below the minimum")
+	}
+}
+
+func TestTokenEncoding(t *testing.T) {
+	s := NewSession()
+	s.TokenEncoding = Hex
+
+	token, err := s.newToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{64}$`).MatchString(token) {
+		t.Errorf("got %q: expected 64 lowercase hex characters", token)
+	}
+
+	s.TokenEncoding = Base64URL
+	token, err = s.newToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`).MatchString(token) {
+		t.Errorf("got %q: expected 43 URL-safe base64 characters", token)
+	}
+
+	s.TokenEncoding = TokenEncoding(99)
+	_, err = s.newToken()
+	if err == nil {
+		t.Errorf("expected an error for an unknown TokenEncoding")
+	}
+}
+
+func TestTokenGenerator(t *testing.T) {
+	s := NewSession()
+	s.TokenGenerator = func() (string, error) {
+		return "custom_token", nil
+	}
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	token, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "custom_token" {
+		t.Errorf("got %q: expected %q", token, "custom_token")
+	}
+
+	s.TokenGenerator = func() (string, error) {
+		return "renewed_token", nil
+	}
+	if err = s.RenewToken(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if s.Token(ctx) != "renewed_token" {
+		t.Errorf("got %q: expected %q", s.Token(ctx), "renewed_token")
+	}
+}
+
+// countingCodec wraps a Codec and counts the calls to Encode.
+type countingCodec struct {
+	Codec
+	encodes int
+}
+
+func (cc *countingCodec) Encode(deadline time.Time, values map[string]interface{}) ([]byte, error) {
+	cc.encodes++
+	return cc.Codec.Encode(deadline, values)
+}
+
+// expiryStore wraps a Store and records the expiry of the last Commit.
+type expiryStore struct {
+	Store
+	commits int
+	expiry  time.Time
+}
+
+func (es *expiryStore) Commit(token string, b []byte, expiry time.Time) error {
+	es.commits++
+	es.expiry = expiry
+	return es.Store.Commit(token, b, expiry)
+}
+
+func TestSaveCheckIdleRefresh(t *testing.T) {
+	s := NewSession()
+	s.IdleTimeout = time.Hour
+	codec := &countingCodec{Codec: s.Codec}
+	s.Codec = codec
+	store := &expiryStore{Store: s.Store}
+	s.Store = store
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	token, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstExpiry := store.expiry
+	time.Sleep(10 * time.Millisecond)
+
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if s.Status(ctx) != Unmodified {
+		t.Fatalf("got %v: expected %v", s.Status(ctx), Unmodified)
+	}
+	if err = s.SaveCheck(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if codec.encodes != 1 {
+		t.Errorf("got %d: expected %d", codec.encodes, 1)
+	}
+	if store.commits != 2 {
+		t.Errorf("got %d: expected %d", store.commits, 2)
+	}
+	if !store.expiry.After(firstExpiry) {
+		t.Errorf("got %v: expected expiry after %v", store.expiry, firstExpiry)
+	}
+	cookie := ctx.Response().Header().Get("Set-Cookie")
+	if !strings.HasPrefix(cookie, s.Cookie.Name+"="+token) {
+		t.Errorf("got %q: expected a cookie for %q", cookie, token)
+	}
+
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if s.GetString(ctx, "foo") != "bar" {
+		t.Errorf("got %q: expected %q", s.GetString(ctx, "foo"), "bar")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	s := NewSession()
+
+	commit := func(values map[string]interface{}) string {
+		ctx := newTestContext()
+		if _, err := s.Load(ctx, ""); err != nil {
+			t.Fatal(err)
+		}
+		for key, val := range values {
+			s.Put(ctx, key, val)
+		}
+		token, _, err := s.Commit(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	for _, overwrite := range []bool{false, true} {
+		anonToken := commit(map[string]interface{}{"cart": "apples", "theme": "dark"})
+		userToken := commit(map[string]interface{}{"user": "alice", "theme": "light"})
+
+		ctx := newTestContext()
+		if _, err := s.Load(ctx, userToken); err != nil {
+			t.Fatal(err)
+		}
+		stableID := s.StableID(ctx)
+
+		var err error
+		if overwrite {
+			err = s.MergeOverwrite(ctx, anonToken)
+		} else {
+			err = s.Merge(ctx, anonToken)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wantTheme := "light"
+		if overwrite {
+			wantTheme = "dark"
+		}
+		if s.GetString(ctx, "theme") != wantTheme {
+			t.Errorf("got %q: expected %q", s.GetString(ctx, "theme"), wantTheme)
+		}
+		if s.GetString(ctx, "cart") != "apples" {
+			t.Errorf("got %q: expected %q", s.GetString(ctx, "cart"), "apples")
+		}
+		if s.GetString(ctx, "user") != "alice" {
+			t.Errorf("got %q: expected %q", s.GetString(ctx, "user"), "alice")
+		}
+		if s.StableID(ctx) != stableID {
+			t.Errorf("got %q: expected %q", s.StableID(ctx), stableID)
+		}
+		if s.Status(ctx) != Modified {
+			t.Errorf("got %v: expected %v", s.Status(ctx), Modified)
+		}
+
+		_, found, _ := s.Store.Find(anonToken)
+		if found {
+			t.Errorf("got %v: expected %v", found, false)
+		}
+	}
+}
+
+func TestMergeNotFound(t *testing.T) {
+	s := NewSession()
+
+	sd := newSessionData(time.Now(), time.Hour)
+	sd.Values["foo"] = "bar"
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
+
+	err := s.Merge(ctx, "missing_token")
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+	if !reflect.DeepEqual(s.Keys(ctx), []string{"foo"}) {
+		t.Errorf("got %v: expected %v", s.Keys(ctx), []string{"foo"})
+	}
+	if s.Status(ctx) != Unmodified {
+		t.Errorf("got %v: expected %v", s.Status(ctx), Unmodified)
+	}
+}
+
+func TestDirtyKeys(t *testing.T) {
+	s := NewSession()
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	s.PutAll(ctx, map[string]interface{}{"baz": 1, "qux": 2})
+	s.RememberMe(ctx, true)
+	if got := s.DirtyKeys(ctx); !reflect.DeepEqual(got, []string{"baz", "foo", "qux"}) {
+		t.Fatalf("got %v: expected %v", got, []string{"baz", "foo", "qux"})
+	}
+
+	token, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.DirtyKeys(ctx); len(got) != 0 {
+		t.Fatalf("got %v: expected no keys", got)
+	}
+
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.DirtyKeys(ctx); len(got) != 0 {
+		t.Fatalf("got %v: expected no keys", got)
+	}
+	s.Get(ctx, "foo")
+	s.Pop(ctx, "baz")
+	s.Remove(ctx, "missing")
+	s.PopDeferred(ctx, "qux")
+	if got := s.DirtyKeys(ctx); !reflect.DeepEqual(got, []string{"baz", "qux"}) {
+		t.Fatalf("got %v: expected %v", got, []string{"baz", "qux"})
+	}
+	s.Remove(ctx, "foo")
+	if got := s.DirtyKeys(ctx); !reflect.DeepEqual(got, []string{"baz", "foo", "qux"}) {
+		t.Fatalf("got %v: expected %v", got, []string{"baz", "foo", "qux"})
+	}
+	if _, _, err = s.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Clear marks every key which was present as dirty.
+	s.PutAll(ctx, map[string]interface{}{"a": 1, "b": 2})
+	if _, _, err = s.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	s.Clear(ctx)
+	if got := s.DirtyKeys(ctx); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("got %v: expected %v", got, []string{"a", "b"})
+	}
+}
+
+func TestDebugString(t *testing.T) {
+	s := NewSession()
+	s.IdleTimeout = time.Minute
+	sd := newSessionData(time.Now(), time.Hour)
+	sd.token = "abcdefghijklmnopqrstuvwxyz"
+	sd.Values["userID"] = int64(4242)
+	sd.Values["cart"] = []string{"secret-item"}
+	sd.Values[lastActiveKey] = time.Now().UnixNano()
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
+	s.Put(ctx, "password", "hunter2")
+
+	got := s.DebugString(ctx)
+	for _, want := range []string{"token=abcdef…", "status=Modified", "deadline=" + sd.Deadline.Format(time.RFC3339), "keys=[cart([]string) password(string) userID(int64)]"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got %q: expected it to contain %q", got, want)
+		}
+	}
+	for _, secret := range []string{"abcdefg", "4242", "secret-item", "hunter2", lastActiveKey} {
+		if strings.Contains(got, secret) {
+			t.Errorf("got %q: expected it not to contain %q", got, secret)
+		}
+	}
+
+	ctx = s.addSessionDataToContext(newTestContext(), newSessionData(time.Now(), time.Hour))
+	if got := s.DebugString(ctx); !strings.HasPrefix(got, "token= ") || !strings.HasSuffix(got, "status=Unmodified keys=[]") {
+		t.Errorf("got %q: expected an empty token and keys", got)
+	}
+}
+
+func TestGetAll(t *testing.T) {
+	s := NewSession()
+
+	sd := newSessionData(time.Now(), time.Hour)
+	ctx := s.addSessionDataToContext(newTestContext(), sd)
+	s.Put(ctx, "foo", "bar")
+	s.Put(ctx, "baz", []byte("qux"))
+	s.StableID(ctx)
+
+	values := s.GetAll(ctx)
+	want := map[string]interface{}{"foo": "bar", "baz": []byte("qux")}
+	if !reflect.DeepEqual(values, want) {
+		t.Fatalf("got %v: expected %v", values, want)
+	}
+
+	values["foo"] = "changed"
+	values["new"] = true
+	values["baz"].([]byte)[0] = 'Q'
+	if s.GetString(ctx, "foo") != "bar" {
+		t.Errorf("got %q: expected %q", s.GetString(ctx, "foo"), "bar")
+	}
+	if s.Exists(ctx, "new") {
+		t.Errorf("got %v: expected %v", s.Exists(ctx, "new"), false)
+	}
+	if !bytes.Equal(s.GetBytes(ctx, "baz"), []byte("qux")) {
+		t.Errorf("got %q: expected %q", s.GetBytes(ctx, "baz"), "qux")
+	}
+}
+
+type testRole string
+
+type testUser struct {
+	UserID    int       `session:"userID"`
+	Role      testRole  `session:"role"`
+	LoginTime time.Time `session:"loginTime"`
+	Name      string
+	Ignored   string `session:"-"`
+	internal  string
+}
+
+func TestEncodeDecode(t *testing.T) {
+	gob.Register(time.Time{})
+
+	s := NewSession()
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	loginTime := time.Now().Round(0)
+	in := testUser{
+		UserID:    123,
+		Role:      "admin",
+		LoginTime: loginTime,
+		Name:      "alice",
+		Ignored:   "ignored",
+		internal:  "internal",
+	}
+	if err := s.Encode(ctx, &in); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.Keys(ctx), []string{"Name", "loginTime", "role", "userID"}) {
+		t.Errorf("got %v: expected %v", s.Keys(ctx), []string{"Name", "loginTime", "role", "userID"})
+	}
+	if s.Status(ctx) != Modified {
+		t.Errorf("got %v: expected %v", s.Status(ctx), Modified)
+	}
+
+	// The values are stored with gob-compatible types.
+	if s.GetInt(ctx, "userID") != 123 {
+		t.Errorf("got %v: expected %v", s.GetInt(ctx, "userID"), 123)
+	}
+	// A plain string decodes into the testRole field, so testRole does not
+	// need registering with gob.
+	s.Put(ctx, "role", "admin")
+	s.Put(ctx, "other", "unknown keys are ignored")
+
+	token, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+
+	out := testUser{Ignored: "kept", internal: "kept"}
+	if err = s.Decode(ctx, &out); err != nil {
+		t.Fatal(err)
+	}
+	want := testUser{UserID: 123, Role: "admin", LoginTime: loginTime, Name: "alice", Ignored: "kept", internal: "kept"}
+	if !out.LoginTime.Equal(want.LoginTime) {
+		t.Errorf("got %v: expected %v", out.LoginTime, want.LoginTime)
+	}
+	out.LoginTime = want.LoginTime
+	if out != want {
+		t.Errorf("got %+v: expected %+v", out, want)
+	}
+
+	// Missing keys decode as zero values.
+	s.Remove(ctx, "userID")
+	if err = s.Decode(ctx, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.UserID != 0 {
+		t.Errorf("got %v: expected %v", out.UserID, 0)
+	}
+
+	s.Put(ctx, "userID", "not an int")
+	if err = s.Decode(ctx, &out); err == nil {
+		t.Errorf("expected an error for a value of the wrong type")
+	}
+	if err = s.Decode(ctx, out); err == nil {
+		t.Errorf("expected an error for a non-pointer")
+	}
+	if err = s.Encode(ctx, "not a struct"); err == nil {
+		t.Errorf("expected an error for a non-struct")
+	}
+}
+
+func TestLoadExpiredDeadline(t *testing.T) {
+	s := NewSession()
+
+	b, err := s.Codec.Encode(time.Now().Add(-time.Minute), map[string]interface{}{"foo": "bar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The store still holds the data, as a store without expiry support would.
+	if err = s.Store.Commit("expired_token", b, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := newTestContext()
+	sd, err := s.Load(ctx, "expired_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sd.Token() != "" {
+		t.Errorf("got %q: expected a new session", sd.Token())
+	}
+	if s.Exists(ctx, "foo") {
+		t.Errorf("got %v: expected %v", s.Exists(ctx, "foo"), false)
+	}
+	if !sd.Deadline.After(time.Now()) {
+		t.Errorf("got %v: expected a deadline in the future", sd.Deadline)
+	}
+
+	_, found, _ := s.Store.Find("expired_token")
+	if found {
+		t.Errorf("got %v: expected %v", found, false)
+	}
+}
+
+func TestLoadUndecodable(t *testing.T) {
+	s := NewSession()
+	cl := &capturingLogger{}
+	s.Logger = cl
+
+	if err := s.Store.Commit("corrupt_token", []byte("not gob data"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// By default the corrupt data is discarded and a new session started.
+	ctx := newTestContext()
+	sd, err := s.Load(ctx, "corrupt_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sd.Token() != "" || len(sd.Values) != 0 {
+		t.Errorf("got %q, %v: expected a new session", sd.Token(), sd.Values)
+	}
+	if _, found, _ := s.Store.Find("corrupt_token"); found {
+		t.Errorf("got %v: expected %v", found, false)
+	}
+	if len(cl.events) == 0 || cl.events[0].level != "error" || cl.events[0].value("token") != tokenPrefix("corrupt_token") {
+		t.Errorf("got %v: expected an error event for the token", cl.events)
+	}
+
+	// The new session can be used as normal.
+	s.Put(ctx, "foo", "bar")
+	if _, _, err = s.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	s.StrictDecode = true
+	if err = s.Store.Commit("corrupt_token", []byte("not gob data"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Load(newTestContext(), "corrupt_token"); err == nil {
+		t.Fatal("got nil: expected an error")
+	}
+	if _, found, _ := s.Store.Find("corrupt_token"); !found {
+		t.Errorf("got %v: expected %v", found, true)
+	}
+}
+
+func TestSetIdleTimeout(t *testing.T) {
+	s := NewSession()
+	s.IdleTimeout = 8 * time.Hour
+	store := &expiryStore{Store: s.Store}
+	s.Store = store
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.SetIdleTimeout(ctx, 15*time.Minute)
+	if s.Status(ctx) != Modified {
+		t.Errorf("got %v: expected %v", s.Status(ctx), Modified)
+	}
+	token, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The override is loaded with the session data on the next request.
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	_, expiry, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(expiry); d > 15*time.Minute || d < 14*time.Minute {
+		t.Errorf("got %v: expected an expiry in about %v", d, 15*time.Minute)
+	}
+	if !store.expiry.Equal(expiry) {
+		t.Errorf("got %v: expected %v", store.expiry, expiry)
+	}
+	if !reflect.DeepEqual(s.Keys(ctx), []string{"foo"}) {
+		t.Errorf("got %v: expected %v", s.Keys(ctx), []string{"foo"})
+	}
+
+	// Sessions without an override use the IdleTimeout field.
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	_, expiry, err = s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(expiry); d < 7*time.Hour {
+		t.Errorf("got %v: expected an expiry in about %v", d, 8*time.Hour)
+	}
+}
+
+func TestSetLifetime(t *testing.T) {
+	s := NewSession()
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.SetLifetime(ctx, time.Hour)
+	token, expiry, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(expiry); d > time.Hour || d < 59*time.Minute {
+		t.Errorf("got %v: expected an expiry in about %v", d, time.Hour)
+	}
+
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.RenewToken(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_, expiry, err = s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Until(expiry); d > time.Hour || d < 59*time.Minute {
+		t.Errorf("got %v: expected an expiry in about %v", d, time.Hour)
+	}
+}
+
+func TestTouch(t *testing.T) {
+	s := NewSession()
+	s.IdleTimeout = time.Hour
+	codec := &countingCodec{Codec: s.Codec}
+	s.Codec = codec
+	store := &expiryStore{Store: s.Store}
+	s.Store = store
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	token, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstExpiry := store.expiry
+	time.Sleep(10 * time.Millisecond)
+
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	s.Touch(ctx)
+	if s.Status(ctx) != Modified {
+		t.Fatalf("got %v: expected %v", s.Status(ctx), Modified)
+	}
+	if _, _, err = s.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !store.expiry.After(firstExpiry) {
+		t.Errorf("got %v: expected expiry after %v", store.expiry, firstExpiry)
+	}
+	if codec.encodes != 1 {
+		t.Errorf("got %d: expected %d", codec.encodes, 1)
+	}
+
+	// A change after Touch means the data is re-encoded.
+	s.Touch(ctx)
+	s.Put(ctx, "foo", "baz")
+	if _, _, err = s.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if codec.encodes != 2 {
+		t.Errorf("got %d: expected %d", codec.encodes, 2)
+	}
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if s.GetString(ctx, "foo") != "baz" {
+		t.Errorf("got %q: expected %q", s.GetString(ctx, "foo"), "baz")
+	}
+}
+
+// touchableStore wraps an expiryStore, implementing Touch by re-committing
+// the data to the wrapped store without counting it as a commit.
+type touchableStore struct {
+	*expiryStore
+	touches int
+}
+
+func (ts *touchableStore) Touch(token string, expiry time.Time) (bool, error) {
+	ts.touches++
+	b, found, err := ts.Store.Find(token)
+	if err != nil || !found {
+		return false, err
+	}
+	ts.expiry = expiry
+	return true, ts.Store.Commit(token, b, expiry)
+}
+
+func TestTouchableStore(t *testing.T) {
+	s := NewSession()
+	s.IdleTimeout = time.Hour
+	store := &touchableStore{expiryStore: &expiryStore{Store: s.Store}}
+	s.Store = store
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	token, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.commits != 1 || store.touches != 0 {
+		t.Fatalf("got %d, %d: expected %d, %d", store.commits, store.touches, 1, 0)
+	}
+	firstExpiry := store.expiry
+	time.Sleep(10 * time.Millisecond)
+
+	// A touch-only refresh extends the expiry without rewriting the data.
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SaveCheck(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if store.commits != 1 || store.touches != 1 {
+		t.Fatalf("got %d, %d: expected %d, %d", store.commits, store.touches, 1, 1)
+	}
+	if !store.expiry.After(firstExpiry) {
+		t.Errorf("got %v: expected expiry after %v", store.expiry, firstExpiry)
+	}
+
+	// Changed data is committed in full.
+	s.Put(ctx, "foo", "baz")
+	if _, _, err = s.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if store.commits != 2 || store.touches != 1 {
+		t.Fatalf("got %d, %d: expected %d, %d", store.commits, store.touches, 2, 1)
+	}
+
+	// If the data has gone from the store, it is committed in full.
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Store.Delete(token); err != nil {
+		t.Fatal(err)
+	}
+	s.Touch(ctx)
+	if _, _, err = s.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if store.commits != 3 || store.touches != 2 {
+		t.Fatalf("got %d, %d: expected %d, %d", store.commits, store.touches, 3, 2)
+	}
+	if _, found, _ := store.Find(token); found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+}
+
+func TestDeadlineAcrossDST(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+
+	// Clocks in New York went forward from 02:00 to 03:00 on 14 March 2021,
+	// so two hours after 01:30 EST is 04:30 EDT.
+	start := time.Date(2021, 3, 14, 1, 30, 0, 0, ny)
+	s := NewSession()
+	s.Lifetime = 2 * time.Hour
+	s.IdleTimeout = 30 * time.Minute
+
+	ctx := newTestContext()
+	sd, err := s.Load(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The deadline as newSessionData sets it when created at start.
+	sd.Deadline = start.Add(s.Lifetime).UTC()
+
+	if got := sd.Deadline.Sub(start); got != 2*time.Hour {
+		t.Fatalf("got %v: expected %v", got, 2*time.Hour)
+	}
+	if got, want := sd.Deadline.In(ny), time.Date(2021, 3, 14, 4, 30, 0, 0, ny); !got.Equal(want) || got.Hour() != 4 {
+		t.Fatalf("got %v: expected %v", got, want)
+	}
+	if got := s.expiryAt(sd, start); !got.Equal(start.Add(30 * time.Minute)) {
+		t.Fatalf("got %v: expected %v", got, start.Add(30*time.Minute))
+	}
+	if got := s.expiryAt(sd, start.Add(time.Hour+45*time.Minute)); !got.Equal(sd.Deadline) {
+		t.Fatalf("got %v: expected %v", got, sd.Deadline)
+	}
+
+	local := s.DeadlineLocal(ctx)
+	if !local.Equal(sd.Deadline) || local.Location() != time.Local {
+		t.Fatalf("got %v: expected %v in the local time zone", local, sd.Deadline)
+	}
+}
+
+// fakeClock is a Clock which only moves when it is advanced.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (fc *fakeClock) Now() time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.now
+}
+
+func (fc *fakeClock) advance(d time.Duration) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.now = fc.now.Add(d)
+}
+
+// clockStore is an in-memory Store which checks expiry against a Clock. If
+// ignoreExpiry is set, data is kept after it has expired, like a store
+// without expiry support.
+type clockStore struct {
+	clock        Clock
+	ignoreExpiry bool
+	items        map[string]clockItem
+}
+
+type clockItem struct {
+	b      []byte
+	expiry time.Time
+}
+
+func (cs *clockStore) Find(token string) ([]byte, bool, error) {
+	item, ok := cs.items[token]
+	if !ok || (!cs.ignoreExpiry && cs.clock.Now().After(item.expiry)) {
+		return nil, false, nil
+	}
+	return item.b, true, nil
+}
+
+func (cs *clockStore) Commit(token string, b []byte, expiry time.Time) error {
+	cs.items[token] = clockItem{b: b, expiry: expiry}
+	return nil
+}
+
+func (cs *clockStore) Delete(token string) error {
+	delete(cs.items, token)
+	return nil
+}
+
+func TestClockIdleTimeout(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	s := NewSession()
+	s.Clock = clock
+	s.IdleTimeout = 10 * time.Minute
+	s.Lifetime = time.Hour
+	s.Store = &clockStore{clock: clock, items: make(map[string]clockItem)}
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	token, expiry, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := clock.Now().Add(10 * time.Minute); !expiry.Equal(want) {
+		t.Fatalf("got %v: expected %v", expiry, want)
+	}
+
+	// Activity within the idle timeout slides the expiry forward.
+	clock.advance(9 * time.Minute)
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if s.GetString(ctx, "foo") != "bar" {
+		t.Fatalf("got %q: expected %q", s.GetString(ctx, "foo"), "bar")
+	}
+	if err = s.SaveCheck(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expiry = s.ExpiryTime(ctx)
+
+	// The session is valid up to its expiry, and not after.
+	clock.advance(10 * time.Minute)
+	if !clock.Now().Equal(expiry) {
+		t.Fatalf("got %v: expected %v", clock.Now(), expiry)
+	}
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if s.Token(ctx) != token {
+		t.Fatalf("got %q: expected %q", s.Token(ctx), token)
+	}
+
+	clock.advance(time.Nanosecond)
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if s.Token(ctx) != "" || s.Exists(ctx, "foo") {
+		t.Fatalf("got %q, %v: expected a new session", s.Token(ctx), s.Exists(ctx, "foo"))
+	}
+}
+
+func TestClockLifetime(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	start := clock.Now()
+	s := NewSession()
+	s.Clock = clock
+	s.Lifetime = time.Hour
+	store := &clockStore{clock: clock, ignoreExpiry: true, items: make(map[string]clockItem)}
+	s.Store = store
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	token, expiry, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := start.Add(time.Hour); !expiry.Equal(want) {
+		t.Fatalf("got %v: expected %v", expiry, want)
+	}
+
+	// The store keeps the data, so the deadline is enforced by Load.
+	clock.advance(time.Hour)
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if s.Token(ctx) != token {
+		t.Fatalf("got %q: expected %q", s.Token(ctx), token)
+	}
+
+	clock.advance(time.Nanosecond)
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if s.Token(ctx) != "" {
+		t.Fatalf("got %q: expected %q", s.Token(ctx), "")
+	}
+	if _, ok := store.items[token]; ok {
+		t.Fatalf("got %v: expected the expired data to be deleted", ok)
+	}
+	if want := clock.Now().Add(time.Hour).UTC(); !s.ExpiryTime(ctx).Equal(want) {
+		t.Fatalf("got %v: expected %v", s.ExpiryTime(ctx), want)
+	}
+}
+
+func TestIdleExpiry(t *testing.T) {
+	s := NewSession()
+	s.IdleTimeout = time.Hour
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !s.LastActive(ctx).IsZero() {
+		t.Errorf("got %v: expected the zero time", s.LastActive(ctx))
+	}
+	s.Put(ctx, "foo", "bar")
+	token, expiry, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastActive := s.LastActive(ctx)
+	if !s.IdleExpiry(ctx).Equal(lastActive.Add(time.Hour)) {
+		t.Errorf("got %v: expected %v", s.IdleExpiry(ctx), lastActive.Add(time.Hour))
+	}
+	if !s.IdleExpiry(ctx).Equal(expiry) {
+		t.Errorf("got %v: expected %v", s.IdleExpiry(ctx), expiry)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// The last active time is persisted with the session data.
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if !s.LastActive(ctx).Equal(lastActive) {
+		t.Errorf("got %v: expected %v", s.LastActive(ctx), lastActive)
+	}
+	firstIdleExpiry := s.IdleExpiry(ctx)
+	if !firstIdleExpiry.Equal(lastActive.Add(time.Hour)) {
+		t.Errorf("got %v: expected %v", firstIdleExpiry, lastActive.Add(time.Hour))
+	}
+
+	// Further activity moves the idle expiry forward.
+	s.Put(ctx, "foo", "baz")
+	if _, _, err = s.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !s.LastActive(ctx).After(lastActive) {
+		t.Errorf("got %v: expected a time after %v", s.LastActive(ctx), lastActive)
+	}
+	if !s.IdleExpiry(ctx).After(firstIdleExpiry) {
+		t.Errorf("got %v: expected a time after %v", s.IdleExpiry(ctx), firstIdleExpiry)
+	}
+
+	// The idle expiry never passes the absolute deadline.
+	s.Lifetime = 30 * time.Minute
+	ctx = newTestContext()
+	sd, err := s.Load(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	if _, _, err = s.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !s.IdleExpiry(ctx).Equal(sd.Deadline) {
+		t.Errorf("got %v: expected %v", s.IdleExpiry(ctx), sd.Deadline)
+	}
+}
+
+func TestIdleExpiryWithoutIdleTimeout(t *testing.T) {
+	s := NewSession()
+
+	ctx := newTestContext()
+	sd, err := s.Load(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	if _, _, err = s.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !s.LastActive(ctx).IsZero() {
+		t.Errorf("got %v: expected the zero time", s.LastActive(ctx))
+	}
+	if !s.IdleExpiry(ctx).Equal(sd.Deadline) {
+		t.Errorf("got %v: expected %v", s.IdleExpiry(ctx), sd.Deadline)
+	}
+}
+
+func TestTouchWithoutIdleTimeout(t *testing.T) {
+	s := NewSession()
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	token, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	s.Touch(ctx)
+	if s.Status(ctx) != Unmodified {
+		t.Errorf("got %v: expected %v", s.Status(ctx), Unmodified)
+	}
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	s := NewSession()
+
+	type loadCall struct {
+		token string
+		isNew bool
+	}
+	var loads []loadCall
+	var commits, destroys []string
+	var commitExpiry time.Time
+	var ctx SessionContext
+
+	s.OnLoad = func(token string, isNew bool) {
+		loads = append(loads, loadCall{token, isNew})
+	}
+	s.OnCommit = func(token string, expiry time.Time) {
+		// The hook can use the session without deadlocking.
+		if s.Token(ctx) != token {
+			t.Errorf("got %q: expected %q", s.Token(ctx), token)
+		}
+		commits = append(commits, token)
+		commitExpiry = expiry
+	}
+	s.OnDestroy = func(token string) {
+		s.Keys(ctx)
+		destroys = append(destroys, token)
+	}
+
+	ctx = newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	// Loading again in the same request cycle uses the context.
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	token, expiry, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Destroy(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	wantLoads := []loadCall{{"", true}, {token, false}}
+	if !reflect.DeepEqual(loads, wantLoads) {
+		t.Errorf("got %v: expected %v", loads, wantLoads)
+	}
+	if !reflect.DeepEqual(commits, []string{token}) {
+		t.Errorf("got %v: expected %v", commits, []string{token})
+	}
+	if !commitExpiry.Equal(expiry) {
+		t.Errorf("got %v: expected %v", commitExpiry, expiry)
+	}
+	if !reflect.DeepEqual(destroys, []string{token}) {
+		t.Errorf("got %v: expected %v", destroys, []string{token})
+	}
+}
+
+func TestLifecycleHooksOnFailure(t *testing.T) {
+	s := NewSession()
+	store := &failingStore{Store: s.Store, failCommit: true}
+	s.Store = store
+	s.OnCommit = func(token string, expiry time.Time) {
+		t.Error("OnCommit called for a failed commit")
+	}
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	if _, _, err := s.Commit(ctx); err == nil {
+		t.Fatal("got nil: expected an error")
+	}
+}
+
+func TestPeek(t *testing.T) {
+	s := NewSession()
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	val, firstSeen := s.Peek(ctx, "flash")
+	if val != nil || firstSeen {
+		t.Errorf("got %v, %v: expected %v, %v", val, firstSeen, nil, false)
+	}
+
+	s.Put(ctx, "flash", "Saved!")
+	token, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	val, firstSeen = s.Peek(ctx, "flash")
+	if val != "Saved!" || !firstSeen {
+		t.Errorf("got %v, %v: expected %v, %v", val, firstSeen, "Saved!", true)
+	}
+	if s.Status(ctx) != Modified {
+		t.Errorf("got %v: expected %v", s.Status(ctx), Modified)
+	}
+	if len(s.Keys(ctx)) != 1 {
+		t.Errorf("got %v: expected %v", s.Keys(ctx), []string{"flash"})
+	}
+	if _, _, err = s.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The seen mark is persisted with the session.
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	val, firstSeen = s.Peek(ctx, "flash")
+	if val != "Saved!" || firstSeen {
+		t.Errorf("got %v, %v: expected %v, %v", val, firstSeen, "Saved!", false)
+	}
+	if s.Status(ctx) != Unmodified {
+		t.Errorf("got %v: expected %v", s.Status(ctx), Unmodified)
+	}
+
+	// Putting a new value clears the mark.
+	s.Put(ctx, "flash", "Saved again!")
+	val, firstSeen = s.Peek(ctx, "flash")
+	if val != "Saved again!" || !firstSeen {
+		t.Errorf("got %v, %v: expected %v, %v", val, firstSeen, "Saved again!", true)
+	}
+}
+
+// contextTouchableStore is a server-side ContextStore which implements
+// TouchableStore.
+type contextTouchableStore struct {
+	*touchableStore
+}
+
+func (cs contextTouchableStore) FindContext(c SessionContext, token string) ([]byte, bool, error) {
+	return cs.Find(token)
+}
+
+func (cs contextTouchableStore) CommitContext(c SessionContext, token string, b []byte, expiry time.Time) error {
+	return cs.Commit(token, b, expiry)
+}
+
+func (cs contextTouchableStore) DeleteContext(c SessionContext, token string) error {
+	return cs.Delete(token)
+}
+
+func TestTouchableContextStore(t *testing.T) {
+	s := NewSession()
+	s.IdleTimeout = time.Hour
+	store := &touchableStore{expiryStore: &expiryStore{Store: s.Store}}
+	s.Store = contextTouchableStore{store}
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	token, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A server-side ContextStore is touched like any other store.
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.SaveCheck(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if store.commits != 1 || store.touches != 1 {
+		t.Fatalf("got %d, %d: expected %d, %d", store.commits, store.touches, 1, 1)
+	}
+}
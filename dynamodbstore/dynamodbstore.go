@@ -0,0 +1,101 @@
+package dynamodbstore
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DynamoDBStore represents the session store.
+type DynamoDBStore struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// New returns a new DynamoDBStore instance which stores sessions in the
+// tableName table. The client parameter should be a pointer to an AWS SDK v2
+// DynamoDB client. See https://pkg.go.dev/github.com/aws/aws-sdk-go-v2/service/dynamodb#Client.
+func New(client *dynamodb.Client, tableName string) *DynamoDBStore {
+	return &DynamoDBStore{
+		client:    client,
+		tableName: tableName,
+	}
+}
+
+// Find returns the data for a given session token from the DynamoDBStore
+// instance. If the session token is not found or is expired, the returned
+// exists flag will be set to false. DynamoDB deletes expired items some time
+// after they expire, so the expiry is checked here as well.
+func (d *DynamoDBStore) Find(token string) (b []byte, exists bool, err error) {
+	out, err := d.client.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName:      aws.String(d.tableName),
+		Key:            d.key(token),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if out.Item == nil {
+		return nil, false, nil
+	}
+
+	expiry, ok := out.Item["expiry"].(*types.AttributeValueMemberN)
+	if !ok {
+		return nil, false, nil
+	}
+	sec, err := strconv.ParseInt(expiry.Value, 10, 64)
+	if err != nil || !time.Now().Before(time.Unix(sec, 0)) {
+		return nil, false, nil
+	}
+
+	data, ok := out.Item["data"].(*types.AttributeValueMemberB)
+	if !ok {
+		return nil, false, nil
+	}
+	return data.Value, true, nil
+}
+
+// Commit adds a session token and data to the DynamoDBStore instance with the
+// given expiry time. If the session token already exists then the data and
+// expiry time are updated.
+func (d *DynamoDBStore) Commit(token string, b []byte, expiry time.Time) error {
+	_, err := d.client.PutItem(context.Background(), &dynamodb.PutItemInput{
+		TableName: aws.String(d.tableName),
+		Item: map[string]types.AttributeValue{
+			"token":  &types.AttributeValueMemberS{Value: token},
+			"data":   &types.AttributeValueMemberB{Value: b},
+			"expiry": &types.AttributeValueMemberN{Value: strconv.FormatInt(makeTimestamp(expiry), 10)},
+		},
+	})
+	return err
+}
+
+// Delete removes a session token and corresponding data from the
+// DynamoDBStore instance.
+func (d *DynamoDBStore) Delete(token string) error {
+	_, err := d.client.DeleteItem(context.Background(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(d.tableName),
+		Key:       d.key(token),
+	})
+	return err
+}
+
+func (d *DynamoDBStore) key(token string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"token": &types.AttributeValueMemberS{Value: token},
+	}
+}
+
+// makeTimestamp returns expiry as a Unix timestamp in seconds, rounded up, as
+// required for a DynamoDB TTL attribute.
+func makeTimestamp(expiry time.Time) int64 {
+	sec := expiry.Unix()
+	if expiry.Nanosecond() > 0 {
+		sec++
+	}
+	return sec
+}
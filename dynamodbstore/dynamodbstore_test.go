@@ -0,0 +1,147 @@
+//go:build dynamodb
+// +build dynamodb
+
+package dynamodbstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const testTableName = "scs_sessions_test"
+
+// The tests need DynamoDB Local (e.g. the amazon/dynamodb-local container)
+// and are only built with the dynamodb build tag:
+//
+//	SCS_DYNAMODB_TEST_ENDPOINT=http://localhost:8000 go test -tags dynamodb
+func newTestStore(t *testing.T) *DynamoDBStore {
+	client := dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(os.Getenv("SCS_DYNAMODB_TEST_ENDPOINT")),
+		Credentials:  aws.AnonymousCredentials{},
+	})
+	ctx := context.Background()
+
+	_, err := client.DeleteTable(ctx, &dynamodb.DeleteTableInput{TableName: aws.String(testTableName)})
+	var notFound *types.ResourceNotFoundException
+	if err != nil && !errors.As(err, &notFound) {
+		t.Fatal(err)
+	}
+	_, err = client.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(testTableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("token"), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String("token"), KeyType: types.KeyTypeHash},
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return New(client, testTableName)
+}
+
+func TestFind(t *testing.T) {
+	d := newTestStore(t)
+
+	err := d.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, found, err := d.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+	if bytes.Equal(b, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("encoded_data"))
+	}
+}
+
+func TestFindMissing(t *testing.T) {
+	d := newTestStore(t)
+
+	_, found, err := d.Find("missing_session_token")
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestSaveUpdated(t *testing.T) {
+	d := newTestStore(t)
+
+	err := d.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.Commit("session_token", []byte("new_encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _, err := d.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(b, []byte("new_encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("new_encoded_data"))
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	d := newTestStore(t)
+
+	// DynamoDB Local does not delete expired items, so this checks that Find
+	// ignores them.
+	err := d.Commit("session_token", []byte("encoded_data"), time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, found, err := d.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	d := newTestStore(t)
+
+	err := d.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = d.Delete("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, found, err := d.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
@@ -0,0 +1,5 @@
+package dynamodbstore
+
+import "github.com/aberlorn/scs/v2"
+
+var _ scs.Store = (*DynamoDBStore)(nil)
@@ -0,0 +1,106 @@
+package scs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrDecryptionFailed is returned by EncryptedCodec.Decode when the session
+// data could not be authenticated and decrypted with any of the configured
+// keys. This indicates tampered data or a wrong key.
+var ErrDecryptionFailed = errors.New("scs: session data could not be decrypted (tampered data or wrong key)")
+
+// EncryptedCodec wraps another Codec and encrypts the encoded session data
+// with AES-256-GCM, so that data held in a shared store is not readable by
+// anyone with access to the store.
+type EncryptedCodec struct {
+	inner Codec
+	aeads []cipher.AEAD
+}
+
+// NewEncryptedCodec returns a new EncryptedCodec which encrypts the output of
+// inner using key. The key must be 32 bytes long. Any decryptionKeys are only
+// used when decoding, which allows data encrypted with a previous key to be
+// read while new data is always encrypted with key.
+func NewEncryptedCodec(key []byte, inner Codec, decryptionKeys ...[]byte) (*EncryptedCodec, error) {
+	return NewEncryptedCodecWithKeys(append([][]byte{key}, decryptionKeys...), inner)
+}
+
+// NewEncryptedCodecWithKeys returns a new EncryptedCodec which encrypts the
+// output of inner using the first of keys, and tries each of keys in order
+// when decoding. To rotate the key, prepend a new key to the list and remove
+// the old key once the sessions encrypted with it have expired. Every key
+// must be 32 bytes long and at least one key is required.
+func NewEncryptedCodecWithKeys(keys [][]byte, inner Codec) (*EncryptedCodec, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("scs: at least one encryption key is required")
+	}
+
+	e := &EncryptedCodec{inner: inner}
+
+	for _, k := range keys {
+		aead, err := newAEAD(k)
+		if err != nil {
+			return nil, err
+		}
+		e.aeads = append(e.aeads, aead)
+	}
+
+	return e, nil
+}
+
+// Encode encodes the session data with the inner codec and encrypts the result
+// with the first key.
+func (e *EncryptedCodec) Encode(deadline time.Time, values map[string]interface{}) ([]byte, error) {
+	b, err := e.inner.Encode(deadline, values)
+	if err != nil {
+		return nil, err
+	}
+
+	aead := e.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(b)+aead.Overhead())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, b, nil), nil
+}
+
+// Decode authenticates and decrypts the session data, trying each key in turn,
+// and then decodes the result with the inner codec. ErrDecryptionFailed is
+// returned if none of the keys work.
+func (e *EncryptedCodec) Decode(b []byte) (time.Time, map[string]interface{}, error) {
+	for _, aead := range e.aeads {
+		if len(b) < aead.NonceSize() {
+			continue
+		}
+
+		plaintext, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+		if err != nil {
+			continue
+		}
+
+		return e.inner.Decode(plaintext)
+	}
+
+	return time.Time{}, nil, ErrDecryptionFailed
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("scs: encryption key must be 32 bytes but is %d bytes", len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
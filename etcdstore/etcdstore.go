@@ -0,0 +1,138 @@
+package etcdstore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// EtcdStore represents the session store.
+type EtcdStore struct {
+	client *clientv3.Client
+	prefix string
+
+	// leases caches the lease granted for each expiry time (as a Unix
+	// timestamp in seconds), so that sessions which expire in the same
+	// second share a lease instead of each commit granting a new one.
+	mu     sync.Mutex
+	leases map[int64]clientv3.LeaseID
+}
+
+// New returns a new EtcdStore instance. The client parameter should be a
+// pointer to an etcd v3 client. See https://pkg.go.dev/go.etcd.io/etcd/client/v3#Client.
+func New(client *clientv3.Client) *EtcdStore {
+	return NewWithPrefix(client, "scs:session:")
+}
+
+// NewWithPrefix returns a new EtcdStore instance. The prefix parameter
+// controls the etcd key prefix, which can be used to avoid naming clashes if
+// necessary.
+func NewWithPrefix(client *clientv3.Client, prefix string) *EtcdStore {
+	return &EtcdStore{
+		client: client,
+		prefix: prefix,
+		leases: make(map[int64]clientv3.LeaseID),
+	}
+}
+
+// Find returns the data for a given session token from the EtcdStore
+// instance. If the session token is not found or is expired, the returned
+// exists flag will be set to false.
+func (e *EtcdStore) Find(token string) ([]byte, bool, error) {
+	resp, err := e.client.Get(context.Background(), e.prefix+token)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, false, nil
+	}
+	return resp.Kvs[0].Value, true, nil
+}
+
+// Commit adds a session token and data to the EtcdStore instance with the
+// given expiry time. The key is attached to a lease which expires at (or up
+// to a second after) the expiry time. If the session token already exists,
+// then the data and lease are updated.
+func (e *EtcdStore) Commit(token string, b []byte, expiry time.Time) error {
+	ctx := context.Background()
+
+	if !time.Now().Before(expiry) {
+		return e.Delete(token)
+	}
+
+	lease, err := e.lease(ctx, expiry)
+	if err != nil {
+		return err
+	}
+	_, err = e.client.Put(ctx, e.prefix+token, string(b), clientv3.WithLease(lease))
+	if err == rpctypes.ErrLeaseNotFound {
+		// The cached lease has been revoked or has expired early, so grant
+		// a new one.
+		e.forgetLease(expiry)
+		lease, err = e.lease(ctx, expiry)
+		if err != nil {
+			return err
+		}
+		_, err = e.client.Put(ctx, e.prefix+token, string(b), clientv3.WithLease(lease))
+	}
+	return err
+}
+
+// Delete removes a session token and corresponding data from the EtcdStore
+// instance. The lease is not revoked, as it may be shared with other
+// sessions; etcd removes it once it expires.
+func (e *EtcdStore) Delete(token string) error {
+	_, err := e.client.Delete(context.Background(), e.prefix+token)
+	return err
+}
+
+// lease returns a lease for the given expiry time, granting a new one if
+// there is none cached.
+func (e *EtcdStore) lease(ctx context.Context, expiry time.Time) (clientv3.LeaseID, error) {
+	sec := makeTimestamp(expiry)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if lease, ok := e.leases[sec]; ok {
+		return lease, nil
+	}
+
+	ttl := sec - time.Now().Unix()
+	if ttl < 1 {
+		ttl = 1
+	}
+	resp, err := e.client.Grant(ctx, ttl)
+	if err != nil {
+		return 0, err
+	}
+
+	// Leases for expiry times which have passed are no longer needed.
+	now := time.Now().Unix()
+	for s := range e.leases {
+		if s < now {
+			delete(e.leases, s)
+		}
+	}
+	e.leases[sec] = resp.ID
+	return resp.ID, nil
+}
+
+func (e *EtcdStore) forgetLease(expiry time.Time) {
+	e.mu.Lock()
+	delete(e.leases, makeTimestamp(expiry))
+	e.mu.Unlock()
+}
+
+// makeTimestamp returns expiry as a Unix timestamp in seconds, rounded up, as
+// etcd lease TTLs are whole seconds.
+func makeTimestamp(expiry time.Time) int64 {
+	sec := expiry.Unix()
+	if expiry.Nanosecond() > 0 {
+		sec++
+	}
+	return sec
+}
@@ -0,0 +1,213 @@
+//go:build etcd
+// +build etcd
+
+package etcdstore
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+// The tests start an embedded etcd server and are only built with the etcd
+// build tag:
+//
+//	go test -tags etcd
+func newTestStore(t *testing.T) *EtcdStore {
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientURL, _ := url.Parse("http://127.0.0.1:0")
+	peerURL, _ := url.Parse("http://127.0.0.1:0")
+	cfg.ListenClientUrls, cfg.AdvertiseClientUrls = []url.URL{*clientURL}, []url.URL{*clientURL}
+	cfg.ListenPeerUrls, cfg.AdvertisePeerUrls = []url.URL{*peerURL}, []url.URL{*peerURL}
+	cfg.InitialCluster = cfg.Name + "=" + peerURL.String()
+
+	server, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("etcd server did not start")
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{server.Clients[0].Addr().String()},
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return New(client)
+}
+
+func TestFind(t *testing.T) {
+	e := newTestStore(t)
+
+	err := e.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, found, err := e.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+	if bytes.Equal(b, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("encoded_data"))
+	}
+}
+
+func TestFindMissing(t *testing.T) {
+	e := newTestStore(t)
+
+	_, found, err := e.Find("missing_session_token")
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestSaveNew(t *testing.T) {
+	e := newTestStore(t)
+
+	err := e.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := e.client.Get(context.Background(), "scs:session:session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Kvs) != 1 {
+		t.Fatalf("got %d: expected %d", len(resp.Kvs), 1)
+	}
+	if bytes.Equal(resp.Kvs[0].Value, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", resp.Kvs[0].Value, []byte("encoded_data"))
+	}
+
+	ttl, err := e.client.TimeToLive(context.Background(), clientv3.LeaseID(resp.Kvs[0].Lease))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl.TTL < 59 || ttl.TTL > 61 {
+		t.Fatalf("got %d: expected about %d", ttl.TTL, 60)
+	}
+}
+
+func TestSaveUpdated(t *testing.T) {
+	e := newTestStore(t)
+
+	err := e.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = e.Commit("session_token", []byte("new_encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _, err := e.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(b, []byte("new_encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("new_encoded_data"))
+	}
+}
+
+func TestLeaseReuse(t *testing.T) {
+	e := newTestStore(t)
+	expiry := time.Now().Add(time.Minute)
+
+	for _, token := range []string{"session_token_1", "session_token_2"} {
+		err := e.Commit(token, []byte("encoded_data"), expiry)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	leases, err := e.client.Leases(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leases.Leases) != 1 {
+		t.Fatalf("got %d: expected %d", len(leases.Leases), 1)
+	}
+
+	// A revoked lease is replaced.
+	_, err = e.client.Revoke(context.Background(), leases.Leases[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = e.Commit("session_token_1", []byte("encoded_data"), expiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, found, err := e.Find("session_token_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	e := newTestStore(t)
+
+	err := e.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, found, _ := e.Find("session_token")
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+
+	time.Sleep(3 * time.Second)
+	_, found, _ = e.Find("session_token")
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	e := newTestStore(t)
+
+	err := e.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = e.Delete("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, found, err := e.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
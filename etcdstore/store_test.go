@@ -0,0 +1,5 @@
+package etcdstore
+
+import "github.com/aberlorn/scs/v2"
+
+var _ scs.Store = (*EtcdStore)(nil)
@@ -0,0 +1,87 @@
+package fiberadapter
+
+import (
+	"net/http"
+
+	"github.com/aberlorn/scs/v2"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Context adapts a *fiber.Ctx to the scs.SessionContext interface. Session
+// data is kept in the fiber locals, so any number of Context values created
+// for the same *fiber.Ctx share the same session.
+type Context struct {
+	ctx *fiber.Ctx
+}
+
+// New returns a new Context for the given fiber context.
+func New(ctx *fiber.Ctx) *Context {
+	return &Context{ctx: ctx}
+}
+
+// Get returns the value for key from the fiber locals.
+func (c *Context) Get(key string) interface{} {
+	return c.ctx.Locals(key)
+}
+
+// Set stores val under key in the fiber locals.
+func (c *Context) Set(key string, val interface{}) {
+	c.ctx.Locals(key, val)
+}
+
+// Cookie returns the named request cookie, or http.ErrNoCookie if it is not
+// present.
+func (c *Context) Cookie(name string) (*http.Cookie, error) {
+	value := c.ctx.Cookies(name)
+	if value == "" {
+		return nil, http.ErrNoCookie
+	}
+	return &http.Cookie{Name: name, Value: value}, nil
+}
+
+// HeaderWriter returns the fasthttp response header as an scs.HeaderWriter.
+func (c *Context) HeaderWriter() scs.HeaderWriter {
+	return responseHeader{ctx: c.ctx}
+}
+
+type responseHeader struct {
+	ctx *fiber.Ctx
+}
+
+func (h responseHeader) Add(key, value string) {
+	h.ctx.Response().Header.Add(key, value)
+}
+
+func (h responseHeader) Values(key string) []string {
+	key = http.CanonicalHeaderKey(key)
+
+	var values []string
+	h.ctx.Response().Header.VisitAll(func(k, v []byte) {
+		if http.CanonicalHeaderKey(string(k)) == key {
+			values = append(values, string(v))
+		}
+	})
+	return values
+}
+
+// LoadAndSave returns fiber middleware which loads the session before the
+// next handler runs and saves it afterwards. Fiber does not send the response
+// until the handler chain returns, so the session cookie can be written after
+// the handlers have finished.
+func LoadAndSave(s *scs.Session) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		sc := New(c)
+
+		if err := s.LoadCheck(sc); err != nil {
+			return err
+		}
+		// Release the token lock taken when SerializeByToken is set.
+		defer s.Release(sc)
+
+		if err := c.Next(); err != nil {
+			return err
+		}
+
+		return s.SaveCheck(sc)
+	}
+}
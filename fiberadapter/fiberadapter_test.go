@@ -0,0 +1,93 @@
+package fiberadapter
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aberlorn/scs/v2"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLoadAndSave(t *testing.T) {
+	session := scs.NewSession()
+
+	app := fiber.New()
+	app.Use(LoadAndSave(session))
+	app.Get("/put", func(c *fiber.Ctx) error {
+		session.Put(New(c), "foo", "bar")
+		return nil
+	})
+	app.Get("/get", func(c *fiber.Ctx) error {
+		return c.SendString(session.GetString(New(c), "foo"))
+	})
+
+	res, err := app.Test(httptest.NewRequest("GET", "/put", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := res.Header.Get("Set-Cookie")
+	if !strings.HasPrefix(cookie, "session=") {
+		t.Fatalf("got %q: expected prefix %q", cookie, "session=")
+	}
+	if res.Header.Get("Vary") != "Cookie" {
+		t.Errorf("got %q: expected %q", res.Header.Get("Vary"), "Cookie")
+	}
+
+	req := httptest.NewRequest("GET", "/get", nil)
+	req.Header.Set("Cookie", strings.Split(cookie, ";")[0])
+	res, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "bar" {
+		t.Errorf("got %q: expected %q", body, "bar")
+	}
+	if res.Header.Get("Set-Cookie") != "" {
+		t.Errorf("got %q: expected %q", res.Header.Get("Set-Cookie"), "")
+	}
+}
+
+func TestLoadAndSaveSerializeByToken(t *testing.T) {
+	session := scs.NewSession()
+	session.SerializeByToken = true
+
+	app := fiber.New()
+	app.Use(LoadAndSave(session))
+	app.Get("/increment", func(c *fiber.Ctx) error {
+		sc := New(c)
+		n := session.GetInt(sc, "counter") + 1
+		session.Put(sc, "counter", n)
+		return c.SendString(strconv.Itoa(n))
+	})
+
+	res, err := app.Test(httptest.NewRequest("GET", "/increment", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := strings.Split(res.Header.Get("Set-Cookie"), ";")[0]
+
+	// Each request releases the lock on the token, so later requests with
+	// the same token aren't blocked.
+	for i := 2; i <= 3; i++ {
+		req := httptest.NewRequest("GET", "/increment", nil)
+		req.Header.Set("Cookie", cookie)
+		res, err = app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != strconv.Itoa(i) {
+			t.Errorf("got %q: expected %q", body, strconv.Itoa(i))
+		}
+	}
+}
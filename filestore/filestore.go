@@ -0,0 +1,235 @@
+package filestore
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// tempPrefix is the filename prefix of the temporary files written by
+// Commit. It can't appear in a filename returned by filename.
+const tempPrefix = ".tmp-"
+
+// FileStore represents the session store.
+type FileStore struct {
+	dir         string
+	mu          sync.Mutex
+	stopCleanup chan bool
+}
+
+// New returns a new FileStore instance which holds each session in its own
+// file in dir. The directory is created on the first commit if it doesn't
+// exist. Expired session files are only removed when they are found, unless
+// StartCleanup is called.
+func New(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+// Find returns the data for a given session token from the FileStore instance.
+// If the session token is not found or is expired, the returned exists flag will
+// be set to false. Expired session files are deleted.
+func (f *FileStore) Find(token string) ([]byte, bool, error) {
+	path := f.path(token)
+	v, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	if expired(v) {
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, false, err
+		}
+		return nil, false, nil
+	}
+	return v[8:], true, nil
+}
+
+// Commit adds a session token and data to the FileStore instance with the
+// given expiry time. If the session token already exists, then the data and expiry
+// time are updated. The file is written to a temporary file and renamed, so
+// that Find never sees a partially written session.
+func (f *FileStore) Commit(token string, b []byte, expiry time.Time) error {
+	err := os.MkdirAll(f.dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	// Each file holds the expiry time in Unix nanoseconds followed by the data.
+	v := make([]byte, 8+len(b))
+	binary.BigEndian.PutUint64(v, uint64(expiry.UnixNano()))
+	copy(v[8:], b)
+
+	tmp, err := ioutil.TempFile(f.dir, tempPrefix)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(v)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), f.path(token))
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Delete removes a session token and corresponding data from the FileStore
+// instance.
+func (f *FileStore) Delete(token string) error {
+	err := os.Remove(f.path(token))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// DeleteExpired removes the files of all expired sessions from the directory
+// and returns the number of sessions removed. It implements
+// scs.GarbageCollectable.
+func (f *FileStore) DeleteExpired() (int, error) {
+	// Serialize cleanups so that two runs don't race to remove the same files.
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	infos, err := ioutil.ReadDir(f.dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, info := range infos {
+		if info.IsDir() || !validFilename(info.Name()) {
+			continue
+		}
+		path := filepath.Join(f.dir, info.Name())
+		v, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return n, err
+		}
+		if !expired(v) {
+			continue
+		}
+		err = os.Remove(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// StartCleanup starts a background goroutine which deletes expired session
+// files from the directory every interval. Use StopCleanup to terminate the
+// goroutine.
+func (f *FileStore) StartCleanup(interval time.Duration) {
+	f.stopCleanup = make(chan bool)
+	go f.startCleanup(interval, f.stopCleanup)
+}
+
+func (f *FileStore) startCleanup(interval time.Duration, stop chan bool) {
+	ticker := time.NewTicker(interval)
+	for {
+		select {
+		case <-ticker.C:
+			_, err := f.DeleteExpired()
+			if err != nil {
+				log.Println(err)
+			}
+		case <-stop:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// StopCleanup terminates the background cleanup goroutine for the FileStore
+// instance. It's rare to terminate this; generally FileStore instances and
+// their cleanup goroutines are intended to be long-lived and run for the lifetime
+// of your application.
+//
+// There may be occasions though when your use of the FileStore is transient.
+// An example is creating a new FileStore instance in a test function. In this
+// scenario, the cleanup goroutine (which will run forever) will prevent the
+// FileStore object from being garbage collected even after the test function
+// has finished. You can prevent this by manually calling StopCleanup.
+func (f *FileStore) StopCleanup() {
+	if f.stopCleanup != nil {
+		f.stopCleanup <- true
+		f.stopCleanup = nil
+	}
+}
+
+// path returns the path of the file holding the session data for token.
+func (f *FileStore) path(token string) string {
+	return filepath.Join(f.dir, filename(token))
+}
+
+// filename returns a safe filename for token. Tokens made up only of the
+// base64url alphabet are used as they are; any other token (which could
+// contain path separators or "..") is replaced by a hash of itself. The '~'
+// prefix of hashed names can't appear in an unhashed one, so the two never
+// collide.
+func filename(token string) string {
+	if safeName(token) {
+		return token
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "~" + hex.EncodeToString(sum[:])
+}
+
+// validFilename returns true if name could have been returned by filename.
+func validFilename(name string) bool {
+	if len(name) > 0 && name[0] == '~' {
+		name = name[1:]
+	}
+	return safeName(name)
+}
+
+// safeName returns true if name is a non-empty string of base64url
+// characters which is short enough to be a filename.
+func safeName(name string) bool {
+	if name == "" || len(name) > 200 {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// expired returns true if the stored value v has expired or is malformed.
+func expired(v []byte) bool {
+	if len(v) < 8 {
+		return true
+	}
+	return time.Now().UnixNano() > int64(binary.BigEndian.Uint64(v[:8]))
+}
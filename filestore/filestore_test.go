@@ -0,0 +1,248 @@
+package filestore
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aberlorn/scs/v2"
+	"github.com/aberlorn/scs/v2/scstest"
+)
+
+var (
+	_ scs.Store              = (*FileStore)(nil)
+	_ scs.GarbageCollectable = (*FileStore)(nil)
+)
+
+func newTestStore(t *testing.T) (*FileStore, func()) {
+	dir, err := ioutil.TempDir("", "filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(filepath.Join(dir, "sessions")), func() { os.RemoveAll(dir) }
+}
+
+func countFiles(t *testing.T, dir string) int {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(infos)
+}
+
+func TestFind(t *testing.T) {
+	f, cleanup := newTestStore(t)
+	defer cleanup()
+
+	err := f.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, found, err := f.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+	if bytes.Equal(b, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("encoded_data"))
+	}
+	if _, err = os.Stat(filepath.Join(f.dir, "session_token")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindMissing(t *testing.T) {
+	f, cleanup := newTestStore(t)
+	defer cleanup()
+
+	_, found, err := f.Find("missing_session_token")
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestSaveUpdated(t *testing.T) {
+	f, cleanup := newTestStore(t)
+	defer cleanup()
+
+	err := f.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Commit("session_token", []byte("new_encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _, err := f.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(b, []byte("new_encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("new_encoded_data"))
+	}
+	if n := countFiles(t, f.dir); n != 1 {
+		t.Fatalf("got %d: expected %d", n, 1)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	f, cleanup := newTestStore(t)
+	defer cleanup()
+
+	err := f.Commit("session_token", []byte("encoded_data"), time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, found, err := f.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+	if n := countFiles(t, f.dir); n != 0 {
+		t.Fatalf("got %d: expected %d", n, 0)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	f, cleanup := newTestStore(t)
+	defer cleanup()
+
+	err := f.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.Delete("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, found, err := f.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+
+	// Deleting a missing token is a no-op.
+	err = f.Delete("session_token")
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+}
+
+func TestUnsafeToken(t *testing.T) {
+	f, cleanup := newTestStore(t)
+	defer cleanup()
+
+	for _, token := range []string{"../escaped", "..", "a/b", "~abc", "", strings.Repeat("x", 300)} {
+		name := filename(token)
+		if name == token || strings.ContainsAny(name, "/.") {
+			t.Fatalf("got %q: expected a hashed filename for %q", name, token)
+		}
+
+		err := f.Commit(token, []byte("encoded_data"), time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, found, err := f.Find(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != true {
+			t.Fatalf("got %v: expected %v", found, true)
+		}
+		if bytes.Equal(b, []byte("encoded_data")) == false {
+			t.Fatalf("got %v: expected %v", b, []byte("encoded_data"))
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(filepath.Dir(f.dir), "escaped")); !os.IsNotExist(err) {
+		t.Fatalf("got %v: expected the file not to exist", err)
+	}
+	if n := countFiles(t, f.dir); n != 6 {
+		t.Fatalf("got %d: expected %d", n, 6)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	f, cleanup := newTestStore(t)
+	defer cleanup()
+
+	// Before the first commit the directory doesn't exist.
+	n, err := f.DeleteExpired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("got %d: expected %d", n, 0)
+	}
+
+	err = f.Commit("expired_token", []byte("encoded_data"), time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Files which weren't written by the store are left alone.
+	err = ioutil.WriteFile(filepath.Join(f.dir, "other.txt"), nil, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err = f.DeleteExpired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("got %d: expected %d", n, 1)
+	}
+	if n := countFiles(t, f.dir); n != 2 {
+		t.Fatalf("got %d: expected %d", n, 2)
+	}
+}
+
+func TestStartCleanup(t *testing.T) {
+	f, cleanup := newTestStore(t)
+	defer cleanup()
+
+	err := f.Commit("session_token", []byte("encoded_data"), time.Now().Add(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.StartCleanup(200 * time.Millisecond)
+	defer f.StopCleanup()
+
+	time.Sleep(300 * time.Millisecond)
+	if n := countFiles(t, f.dir); n != 0 {
+		t.Fatalf("got %d: expected %d", n, 0)
+	}
+}
+
+func TestStoreInterfaces(t *testing.T) {
+	f, cleanup := newTestStore(t)
+	defer cleanup()
+
+	got := scstest.StoreInterfaces(f)
+	if !reflect.DeepEqual(got, []string{"GarbageCollectable"}) {
+		t.Fatalf("got %v: expected %v", got, []string{"GarbageCollectable"})
+	}
+}
@@ -0,0 +1,45 @@
+package scs
+
+// Logger is the interface for structured logging of session events. The
+// keysAndValues are alternating keys (strings) and values, as used by
+// loggers such as go-kit, logr and zap's SugaredLogger. Implementations must
+// be safe for concurrent use.
+//
+// Session tokens are never logged in full: the "token" value is a short
+// prefix of the token, which identifies the session in the logs without
+// allowing it to be hijacked.
+type Logger interface {
+	// Debug is called when a session is loaded, committed or destroyed.
+	Debug(msg string, keysAndValues ...interface{})
+
+	// Error is called when a session store operation fails.
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// NoopLogger is a Logger implementation which logs nothing. It is the
+// default for new sessions.
+type NoopLogger struct{}
+
+func (NoopLogger) Debug(msg string, keysAndValues ...interface{}) {}
+func (NoopLogger) Error(msg string, keysAndValues ...interface{}) {}
+
+func (s *Session) logger() Logger {
+	if s.Logger == nil {
+		return NoopLogger{}
+	}
+	return s.Logger
+}
+
+// tokenPrefixLen is the maximum number of characters of a token which are
+// logged.
+const tokenPrefixLen = 6
+
+// tokenPrefix returns the part of token which may be logged: at most
+// tokenPrefixLen characters, and never more than half of the token.
+func tokenPrefix(token string) string {
+	n := len(token) / 2
+	if n > tokenPrefixLen {
+		n = tokenPrefixLen
+	}
+	return token[:n]
+}
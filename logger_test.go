@@ -0,0 +1,130 @@
+package scs
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type logEvent struct {
+	level         string
+	msg           string
+	keysAndValues []interface{}
+}
+
+// value returns the value for key in the event, or nil.
+func (e logEvent) value(key string) interface{} {
+	for i := 0; i+1 < len(e.keysAndValues); i += 2 {
+		if e.keysAndValues[i] == key {
+			return e.keysAndValues[i+1]
+		}
+	}
+	return nil
+}
+
+// capturingLogger records the events it is given.
+type capturingLogger struct {
+	mu     sync.Mutex
+	events []logEvent
+}
+
+func (cl *capturingLogger) Debug(msg string, keysAndValues ...interface{}) {
+	cl.log("debug", msg, keysAndValues)
+}
+
+func (cl *capturingLogger) Error(msg string, keysAndValues ...interface{}) {
+	cl.log("error", msg, keysAndValues)
+}
+
+func (cl *capturingLogger) log(level, msg string, keysAndValues []interface{}) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.events = append(cl.events, logEvent{level, msg, keysAndValues})
+}
+
+func TestLogger(t *testing.T) {
+	s := NewSession()
+	cl := &capturingLogger{}
+	s.Logger = cl
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	token, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Destroy(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var msgs []string
+	for _, e := range cl.events {
+		if e.level != "debug" {
+			t.Errorf("got %q: expected %q", e.level, "debug")
+		}
+		msgs = append(msgs, e.msg)
+		if strings.Contains(fmt.Sprint(e.keysAndValues...), token) {
+			t.Errorf("got %v: expected the token not to be logged", e.keysAndValues)
+		}
+	}
+	want := "scs: session loaded,scs: session committed,scs: session destroyed"
+	if got := strings.Join(msgs, ","); got != want {
+		t.Errorf("got %q: expected %q", got, want)
+	}
+	if got := cl.events[1].value("token"); got != token[:tokenPrefixLen] {
+		t.Errorf("got %v: expected %q", got, token[:tokenPrefixLen])
+	}
+}
+
+func TestLoggerStoreError(t *testing.T) {
+	s := NewSession()
+	cl := &capturingLogger{}
+	s.Logger = cl
+	s.Store = &failingStore{Store: s.Store, failCommit: true}
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	if _, _, err := s.Commit(ctx); err == nil {
+		t.Fatal("expected commit to fail")
+	}
+
+	var errorEvents []logEvent
+	for _, e := range cl.events {
+		if e.level == "error" {
+			errorEvents = append(errorEvents, e)
+		}
+	}
+	if len(errorEvents) != 1 {
+		t.Fatalf("got %d: expected %d error events", len(errorEvents), 1)
+	}
+	e := errorEvents[0]
+	if e.value("op") != "commit" {
+		t.Errorf("got %v: expected %q", e.value("op"), "commit")
+	}
+	if err, ok := e.value("error").(error); !ok || err.Error() != "commit failed" {
+		t.Errorf("got %v: expected %q", e.value("error"), "commit failed")
+	}
+	if prefix, _ := e.value("token").(string); len(prefix) != tokenPrefixLen {
+		t.Errorf("got %q: expected a %d character token prefix", prefix, tokenPrefixLen)
+	}
+}
+
+func TestTokenPrefix(t *testing.T) {
+	tests := map[string]string{
+		"":                 "",
+		"abcd":             "ab",
+		"abcdefghijklmnop": "abcdef",
+	}
+	for token, want := range tests {
+		if got := tokenPrefix(token); got != want {
+			t.Errorf("got %q: expected %q", got, want)
+		}
+	}
+}
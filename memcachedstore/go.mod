@@ -0,0 +1,10 @@
+module github.com/aberlorn/scs/memcachedstore
+
+go 1.12
+
+require (
+	github.com/aberlorn/scs/v2 v2.0.0
+	github.com/bradfitz/gomemcache v0.0.0-20230905024940-24af94b03874
+)
+
+replace github.com/aberlorn/scs/v2 => ../
@@ -0,0 +1,114 @@
+package memcachedstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// maxKeyLength is the maximum length of a Memcached key.
+const maxKeyLength = 250
+
+// maxRelativeExpiration is the longest expiration which Memcached treats as a
+// number of seconds from now. Longer expirations must be Unix timestamps.
+const maxRelativeExpiration = 30 * 24 * time.Hour
+
+// MemcachedStore represents the session store.
+type MemcachedStore struct {
+	client *memcache.Client
+	prefix string
+}
+
+// New returns a new MemcachedStore instance. The client parameter should be a
+// pointer to a gomemcache client. See https://godoc.org/github.com/bradfitz/gomemcache/memcache#Client.
+func New(client *memcache.Client) *MemcachedStore {
+	return NewWithPrefix(client, "scs:session:")
+}
+
+// NewWithPrefix returns a new MemcachedStore instance. The client parameter
+// should be a pointer to a gomemcache client. The prefix parameter controls
+// the Memcached key prefix, which can be used to avoid naming clashes if
+// necessary.
+func NewWithPrefix(client *memcache.Client, prefix string) *MemcachedStore {
+	return &MemcachedStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// Find returns the data for a given session token from the MemcachedStore
+// instance. If the session token is not found or is expired, the returned
+// exists flag will be set to false.
+func (m *MemcachedStore) Find(token string) ([]byte, bool, error) {
+	item, err := m.client.Get(m.key(token))
+	if err == memcache.ErrCacheMiss {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return item.Value, true, nil
+}
+
+// Commit adds a session token and data to the MemcachedStore instance with the
+// given expiry time. If the session token already exists then the data and
+// expiry time are updated.
+func (m *MemcachedStore) Commit(token string, b []byte, expiry time.Time) error {
+	expiration, ok := makeExpiration(expiry)
+	if !ok {
+		// An expiration of 0 means the item never expires, so delete data
+		// which has already expired instead.
+		return m.Delete(token)
+	}
+	return m.client.Set(&memcache.Item{
+		Key:        m.key(token),
+		Value:      b,
+		Expiration: expiration,
+	})
+}
+
+// Delete removes a session token and corresponding data from the
+// MemcachedStore instance.
+func (m *MemcachedStore) Delete(token string) error {
+	err := m.client.Delete(m.key(token))
+	if err == memcache.ErrCacheMiss {
+		return nil
+	}
+	return err
+}
+
+// key returns the Memcached key for token. Keys are limited to 250 bytes
+// without whitespace or control characters, so tokens which would make an
+// invalid key (e.g. from a custom Session.TokenGenerator) are hashed.
+func (m *MemcachedStore) key(token string) string {
+	key := m.prefix + token
+	if len(key) <= maxKeyLength && legalKey(key) {
+		return key
+	}
+	sum := sha256.Sum256([]byte(token))
+	return m.prefix + hex.EncodeToString(sum[:])
+}
+
+func legalKey(key string) bool {
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// makeExpiration converts expiry to a Memcached expiration value: the number
+// of seconds from now (rounded up) or, beyond 30 days, a Unix timestamp. It
+// returns false if expiry is not in the future.
+func makeExpiration(expiry time.Time) (int32, bool) {
+	d := time.Until(expiry)
+	if d <= 0 {
+		return 0, false
+	}
+	if d > maxRelativeExpiration {
+		return int32(expiry.Unix() + 1), true
+	}
+	return int32((d + time.Second - 1) / time.Second), true
+}
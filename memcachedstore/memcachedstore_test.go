@@ -0,0 +1,271 @@
+package memcachedstore
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aberlorn/scs/v2"
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+var _ scs.Store = (*MemcachedStore)(nil)
+
+// newTestClient returns a client for the Memcached server at
+// SCS_MEMCACHED_TEST_ADDR, or for an in-process fake server supporting the
+// get, set and delete commands if it is not set.
+func newTestClient(t *testing.T) *memcache.Client {
+	addr := os.Getenv("SCS_MEMCACHED_TEST_ADDR")
+	if addr == "" {
+		return memcache.New(startFakeServer(t))
+	}
+	client := memcache.New(addr)
+	if err := client.DeleteAll(); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func startFakeServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var mu sync.Mutex
+	items := make(map[string][]byte)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+				for {
+					line, err := rw.ReadString('\n')
+					if err != nil {
+						return
+					}
+					fields := strings.Fields(line)
+					if len(fields) == 0 {
+						return
+					}
+
+					mu.Lock()
+					switch fields[0] {
+					case "get", "gets":
+						for _, key := range fields[1:] {
+							if b, ok := items[key]; ok {
+								fmt.Fprintf(rw, "VALUE %s 0 %d 1\r\n%s\r\n", key, len(b), b)
+							}
+						}
+						rw.WriteString("END\r\n")
+					case "set":
+						n, _ := strconv.Atoi(fields[4])
+						b := make([]byte, n+2)
+						if _, err := io.ReadFull(rw, b); err != nil {
+							mu.Unlock()
+							return
+						}
+						items[fields[1]] = b[:n]
+						rw.WriteString("STORED\r\n")
+					case "delete":
+						if _, ok := items[fields[1]]; ok {
+							delete(items, fields[1])
+							rw.WriteString("DELETED\r\n")
+						} else {
+							rw.WriteString("NOT_FOUND\r\n")
+						}
+					default:
+						rw.WriteString("ERROR\r\n")
+					}
+					mu.Unlock()
+					rw.Flush()
+				}
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestFind(t *testing.T) {
+	client := newTestClient(t)
+	m := New(client)
+
+	err := client.Set(&memcache.Item{Key: m.prefix + "session_token", Value: []byte("encoded_data")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, found, err := m.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+	if bytes.Equal(b, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("encoded_data"))
+	}
+}
+
+func TestFindMissing(t *testing.T) {
+	m := New(newTestClient(t))
+
+	_, found, err := m.Find("missing_session_token")
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestSaveNew(t *testing.T) {
+	client := newTestClient(t)
+	m := New(client)
+
+	err := m.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := client.Get(m.prefix + "session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(item.Value, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", item.Value, []byte("encoded_data"))
+	}
+}
+
+func TestSaveUpdated(t *testing.T) {
+	client := newTestClient(t)
+	m := New(client)
+
+	err := m.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Commit("session_token", []byte("new_encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _, err := m.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(b, []byte("new_encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("new_encoded_data"))
+	}
+}
+
+func TestSaveExpired(t *testing.T) {
+	m := New(newTestClient(t))
+
+	err := m.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Commit("session_token", []byte("encoded_data"), time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, found, err := m.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	m := New(newTestClient(t))
+
+	err := m.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Delete("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, found, err := m.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+
+	// Deleting a missing token is a no-op.
+	err = m.Delete("session_token")
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+}
+
+func TestLongToken(t *testing.T) {
+	m := New(newTestClient(t))
+	token := strings.Repeat("x", 300)
+
+	if len(m.key(token)) > maxKeyLength {
+		t.Fatalf("got %d: expected at most %d", len(m.key(token)), maxKeyLength)
+	}
+	if m.key(token) == m.key(strings.Repeat("x", 301)) {
+		t.Fatal("got the same key for different tokens")
+	}
+	if m.key("with space") == m.prefix+"with space" {
+		t.Fatalf("got %q: expected a hashed key", m.key("with space"))
+	}
+
+	err := m.Commit(token, []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, found, err := m.Find(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+	if bytes.Equal(b, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("encoded_data"))
+	}
+}
+
+func TestMakeExpiration(t *testing.T) {
+	expiration, ok := makeExpiration(time.Now().Add(90 * time.Second))
+	if !ok || expiration < 90 || expiration > 91 {
+		t.Fatalf("got %d, %v: expected about %d", expiration, ok, 90)
+	}
+
+	expiry := time.Now().Add(60 * 24 * time.Hour)
+	expiration, ok = makeExpiration(expiry)
+	if !ok || int64(expiration) != expiry.Unix()+1 {
+		t.Fatalf("got %d, %v: expected %d", expiration, ok, expiry.Unix()+1)
+	}
+
+	_, ok = makeExpiration(time.Now().Add(-time.Second))
+	if ok {
+		t.Fatalf("got %v: expected %v", ok, false)
+	}
+}
@@ -1,6 +1,7 @@
 package memstore
 
 import (
+	"container/list"
 	"errors"
 	"sync"
 	"time"
@@ -11,6 +12,7 @@ var errTypeAssertionFailed = errors.New("type assertion failed: could not conver
 type item struct {
 	object     interface{}
 	expiration int64
+	elem       *list.Element
 }
 
 // MemStore represents the session store.
@@ -18,6 +20,25 @@ type MemStore struct {
 	items       map[string]item
 	mu          sync.RWMutex
 	stopCleanup chan bool
+
+	// lru orders the tokens from the most to the least recently used when the
+	// number of entries is limited, and is nil otherwise.
+	lru        *list.List
+	maxEntries int
+}
+
+// Config holds the settings for a MemStore created with NewWithConfig.
+type Config struct {
+	// CleanupInterval controls how frequently expired session data is removed
+	// by the background cleanup goroutine. It defaults to one minute if zero,
+	// and setting it to a negative value prevents the cleanup goroutine from
+	// running.
+	CleanupInterval time.Duration
+
+	// MaxEntries is the maximum number of sessions held by the MemStore. Once
+	// it is reached, committing a new session evicts the least recently used
+	// one, where both Find and Commit count as a use. Zero means no limit.
+	MaxEntries int
 }
 
 // New returns a new MemStore instance, with a background cleanup goroutine that
@@ -42,12 +63,61 @@ func NewWithCleanupInterval(cleanupInterval time.Duration) *MemStore {
 	return m
 }
 
+// NewWithConfig returns a new MemStore instance with the given settings.
+// Limiting the number of entries stops a flood of requests each creating a
+// new session from growing the store without bound.
+func NewWithConfig(config Config) *MemStore {
+	cleanupInterval := config.CleanupInterval
+	if cleanupInterval == 0 {
+		cleanupInterval = time.Minute
+	}
+
+	m := NewWithCleanupInterval(0)
+	if config.MaxEntries > 0 {
+		m.lru = list.New()
+		m.maxEntries = config.MaxEntries
+	}
+
+	if cleanupInterval > 0 {
+		go m.startCleanup(cleanupInterval)
+	}
+
+	return m
+}
+
+// lockForFind locks the MemStore for a lookup, and returns the function to
+// unlock it. Lookups only need a read lock unless they must also record the
+// use of an entry in the LRU list.
+func (m *MemStore) lockForFind() func() {
+	if m.lru != nil {
+		m.mu.Lock()
+		return m.mu.Unlock
+	}
+	m.mu.RLock()
+	return m.mu.RUnlock
+}
+
+// touch marks an entry as the most recently used. The caller must hold the
+// write lock.
+func (m *MemStore) touch(item item) {
+	if m.lru != nil && item.elem != nil {
+		m.lru.MoveToFront(item.elem)
+	}
+}
+
+// remove deletes the entry for token. The caller must hold the write lock.
+func (m *MemStore) remove(token string, item item) {
+	if m.lru != nil && item.elem != nil {
+		m.lru.Remove(item.elem)
+	}
+	delete(m.items, token)
+}
+
 // Find returns the data for a given session token from the MemStore instance.
 // If the session token is not found or is expired, the returned exists flag will
 // be set to false.
 func (m *MemStore) Find(token string) ([]byte, bool, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	defer m.lockForFind()()
 
 	item, found := m.items[token]
 	if !found {
@@ -61,20 +131,68 @@ func (m *MemStore) Find(token string) ([]byte, bool, error) {
 	if !ok {
 		return nil, true, errTypeAssertionFailed
 	}
+	m.touch(item)
 
 	return b, true, nil
 }
 
+// FindWithExpiry returns the data and expiry time for a given session token
+// from the MemStore instance. If the session token is not found or is expired,
+// the returned exists flag will be set to false.
+func (m *MemStore) FindWithExpiry(token string) ([]byte, time.Time, bool, error) {
+	defer m.lockForFind()()
+
+	item, found := m.items[token]
+	if !found {
+		return nil, time.Time{}, false, nil
+	}
+	if time.Now().UnixNano() > item.expiration {
+		return nil, time.Time{}, false, nil
+	}
+
+	b, ok := item.object.([]byte)
+	if !ok {
+		return nil, time.Time{}, true, errTypeAssertionFailed
+	}
+	m.touch(item)
+
+	return b, time.Unix(0, item.expiration), true, nil
+}
+
 // Commit adds a session token and data to the MemStore instance with the given
 // expiry time. If the session token already exists, then the data and expiry
-// time are updated.
+// time are updated. If the MemStore was created with a MaxEntries limit and
+// it has been reached, the least recently used session is evicted to make
+// room for a new one.
 func (m *MemStore) Commit(token string, b []byte, expiry time.Time) error {
 	m.mu.Lock()
-	m.items[token] = item{
+	defer m.mu.Unlock()
+
+	existing, found := m.items[token]
+	it := item{
 		object:     b,
 		expiration: expiry.UnixNano(),
 	}
-	m.mu.Unlock()
+	if m.lru != nil {
+		if found && existing.elem != nil {
+			it.elem = existing.elem
+			m.lru.MoveToFront(it.elem)
+		} else {
+			it.elem = m.lru.PushFront(token)
+		}
+	}
+	m.items[token] = it
+
+	if m.lru != nil {
+		for len(m.items) > m.maxEntries {
+			oldest := m.lru.Back()
+			if oldest == nil {
+				break
+			}
+			t := oldest.Value.(string)
+			m.remove(t, m.items[t])
+		}
+	}
 
 	return nil
 }
@@ -83,19 +201,39 @@ func (m *MemStore) Commit(token string, b []byte, expiry time.Time) error {
 // instance.
 func (m *MemStore) Delete(token string) error {
 	m.mu.Lock()
-	delete(m.items, token)
+	if item, found := m.items[token]; found {
+		m.remove(token, item)
+	}
 	m.mu.Unlock()
 
 	return nil
 }
 
+// Len returns the number of unexpired sessions in the MemStore instance.
+// Expired entries which have not yet been removed by the cleanup goroutine
+// are not counted.
+func (m *MemStore) Len() (int, error) {
+	now := time.Now().UnixNano()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n := 0
+	for _, item := range m.items {
+		if now <= item.expiration {
+			n++
+		}
+	}
+	return n, nil
+}
+
 func (m *MemStore) startCleanup(interval time.Duration) {
 	m.stopCleanup = make(chan bool)
 	ticker := time.NewTicker(interval)
 	for {
 		select {
 		case <-ticker.C:
-			m.deleteExpired()
+			m.DeleteExpired()
 		case <-m.stopCleanup:
 			ticker.Stop()
 			return
@@ -119,13 +257,21 @@ func (m *MemStore) StopCleanup() {
 	}
 }
 
-func (m *MemStore) deleteExpired() {
+// DeleteExpired removes all expired session data from the MemStore instance
+// and returns the number of sessions removed. It is called by the background
+// cleanup goroutine, and implements scs.GarbageCollectable.
+func (m *MemStore) DeleteExpired() (int, error) {
 	now := time.Now().UnixNano()
+
 	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
 	for token, item := range m.items {
 		if now > item.expiration {
-			delete(m.items, token)
+			m.remove(token, item)
+			n++
 		}
 	}
-	m.mu.Unlock()
+	return n, nil
 }
@@ -3,6 +3,7 @@ package memstore
 import (
 	"bytes"
 	"reflect"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -45,6 +46,38 @@ func TestFindBadData(t *testing.T) {
 	}
 }
 
+func TestFindWithExpiry(t *testing.T) {
+	m := NewWithCleanupInterval(0)
+	expiry := time.Now().Add(time.Minute)
+
+	err := m.Commit("session_token", []byte("encoded_data"), expiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, gotExpiry, found, err := m.FindWithExpiry("session_token")
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+	if bytes.Equal(b, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("encoded_data"))
+	}
+	if gotExpiry.Equal(expiry) == false {
+		t.Fatalf("got %v: expected %v", gotExpiry, expiry)
+	}
+
+	_, _, found, err = m.FindWithExpiry("missing_session_token")
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
 func TestCommitNew(t *testing.T) {
 	m := NewWithCleanupInterval(0)
 
@@ -123,3 +156,97 @@ func TestDelete(t *testing.T) {
 		t.Fatalf("got %v: expected %v", found, false)
 	}
 }
+
+func TestDeleteExpired(t *testing.T) {
+	m := NewWithCleanupInterval(0)
+	m.items["session_token_1"] = item{object: []byte("encoded_data"), expiration: time.Now().Add(time.Minute).UnixNano()}
+	m.items["session_token_2"] = item{object: []byte("encoded_data"), expiration: time.Now().Add(time.Minute).UnixNano()}
+	m.items["expired_token_1"] = item{object: []byte("encoded_data"), expiration: time.Now().Add(-time.Second).UnixNano()}
+	m.items["expired_token_2"] = item{object: []byte("encoded_data"), expiration: time.Now().Add(-time.Minute).UnixNano()}
+
+	n, err := m.DeleteExpired()
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+	if n != 2 {
+		t.Fatalf("got %d: expected %d", n, 2)
+	}
+	if len(m.items) != 2 {
+		t.Fatalf("got %d: expected %d", len(m.items), 2)
+	}
+	for _, token := range []string{"session_token_1", "session_token_2"} {
+		if _, found := m.items[token]; !found {
+			t.Fatalf("got %v: expected %v", found, true)
+		}
+	}
+}
+
+func TestLen(t *testing.T) {
+	m := NewWithCleanupInterval(0)
+	m.items["session_token_1"] = item{object: []byte("encoded_data"), expiration: time.Now().Add(time.Minute).UnixNano()}
+	m.items["session_token_2"] = item{object: []byte("encoded_data"), expiration: time.Now().Add(time.Minute).UnixNano()}
+	m.items["session_token_3"] = item{object: []byte("encoded_data"), expiration: time.Now().Add(time.Minute).UnixNano()}
+	m.items["expired_token"] = item{object: []byte("encoded_data"), expiration: time.Now().Add(-time.Second).UnixNano()}
+
+	n, err := m.Len()
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+	if n != 3 {
+		t.Fatalf("got %d: expected %d", n, 3)
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+	m := NewWithConfig(Config{CleanupInterval: -1, MaxEntries: 3})
+	expiry := time.Now().Add(time.Minute)
+
+	for _, token := range []string{"token_1", "token_2", "token_3"} {
+		if err := m.Commit(token, []byte("encoded_data"), expiry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Using token_1 makes token_2 the least recently used.
+	if _, found, _ := m.Find("token_1"); found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+	if err := m.Commit("token_4", []byte("encoded_data"), expiry); err != nil {
+		t.Fatal(err)
+	}
+	// Updating token_3 makes token_1 the least recently used.
+	if err := m.Commit("token_3", []byte("new_encoded_data"), expiry); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Commit("token_5", []byte("encoded_data"), expiry); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.items) != 3 || m.lru.Len() != 3 {
+		t.Fatalf("got %d, %d: expected %d, %d", len(m.items), m.lru.Len(), 3, 3)
+	}
+	for token, expected := range map[string]bool{"token_1": false, "token_2": false, "token_3": true, "token_4": true, "token_5": true} {
+		if _, found, _ := m.Find(token); found != expected {
+			t.Fatalf("%s: got %v: expected %v", token, found, expected)
+		}
+	}
+
+	if err := m.Delete("token_3"); err != nil {
+		t.Fatal(err)
+	}
+	if m.lru.Len() != 2 {
+		t.Fatalf("got %d: expected %d", m.lru.Len(), 2)
+	}
+}
+
+func TestNewUnbounded(t *testing.T) {
+	m := NewWithCleanupInterval(0)
+	for i := 0; i < 100; i++ {
+		if err := m.Commit(strconv.Itoa(i), []byte("encoded_data"), time.Now().Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(m.items) != 100 || m.lru != nil {
+		t.Fatalf("got %d, %v: expected %d, %v", len(m.items), m.lru, 100, nil)
+	}
+}
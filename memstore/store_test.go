@@ -0,0 +1,28 @@
+package memstore_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aberlorn/scs/v2"
+	"github.com/aberlorn/scs/v2/memstore"
+	"github.com/aberlorn/scs/v2/scstest"
+)
+
+// The assertions live in an external test package, as scs imports memstore.
+var (
+	_ scs.Store              = (*memstore.MemStore)(nil)
+	_ scs.CountableStore     = (*memstore.MemStore)(nil)
+	_ scs.GarbageCollectable = (*memstore.MemStore)(nil)
+	_ scs.ExpiryStore        = (*memstore.MemStore)(nil)
+)
+
+func TestStoreInterfaces(t *testing.T) {
+	m := memstore.NewWithConfig(memstore.Config{CleanupInterval: -1})
+
+	got := scstest.StoreInterfaces(m)
+	expected := []string{"CountableStore", "GarbageCollectable", "ExpiryStore"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got %v: expected %v", got, expected)
+	}
+}
@@ -0,0 +1,48 @@
+package scs
+
+import "time"
+
+// Metrics is the interface for recording session metrics, such as counters
+// for a Prometheus exporter. Implementations must be safe for concurrent use.
+type Metrics interface {
+	// IncCreated is called when a new session is committed for the first
+	// time.
+	IncCreated()
+
+	// IncLoaded is called when an existing session is loaded from the
+	// session store.
+	IncLoaded()
+
+	// IncCommitted is called when the session data is committed to the
+	// session store.
+	IncCommitted()
+
+	// IncDestroyed is called when a session is destroyed.
+	IncDestroyed()
+
+	// ObserveCommitLatency is called with the time taken by the session
+	// store to commit the session data, or to touch it (see TouchableStore).
+	ObserveCommitLatency(d time.Duration)
+
+	// IncStoreError is called when a session store operation fails, with op
+	// set to "find", "commit", "touch" or "delete".
+	IncStoreError(op string)
+}
+
+// NoopMetrics is a Metrics implementation which records nothing. It is the
+// default for new sessions.
+type NoopMetrics struct{}
+
+func (NoopMetrics) IncCreated()                          {}
+func (NoopMetrics) IncLoaded()                           {}
+func (NoopMetrics) IncCommitted()                        {}
+func (NoopMetrics) IncDestroyed()                        {}
+func (NoopMetrics) ObserveCommitLatency(d time.Duration) {}
+func (NoopMetrics) IncStoreError(op string)              {}
+
+func (s *Session) metrics() Metrics {
+	if s.Metrics == nil {
+		return NoopMetrics{}
+	}
+	return s.Metrics
+}
@@ -0,0 +1,111 @@
+package scs
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingMetrics counts the calls to each Metrics method.
+type recordingMetrics struct {
+	mu          sync.Mutex
+	counts      map[string]int
+	storeErrors []string
+}
+
+func (rm *recordingMetrics) inc(name string) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	if rm.counts == nil {
+		rm.counts = make(map[string]int)
+	}
+	rm.counts[name]++
+}
+
+func (rm *recordingMetrics) IncCreated()   { rm.inc("created") }
+func (rm *recordingMetrics) IncLoaded()    { rm.inc("loaded") }
+func (rm *recordingMetrics) IncCommitted() { rm.inc("committed") }
+func (rm *recordingMetrics) IncDestroyed() { rm.inc("destroyed") }
+
+func (rm *recordingMetrics) ObserveCommitLatency(d time.Duration) {
+	if d < 0 {
+		panic("negative commit latency")
+	}
+	rm.inc("commitLatency")
+}
+
+func (rm *recordingMetrics) IncStoreError(op string) {
+	rm.inc("storeError")
+	rm.mu.Lock()
+	rm.storeErrors = append(rm.storeErrors, op)
+	rm.mu.Unlock()
+}
+
+func TestMetrics(t *testing.T) {
+	s := NewSession()
+	rm := &recordingMetrics{}
+	s.Metrics = rm
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	token, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "baz")
+	if _, _, err = s.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Destroy(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{
+		"created":       1,
+		"loaded":        1,
+		"committed":     2,
+		"commitLatency": 2,
+		"destroyed":     1,
+	}
+	if !reflect.DeepEqual(rm.counts, want) {
+		t.Errorf("got %v: expected %v", rm.counts, want)
+	}
+}
+
+func TestMetricsStoreErrors(t *testing.T) {
+	s := NewSession()
+	rm := &recordingMetrics{}
+	s.Metrics = rm
+	s.Store = &failingStore{Store: s.Store, failCommit: true}
+
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Put(ctx, "foo", "bar")
+	if _, _, err := s.Commit(ctx); err == nil {
+		t.Fatal("expected commit to fail")
+	}
+
+	if !reflect.DeepEqual(rm.storeErrors, []string{"commit"}) {
+		t.Errorf("got %v: expected %v", rm.storeErrors, []string{"commit"})
+	}
+	if rm.counts["created"] != 0 || rm.counts["committed"] != 0 {
+		t.Errorf("got %v: expected no created or committed sessions", rm.counts)
+	}
+
+	// A nil Metrics is treated as NoopMetrics.
+	s.Metrics = nil
+	if _, _, err := s.Commit(ctx); err == nil {
+		t.Fatal("expected commit to fail")
+	}
+}
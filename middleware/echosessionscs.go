@@ -1,8 +1,9 @@
 package middleware
 
 import (
-	"encoding/gob"
 	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/aberlorn/scs/v2"
@@ -23,25 +24,96 @@ type EchoSessionSCS struct {
 	IdleTimeoutMinutes int    `json:"idleTimeoutMinutes"`
 	LifetimeMinutes    int    `json:"lifetimeMinutes"`
 
-	GOBInterfaces []interface{}
+	// Cookie settings applied to Session.Cookie by Initialize. Empty or nil
+	// values leave the corresponding Session.Cookie setting unchanged.
+	// CookieSameSite accepts "lax", "strict", "none" or "omit" (no SameSite
+	// attribute).
+	CookieName     string `json:"cookieName"`
+	CookieDomain   string `json:"cookieDomain"`
+	CookiePath     string `json:"cookiePath"`
+	CookieHttpOnly *bool  `json:"cookieHttpOnly"`
+	CookieSecure   *bool  `json:"cookieSecure"`
+	CookieSameSite string `json:"cookieSameSite"`
+
+	// GOBInterfaces are registered with Session.RegisterType by Initialize.
+	// If ValidateGOBInterfaces is set, Initialize also checks that values of
+	// each type can be encoded (see Session.ValidateTypes), so a type which
+	// can't be stored is reported at startup rather than when a request
+	// commits its session.
+	GOBInterfaces         []interface{}
+	ValidateGOBInterfaces bool `json:"validateGobInterfaces"`
 }
 
+// EchoSessionSCS gets LoadCheck and SaveCheck from the embedded *scs.Session,
+// so the default type can be used by SessionsWithConfig as it is.
+var _ IEchoSessionSCS = (*EchoSessionSCS)(nil)
+
 func (s *EchoSessionSCS) GetSession() *EchoSessionSCS {
 	return s
 }
 
 // Initialize translates minute values for IdleTimout and Lifetime
-// to Duration. Gobs are registered which is required for scs
-// session encoding.
+// to Duration, validates the TokenEncoding, applies the cookie settings to
+// Session.Cookie and validates the cookie name. Gobs
+// are registered which is required for scs session encoding, and validated if
+// ValidateGOBInterfaces is set.
 func (s *EchoSessionSCS) Initialize() error {
 	s.Session.Lifetime = s.GetLifetime()
 	s.IdleTimeout = s.GetIdleTimeout()
 
+	if err := s.TokenEncoding.Validate(); err != nil {
+		return err
+	}
+
+	if err := s.initializeCookie(); err != nil {
+		return err
+	}
+	if err := s.Cookie.ValidateName(); err != nil {
+		return err
+	}
+
 	for _, i := range s.GOBInterfaces {
 		if i != nil {
-			gob.Register(i)
+			s.RegisterType(i)
 		}
 	}
+	if s.ValidateGOBInterfaces {
+		return s.ValidateTypes()
+	}
+
+	return nil
+}
+
+func (s *EchoSessionSCS) initializeCookie() error {
+	if s.CookieName != "" {
+		s.Cookie.Name = s.CookieName
+	}
+	if s.CookieDomain != "" {
+		s.Cookie.Domain = s.CookieDomain
+	}
+	if s.CookiePath != "" {
+		s.Cookie.Path = s.CookiePath
+	}
+	if s.CookieHttpOnly != nil {
+		s.Cookie.HttpOnly = *s.CookieHttpOnly
+	}
+	if s.CookieSecure != nil {
+		s.Cookie.Secure = *s.CookieSecure
+	}
+
+	switch strings.ToLower(s.CookieSameSite) {
+	case "":
+	case "lax":
+		s.Cookie.SameSite = http.SameSiteLaxMode
+	case "strict":
+		s.Cookie.SameSite = http.SameSiteStrictMode
+	case "none":
+		s.Cookie.SameSite = http.SameSiteNoneMode
+	case "omit":
+		s.Cookie.SameSite = 0
+	default:
+		return fmt.Errorf("invalid cookieSameSite value %q", s.CookieSameSite)
+	}
 
 	return nil
 }
@@ -68,6 +140,26 @@ type SessionsConfig struct {
 	Session IEchoSessionSCS // *EchoSessionSCS
 	// Cache this configuration
 	DoCache bool
+	// AutoSave saves the session when the response header is first written
+	// (e.g. by c.Redirect or c.String), or after the handler returns if it
+	// wrote no response, instead of before the handler runs. Handlers then
+	// do not need to call SaveCheck themselves. The default is false.
+	AutoSave bool
+
+	// RequireSession rejects requests which do not carry the token of an
+	// existing session, returning RequireSessionError before the handler
+	// runs, instead of creating a new empty session. This suits APIs behind
+	// authentication. The default is false.
+	RequireSession bool
+
+	// RequireSessionError is returned for requests rejected by
+	// RequireSession. The default is echo.ErrUnauthorized.
+	RequireSessionError error
+
+	// ViewKey, if set, is the echo.Context key under which the middleware
+	// stores a read-only *SessionView of the loaded session, for use by
+	// templates (e.g. "session"). The default is not to store one.
+	ViewKey string
 }
 
 var (
@@ -115,10 +207,32 @@ func SessionsWithConfig(config *SessionsConfig) echo.MiddlewareFunc {
 				return next(c)
 			}
 
+			// Release the token lock taken when SerializeByToken is set, even
+			// if LoadCheck fails after taking it.
+			defer config.Session.GetSession().Release(c)
 			if err := config.Session.LoadCheck(c); err != nil {
 				return fmt.Errorf("could not load the session in SessionsWithConfig; %v", err)
 			}
 
+			if config.RequireSession && config.Session.GetSession().Token(c) == "" {
+				if config.RequireSessionError != nil {
+					return config.RequireSessionError
+				}
+				return echo.ErrUnauthorized
+			}
+
+			if config.ViewKey != "" {
+				c.Set(config.ViewKey, NewSessionView(c, config.Session.GetSession().Session))
+			}
+
+			// Save changes made before a panic in the handler. This runs
+			// before the token lock is released.
+			defer saveOnPanic(c, config.Session)
+
+			if config.AutoSave {
+				return autoSave(c, config.Session, next)
+			}
+
 			// If a token has not been created, be certain to save it and write headers.
 			// This code only saves to the DB on `Modified` or `Destroyed` or when token == "".
 			if err := config.Session.SaveCheck(c); err != nil {
@@ -136,3 +250,50 @@ func SessionsWithConfig(config *SessionsConfig) echo.MiddlewareFunc {
 		}
 	}
 }
+
+// saveOnPanic is deferred by the middleware. If the handler panics before the
+// response has been written, it saves the session and then re-panics, so that
+// changes made before the panic are committed and the session cookie is
+// included in the error response written by a recover middleware. For the
+// cookie to be sent, echo's Recover middleware must be registered before
+// (i.e. outside) the Sessions middleware, so that it handles the panic after
+// saveOnPanic. An error from the save is discarded in favour of the panic.
+func saveOnPanic(c echo.Context, session IEchoSessionSCS) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if !c.Response().Committed {
+		_ = session.SaveCheck(c)
+	}
+	panic(r)
+}
+
+// autoSave calls next, saving the session just before the response header is
+// written so the session cookie is included even on redirects. If next writes
+// no response, including when it returns an error or panics, the session is
+// saved before autoSave returns: the response written afterwards by echo's
+// HTTPErrorHandler or a recover middleware doesn't save it again, as by then
+// the token lock has been released.
+func autoSave(c echo.Context, session IEchoSessionSCS, next echo.HandlerFunc) error {
+	var saveErr error
+	done := false
+	defer func() { done = true }()
+	c.Response().Before(func() {
+		if !done {
+			saveErr = session.SaveCheck(c)
+		}
+	})
+
+	err := next(c)
+	if !c.Response().Committed {
+		saveErr = session.SaveCheck(c)
+	}
+	if err != nil {
+		return err
+	}
+	if saveErr != nil {
+		return fmt.Errorf("could not save the session in SessionsWithConfig; %v", saveErr)
+	}
+	return nil
+}
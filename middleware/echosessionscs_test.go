@@ -1,14 +1,19 @@
 package middleware
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/aberlorn/scs/v2"
 	"github.com/labstack/echo/v4"
+	emidware "github.com/labstack/echo/v4/middleware"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -392,3 +397,372 @@ func TestLoadCheckObject(t *testing.T) {
 		t.Fatalf("post-test session cache should be 0 but it is %d", SessionCache().Length())
 	}
 }
+
+func TestInitializeCookieConfig(t *testing.T) {
+	config := []byte(`{
+		"idleTimeoutMinutes": 20,
+		"lifetimeMinutes": 60,
+		"cookieName": "app_session",
+		"cookieDomain": "example.com",
+		"cookiePath": "/app",
+		"cookieHttpOnly": false,
+		"cookieSecure": true,
+		"cookieSameSite": "strict"
+	}`)
+
+	s := &EchoSessionSCS{Session: scs.NewSession()}
+	if err := json.Unmarshal(config, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 20*time.Minute, s.IdleTimeout)
+	assert.Equal(t, 60*time.Minute, s.Lifetime)
+	assert.Equal(t, "app_session", s.Cookie.Name)
+	assert.Equal(t, "example.com", s.Cookie.Domain)
+	assert.Equal(t, "/app", s.Cookie.Path)
+	assert.False(t, s.Cookie.HttpOnly)
+	assert.True(t, s.Cookie.Secure)
+	assert.Equal(t, http.SameSiteStrictMode, s.Cookie.SameSite)
+
+	// Unset values keep the scs defaults.
+	s = &EchoSessionSCS{Session: scs.NewSession()}
+	assert.NoError(t, s.Initialize())
+	assert.Equal(t, "session", s.Cookie.Name)
+	assert.Equal(t, "/", s.Cookie.Path)
+	assert.True(t, s.Cookie.HttpOnly)
+	assert.False(t, s.Cookie.Secure)
+	assert.Equal(t, http.SameSiteLaxMode, s.Cookie.SameSite)
+
+	s = &EchoSessionSCS{Session: scs.NewSession(), CookieSameSite: "sometimes"}
+	assert.Error(t, s.Initialize())
+
+	s = &EchoSessionSCS{Session: scs.NewSession()}
+	s.TokenEncoding = scs.TokenEncoding(99)
+	assert.Error(t, s.Initialize())
+}
+
+func TestInitializeCookieName(t *testing.T) {
+	s := &EchoSessionSCS{Session: scs.NewSession(), CookieName: "__Host-app_session.v2"}
+	assert.NoError(t, s.Initialize())
+
+	for _, name := range []string{"my session", "a,b", "a;b", "a=b", "a:b", "a\\b", "a\"b", "a\tb", "a\x7fb", "séance"} {
+		s := &EchoSessionSCS{Session: scs.NewSession(), CookieName: name}
+		assert.Error(t, s.Initialize(), name)
+	}
+}
+
+type gobValue struct {
+	Value interface{}
+}
+
+type gobUnregistered struct{}
+
+func TestInitializeValidateGOBInterfaces(t *testing.T) {
+	s := &EchoSessionSCS{
+		Session:       scs.NewSession(),
+		GOBInterfaces: []interface{}{gobValue{Value: gobUnregistered{}}},
+	}
+	assert.NoError(t, s.Initialize())
+
+	s.ValidateGOBInterfaces = true
+	assert.Error(t, s.Initialize())
+}
+
+func TestMiddlewareDefaultEndToEnd(t *testing.T) {
+	if SessionCache().Length() != 0 {
+		t.Fatalf("pre-test session cache should be 0 but it is %d", SessionCache().Length())
+	}
+	defer SessionCache().Remove("session")
+
+	e := echo.New()
+	e.Use(Sessions())
+	e.GET("/put", func(c echo.Context) error {
+		session := SessionCache().Get("session").Session.GetSession()
+		session.Put(c, "message", "Ipso Facto")
+		if err := session.SaveCheck(c); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusOK)
+	})
+	e.GET("/get", func(c echo.Context) error {
+		session := SessionCache().Get("session").Session.GetSession()
+		return c.String(http.StatusOK, session.GetString(c, "message"))
+	})
+
+	ts := httptest.NewServer(e)
+	defer ts.Close()
+
+	rs, err := ts.Client().Get(ts.URL + "/put")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+	cookies := rs.Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "session" || cookies[0].Value == "" {
+		t.Fatalf("want a session cookie; got %v", cookies)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/get", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(cookies[0])
+	rs, err = ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+	body, err := ioutil.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "Ipso Facto", string(body))
+}
+
+func TestMiddlewareAutoSave(t *testing.T) {
+	session := &EchoSessionSCS{Session: scs.NewSession()}
+
+	e := echo.New()
+	e.Use(SessionsWithConfig(&SessionsConfig{
+		Session:  session,
+		AutoSave: true,
+	}))
+	e.GET("/login", func(c echo.Context) error {
+		session.Put(c, "message", "Ipso Facto")
+		return c.Redirect(http.StatusFound, "/get")
+	})
+	e.GET("/put", func(c echo.Context) error {
+		session.Put(c, "message", "Lorem Ipsum")
+		return nil
+	})
+	e.GET("/get", func(c echo.Context) error {
+		return c.String(http.StatusOK, session.GetString(c, "message"))
+	})
+
+	ts := httptest.NewServer(e)
+	defer ts.Close()
+	client := ts.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	get := func(path string, cookies []*http.Cookie) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rs, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rs.Body.Close()
+		body, err := ioutil.ReadAll(rs.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs, string(body)
+	}
+
+	// The session cookie survives a redirect.
+	rs, _ := get("/login", nil)
+	assert.Equal(t, http.StatusFound, rs.StatusCode)
+	cookies := rs.Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "session" || cookies[0].Value == "" {
+		t.Fatalf("want a session cookie; got %v", cookies)
+	}
+	rs, body := get("/get", cookies)
+	assert.Equal(t, "Ipso Facto", body)
+	assert.Empty(t, rs.Cookies())
+
+	// A handler which writes no response still saves the session.
+	rs, _ = get("/put", cookies)
+	if len(rs.Cookies()) != 1 {
+		t.Fatalf("want a session cookie; got %v", rs.Cookies())
+	}
+	_, body = get("/get", cookies)
+	assert.Equal(t, "Lorem Ipsum", body)
+}
+
+// orderLogger records the debug messages it is given, and events added by a
+// test, in the order they happen.
+type orderLogger struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *orderLogger) Debug(msg string, keysAndValues ...interface{}) { l.add(msg) }
+func (l *orderLogger) Error(msg string, keysAndValues ...interface{}) { l.add(msg) }
+
+func (l *orderLogger) add(event string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
+
+func TestMiddlewareAutoSaveHandlerError(t *testing.T) {
+	session := &EchoSessionSCS{Session: scs.NewSession()}
+	session.SerializeByToken = true
+	logger := &orderLogger{}
+	session.Logger = logger
+
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := next(c)
+			logger.add("middleware returned")
+			return err
+		}
+	})
+	e.Use(SessionsWithConfig(&SessionsConfig{
+		Session:  session,
+		AutoSave: true,
+	}))
+	e.GET("/fail", func(c echo.Context) error {
+		session.Put(c, "message", "Ipso Facto")
+		return echo.NewHTTPError(http.StatusBadRequest, "handler failed")
+	})
+
+	// The session is saved before the middleware returns and releases the
+	// token lock, not when echo's HTTPErrorHandler writes the response.
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fail", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	if len(rec.Result().Cookies()) != 1 {
+		t.Fatalf("want a session cookie; got %v", rec.Result().Cookies())
+	}
+	assert.Equal(t, []string{"scs: session loaded", "scs: session committed", "middleware returned"}, logger.events)
+}
+
+func TestMiddlewareSerializeByToken(t *testing.T) {
+	session := &EchoSessionSCS{Session: scs.NewSession()}
+	session.SerializeByToken = true
+
+	e := echo.New()
+	e.Use(SessionsWithConfig(&SessionsConfig{
+		Session:  session,
+		AutoSave: true,
+	}))
+	e.GET("/increment", func(c echo.Context) error {
+		n := session.GetInt(c, "counter")
+		time.Sleep(time.Millisecond)
+		session.Put(c, "counter", n+1)
+		return c.String(http.StatusOK, strconv.Itoa(n+1))
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/increment", nil))
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("want a session cookie; got %v", cookies)
+	}
+
+	// The lock is released after each request, so requests with the same
+	// token run one at a time rather than blocking forever.
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/increment", nil)
+			req.AddCookie(cookies[0])
+			e.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+	}
+	wg.Wait()
+
+	req := httptest.NewRequest(http.MethodGet, "/increment", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, "22", rec.Body.String())
+}
+
+func TestMiddlewareRequireSession(t *testing.T) {
+	session := &EchoSessionSCS{Session: scs.NewSession()}
+
+	// Create a session to present later.
+	c := echo.New().NewContext(httptest.NewRequest(echo.GET, "/", nil), httptest.NewRecorder())
+	assert.NoError(t, session.LoadCheck(c))
+	session.Put(c, "user", "alice")
+	token, _, err := session.Commit(c)
+	assert.NoError(t, err)
+
+	for _, tc := range []struct {
+		name       string
+		customErr  error
+		cookie     *http.Cookie
+		wantStatus int
+	}{
+		{"no cookie", nil, nil, http.StatusUnauthorized},
+		{"unknown token", nil, &http.Cookie{Name: "session", Value: "unknown_token"}, http.StatusUnauthorized},
+		{"custom error", echo.ErrForbidden, nil, http.StatusForbidden},
+		{"existing session", nil, &http.Cookie{Name: "session", Value: token}, http.StatusOK},
+	} {
+		e := echo.New()
+		e.Use(SessionsWithConfig(&SessionsConfig{
+			Session:             session,
+			RequireSession:      true,
+			RequireSessionError: tc.customErr,
+		}))
+		called := false
+		e.GET("/", func(c echo.Context) error {
+			called = true
+			return c.String(http.StatusOK, session.GetString(c, "user"))
+		})
+
+		req := httptest.NewRequest(echo.GET, "/", nil)
+		if tc.cookie != nil {
+			req.AddCookie(tc.cookie)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		assert.Equal(t, tc.wantStatus, rec.Code, tc.name)
+		assert.Equal(t, tc.wantStatus == http.StatusOK, called, tc.name)
+		if tc.wantStatus == http.StatusOK {
+			assert.Equal(t, "alice", rec.Body.String(), tc.name)
+		} else {
+			assert.Empty(t, rec.Header().Get(echo.HeaderSetCookie), tc.name)
+		}
+	}
+}
+
+func TestMiddlewareSaveOnPanic(t *testing.T) {
+	for _, autoSave := range []bool{false, true} {
+		session := &EchoSessionSCS{Session: scs.NewSession()}
+
+		e := echo.New()
+		e.Use(emidware.Recover())
+		e.Use(SessionsWithConfig(&SessionsConfig{
+			Session:  session,
+			AutoSave: autoSave,
+		}))
+		e.GET("/panic", func(c echo.Context) error {
+			session.Put(c, "message", "Ipso Facto")
+			panic("handler failed")
+		})
+		e.GET("/get", func(c echo.Context) error {
+			return c.String(http.StatusOK, session.GetString(c, "message"))
+		})
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(echo.GET, "/panic", nil))
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		cookies := (&http.Response{Header: rec.Header()}).Cookies()
+		if len(cookies) == 0 || cookies[len(cookies)-1].Value == "" {
+			t.Fatalf("autoSave %v: want a session cookie; got %v", autoSave, cookies)
+		}
+
+		req := httptest.NewRequest(echo.GET, "/get", nil)
+		req.AddCookie(cookies[len(cookies)-1])
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, "Ipso Facto", rec.Body.String(), "autoSave %v", autoSave)
+	}
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"fmt"
+
+	"github.com/aberlorn/scs/v2"
+	"github.com/labstack/echo/v4"
+)
+
+// DefaultHeaderName is the header used by HeaderSessionSCS when HeaderName
+// is not set.
+const DefaultHeaderName = "X-Session-Token"
+
+// HeaderSessionSCS communicates the session token in a request and response
+// header instead of a cookie. This suits single-page apps and mobile clients
+// which cannot always use cookies. No Set-Cookie header is ever written.
+type HeaderSessionSCS struct {
+	*EchoSessionSCS
+
+	// HeaderName is the request and response header carrying the session
+	// token. The default is "X-Session-Token".
+	HeaderName string `json:"headerName"`
+}
+
+// GetHeaderName returns the configured header name or DefaultHeaderName.
+func (s *HeaderSessionSCS) GetHeaderName() string {
+	if s.HeaderName == "" {
+		return DefaultHeaderName
+	}
+	return s.HeaderName
+}
+
+// LoadCheck loads the session data using the token from the request header,
+// or from the Session.TokenQueryParam query parameter if it is set and the
+// header is missing.
+func (s *HeaderSessionSCS) LoadCheck(c scs.SessionContext) error {
+	ec, ok := c.(echo.Context)
+	if !ok {
+		return fmt.Errorf("HeaderSessionSCS.LoadCheck requires an echo.Context but got %T", c)
+	}
+
+	token := ec.Request().Header.Get(s.GetHeaderName())
+	if token == "" && s.TokenQueryParam != "" {
+		token = ec.QueryParam(s.TokenQueryParam)
+	}
+
+	// LoadToken takes the token lock when SerializeByToken is set, which the
+	// middleware releases after the handler returns.
+	if err := s.LoadToken(c, token); err != nil {
+		return fmt.Errorf("func s.LoadToken failed in HeaderSessionSCS.LoadCheck; %v", err)
+	}
+
+	return nil
+}
+
+// SaveCheck commits Modified session data (or touches Unmodified session data
+// when an idle timeout is being used) and writes the token to the response
+// header. A Destroyed session results in an empty header value,
+// which tells the client to discard its token.
+func (s *HeaderSessionSCS) SaveCheck(c scs.SessionContext) error {
+	ec, ok := c.(echo.Context)
+	if !ok {
+		return fmt.Errorf("HeaderSessionSCS.SaveCheck requires an echo.Context but got %T", c)
+	}
+
+	if s.Status(c) == scs.Unmodified && s.NeedsRefresh(c) {
+		s.Touch(c)
+	}
+
+	switch s.Status(c) {
+	case scs.Modified:
+		token, _, err := s.Commit(c)
+		if err != nil {
+			return err
+		}
+		ec.Response().Header().Set(s.GetHeaderName(), token)
+	case scs.Destroyed:
+		ec.Response().Header().Set(s.GetHeaderName(), "")
+	}
+	return nil
+}
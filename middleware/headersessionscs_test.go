@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aberlorn/scs/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderSession(t *testing.T) {
+	if SessionCache().Length() != 0 {
+		t.Fatalf("pre-test session cache should be 0 but it is %d", SessionCache().Length())
+	}
+
+	e := echo.New()
+
+	scHeader := &SessionsConfig{
+		Session: &HeaderSessionSCS{EchoSessionSCS: &EchoSessionSCS{Session: scs.NewSession()}},
+	}
+	session := scHeader.Session.GetSession()
+
+	mw := SessionsWithConfig(scHeader)
+	h := mw(func(c echo.Context) error {
+		session.Put(c, "message", "Ipso Facto")
+		if err := scHeader.Session.SaveCheck(c); err != nil {
+			return err
+		}
+		return c.String(http.StatusOK, "ok")
+	})
+
+	req := httptest.NewRequest(echo.GET, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	assert.NoError(t, h(c))
+
+	token := rec.Header().Get(DefaultHeaderName)
+	assert.NotEmpty(t, token)
+	assert.Empty(t, rec.Header().Get(echo.HeaderSetCookie))
+
+	h = mw(func(c echo.Context) error {
+		return c.String(http.StatusOK, session.GetString(c, "message"))
+	})
+
+	req = httptest.NewRequest(echo.GET, "/", nil)
+	req.Header.Set(DefaultHeaderName, token)
+	rec = httptest.NewRecorder()
+	c = e.NewContext(req, rec)
+	assert.NoError(t, h(c))
+
+	assert.Equal(t, "Ipso Facto", rec.Body.String())
+	assert.Empty(t, rec.Header().Get(echo.HeaderSetCookie))
+
+	if SessionCache().Length() != 0 {
+		t.Fatalf("post-test session cache should be 0 but it is %d", SessionCache().Length())
+	}
+}
+
+func TestHeaderSessionCustomName(t *testing.T) {
+	e := echo.New()
+
+	s := &HeaderSessionSCS{EchoSessionSCS: &EchoSessionSCS{Session: scs.NewSession()}, HeaderName: "X-Auth-Session"}
+	assert.NoError(t, s.Initialize())
+
+	req := httptest.NewRequest(echo.GET, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	assert.NoError(t, s.LoadCheck(c))
+	s.Put(c, "foo", "bar")
+	assert.NoError(t, s.SaveCheck(c))
+
+	assert.NotEmpty(t, rec.Header().Get("X-Auth-Session"))
+	assert.Empty(t, rec.Header().Get(DefaultHeaderName))
+	assert.Empty(t, rec.Header().Get(echo.HeaderSetCookie))
+}
+
+func TestHeaderSessionQueryParam(t *testing.T) {
+	e := echo.New()
+
+	s := &HeaderSessionSCS{EchoSessionSCS: &EchoSessionSCS{Session: scs.NewSession()}}
+	s.TokenQueryParam = "sid"
+	assert.NoError(t, s.Initialize())
+
+	c := e.NewContext(httptest.NewRequest(echo.GET, "/", nil), httptest.NewRecorder())
+	assert.NoError(t, s.LoadCheck(c))
+	s.Put(c, "foo", "bar")
+	token, _, err := s.Commit(c)
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	c = e.NewContext(httptest.NewRequest(echo.GET, "/ws?sid="+token, nil), rec)
+	assert.NoError(t, s.LoadCheck(c))
+	assert.Equal(t, "bar", s.GetString(c, "foo"))
+	assert.NoError(t, s.SaveCheck(c))
+	assert.Empty(t, rec.Header().Get(DefaultHeaderName))
+}
+
+func TestHeaderSessionSerializeByToken(t *testing.T) {
+	session := &HeaderSessionSCS{EchoSessionSCS: &EchoSessionSCS{Session: scs.NewSession()}}
+	session.SerializeByToken = true
+
+	e := echo.New()
+	e.Use(SessionsWithConfig(&SessionsConfig{
+		Session:  session,
+		AutoSave: true,
+	}))
+	e.GET("/increment", func(c echo.Context) error {
+		n := session.GetInt(c, "counter")
+		time.Sleep(time.Millisecond)
+		session.Put(c, "counter", n+1)
+		return c.String(http.StatusOK, strconv.Itoa(n+1))
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/increment", nil))
+	token := rec.Header().Get(DefaultHeaderName)
+	assert.NotEmpty(t, token)
+
+	// Requests with the same token run one at a time, so no increment is
+	// lost.
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/increment", nil)
+			req.Header.Set(DefaultHeaderName, token)
+			e.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+	}
+	wg.Wait()
+
+	req := httptest.NewRequest(http.MethodGet, "/increment", nil)
+	req.Header.Set(DefaultHeaderName, token)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, "22", rec.Body.String())
+}
@@ -2,7 +2,10 @@ package middleware
 
 import (
 	"fmt"
+	"sort"
 	"sync"
+
+	"github.com/labstack/echo/v4"
 )
 
 var scache *sessionCache
@@ -68,10 +71,32 @@ func (sc *sessionCache) Length() int {
 	return len(sc.instances)
 }
 
-func (sc *sessionCache) Remove(key string) error {
+// Keys returns a sorted snapshot of all registered session keys.
+func (sc *sessionCache) Keys() []string {
 	sc.mu.RLock()
 	defer sc.mu.RUnlock()
 
+	keys := make([]string, 0, len(sc.instances))
+	for key := range sc.instances {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Clear removes every registered instance from the cache.
+func (sc *sessionCache) Clear() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.instances = make(map[string]*SessionsConfig)
+}
+
+func (sc *sessionCache) Remove(key string) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
 	if _, ok := sc.instances[key]; !ok {
 		return nil
 	}
@@ -80,3 +105,39 @@ func (sc *sessionCache) Remove(key string) error {
 
 	return nil
 }
+
+// DestroyAll destroys every session registered in the SessionCache for the
+// current request, such as when logging out of a site which uses several
+// sessions, and saves each one so that an expired cookie (or an empty header
+// for HeaderSessionSCS) is written for every configured name. Sessions which
+// have not been loaded on the request are loaded first, so any data stored
+// under a token the request carries is deleted too; a session the request
+// has no token for just gets the expired cookie. With AutoSave the sessions
+// may be saved again when the response is written, which repeats the same
+// expired cookie.
+func DestroyAll(c echo.Context) error {
+	for _, key := range SessionCache().Keys() {
+		config := SessionCache().Get(key)
+		if config == nil || config.Session == nil {
+			continue
+		}
+		if err := destroySession(c, config.Session); err != nil {
+			return fmt.Errorf("could not destroy the session %s in DestroyAll; %v", key, err)
+		}
+	}
+	return nil
+}
+
+func destroySession(c echo.Context, session IEchoSessionSCS) error {
+	s := session.GetSession()
+	if !s.Loaded(c) {
+		if err := session.LoadCheck(c); err != nil {
+			return err
+		}
+		defer s.Release(c)
+	}
+	if err := s.Destroy(c); err != nil {
+		return err
+	}
+	return session.SaveCheck(c)
+}
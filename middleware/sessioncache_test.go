@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/aberlorn/scs/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionCacheConcurrentRegisterRemove(t *testing.T) {
+	if SessionCache().Length() != 0 {
+		t.Fatalf("pre-test session cache should be 0 but it is %d", SessionCache().Length())
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("session%d", i)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			SessionCache().Register(key, &SessionsConfig{})
+		}()
+		go func() {
+			defer wg.Done()
+			SessionCache().Remove(key)
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 50; i++ {
+		SessionCache().Remove(fmt.Sprintf("session%d", i))
+	}
+
+	if SessionCache().Length() != 0 {
+		t.Fatalf("post-test session cache should be 0 but it is %d", SessionCache().Length())
+	}
+}
+
+func TestSessionCacheKeysAndClear(t *testing.T) {
+	if SessionCache().Length() != 0 {
+		t.Fatalf("pre-test session cache should be 0 but it is %d", SessionCache().Length())
+	}
+
+	SessionCache().Register("session2", &SessionsConfig{})
+	SessionCache().Register("session1", &SessionsConfig{})
+	SessionCache().Register("session3", &SessionsConfig{})
+
+	assert.Equal(t, []string{"session1", "session2", "session3"}, SessionCache().Keys())
+
+	SessionCache().Clear()
+
+	assert.Empty(t, SessionCache().Keys())
+	if SessionCache().Length() != 0 {
+		t.Fatalf("post-test session cache should be 0 but it is %d", SessionCache().Length())
+	}
+}
+
+func TestDestroyAll(t *testing.T) {
+	if SessionCache().Length() != 0 {
+		t.Fatalf("pre-test session cache should be 0 but it is %d", SessionCache().Length())
+	}
+	defer SessionCache().Clear()
+
+	e := echo.New()
+
+	var configs []*SessionsConfig
+	var mws []echo.MiddlewareFunc
+	var cookies []*http.Cookie
+	for _, name := range []string{"session1", "session2"} {
+		config := &SessionsConfig{
+			Session: &EchoSessionSCS{Session: scs.NewSession(), CookieName: name},
+			DoCache: true,
+		}
+		mw := SessionsWithConfig(config)
+		configs = append(configs, config)
+		mws = append(mws, mw)
+
+		// Create a session for each name.
+		req := httptest.NewRequest(echo.GET, "/", nil)
+		rec := httptest.NewRecorder()
+		h := mw(func(c echo.Context) error {
+			config.Session.GetSession().Put(c, "foo", "bar")
+			return config.Session.SaveCheck(c)
+		})
+		assert.NoError(t, h(e.NewContext(req, rec)))
+		cookie := rec.Result().Cookies()[len(rec.Result().Cookies())-1]
+		_, found, err := config.Session.GetSession().Store.Find(cookie.Value)
+		assert.NoError(t, err)
+		assert.True(t, found)
+		cookies = append(cookies, cookie)
+	}
+	// A registered config without a session is skipped.
+	SessionCache().Register("empty", &SessionsConfig{})
+
+	// Only session1 is loaded by middleware; session2 is loaded by DestroyAll.
+	req := httptest.NewRequest(echo.GET, "/logout", nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	assert.NoError(t, mws[0](DestroyAll)(e.NewContext(req, rec)))
+
+	res := rec.Result()
+	expired := map[string]bool{}
+	for _, cookie := range res.Cookies() {
+		expired[cookie.Name] = cookie.MaxAge < 0 && cookie.Value == ""
+	}
+	assert.Equal(t, map[string]bool{"session1": true, "session2": true}, expired)
+
+	for i, config := range configs {
+		_, found, err := config.Session.GetSession().Store.Find(cookies[i].Value)
+		assert.NoError(t, err)
+		assert.False(t, found)
+	}
+
+	// A request without any session cookies still gets expired cookies.
+	rec = httptest.NewRecorder()
+	assert.NoError(t, DestroyAll(e.NewContext(httptest.NewRequest(echo.GET, "/logout", nil), rec)))
+	assert.Len(t, rec.Result().Cookies(), 2)
+}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"reflect"
+
+	"github.com/aberlorn/scs/v2"
+	"github.com/labstack/echo/v4"
+)
+
+// FlashKey is the session key under which AddFlash stores flash messages.
+const FlashKey = "flashes"
+
+// AddFlash appends msg to the flash messages of the session, which are read
+// (and removed) by SessionView.Flashes. The session data status will be set
+// to Modified.
+func (s *EchoSessionSCS) AddFlash(c scs.SessionContext, msg string) {
+	s.WithValues(c, func(values map[string]interface{}) {
+		flashes, _ := values[FlashKey].([]string)
+		values[FlashKey] = append(append([]string(nil), flashes...), msg)
+	})
+}
+
+// SessionView is a read-only view of the session for the current request,
+// stored on the echo.Context by the middleware when SessionsConfig.ViewKey is
+// set, so that templates can read session values without going through the
+// SessionCache. It has no methods which change session values; slices and
+// maps are returned as copies, so changing them doesn't change the session.
+type SessionView struct {
+	session *scs.Session
+	c       echo.Context
+}
+
+// NewSessionView returns a SessionView of session for the request in c.
+func NewSessionView(c echo.Context, session *scs.Session) *SessionView {
+	return &SessionView{session: session, c: c}
+}
+
+// IsAuthenticated reports whether a user has been registered for the
+// session with scs.Session.RegisterUserSession.
+func (v *SessionView) IsAuthenticated() bool {
+	return v.session.UserID(v.c) != ""
+}
+
+// UserID returns the user ID registered for the session, or "".
+func (v *SessionView) UserID() string {
+	return v.session.UserID(v.c)
+}
+
+// Get returns the value for key, or nil if it is not set. Slice and map
+// values are copied.
+func (v *SessionView) Get(key string) interface{} {
+	return copyValue(v.session.Get(v.c, key))
+}
+
+// GetString returns the string value for key, or "" if it is not set or is
+// not a string.
+func (v *SessionView) GetString(key string) string {
+	return v.session.GetString(v.c, key)
+}
+
+// Exists reports whether key is set in the session.
+func (v *SessionView) Exists(key string) bool {
+	return v.session.Exists(v.c, key)
+}
+
+// Flashes returns the flash messages added with AddFlash and removes them
+// from the session, so each message is shown once. The removal is a change
+// to the session like any other, so the session must be saved after the
+// template has been rendered for it to persist; AutoSave does this when the
+// rendered response is written.
+func (v *SessionView) Flashes() []string {
+	val, ok := v.session.PopOK(v.c, FlashKey)
+	if !ok {
+		return nil
+	}
+	flashes, _ := val.([]string)
+	return flashes
+}
+
+// copyValue returns a shallow copy of v if it is a slice or map, and v
+// otherwise.
+func copyValue(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice:
+		if rv.IsNil() {
+			return v
+		}
+		cp := reflect.MakeSlice(rv.Type(), rv.Len(), rv.Len())
+		reflect.Copy(cp, rv)
+		return cp.Interface()
+	case reflect.Map:
+		if rv.IsNil() {
+			return v
+		}
+		cp := reflect.MakeMapWithSize(rv.Type(), rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			cp.SetMapIndex(iter.Key(), iter.Value())
+		}
+		return cp.Interface()
+	}
+	return v
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aberlorn/scs/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionViewTemplate(t *testing.T) {
+	session := &EchoSessionSCS{Session: scs.NewSession()}
+	mw := SessionsWithConfig(&SessionsConfig{Session: session, AutoSave: true, ViewKey: "session"})
+
+	tmpl := template.Must(template.New("page").Parse(
+		`{{with .session}}{{if .IsAuthenticated}}Hello {{.GetString "name"}}{{else}}Hello guest{{end}}{{range .Flashes}} [{{.}}]{{end}}{{end}}`))
+
+	e := echo.New()
+	e.GET("/login", func(c echo.Context) error {
+		session.RegisterUserSession(c, "42")
+		session.Put(c, "name", "alice")
+		session.Put(c, "roles", []string{"admin"})
+		session.AddFlash(c, "Logged in")
+		session.AddFlash(c, "Welcome")
+		return c.NoContent(http.StatusOK)
+	}, mw)
+	e.GET("/", func(c echo.Context) error {
+		view := c.Get("session").(*SessionView)
+
+		// Copies of slices can be changed without changing the session.
+		if roles, ok := view.Get("roles").([]string); ok {
+			roles[0] = "guest"
+			assert.Equal(t, []string{"admin"}, session.Get(c, "roles"))
+		}
+
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, map[string]interface{}{"session": view}); err != nil {
+			return err
+		}
+		return c.HTML(http.StatusOK, b.String())
+	}, mw)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(echo.GET, "/", nil))
+	assert.Equal(t, "Hello guest", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(echo.GET, "/login", nil))
+	cookies := rec.Result().Cookies()
+	assert.Len(t, cookies, 1)
+
+	render := func() string {
+		req := httptest.NewRequest(echo.GET, "/", nil)
+		req.AddCookie(cookies[0])
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+	assert.Equal(t, "Hello alice [Logged in] [Welcome]", render())
+
+	// Reading the flashes removed them, and AutoSave saved the change.
+	assert.Equal(t, "Hello alice", render())
+}
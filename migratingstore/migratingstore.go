@@ -0,0 +1,135 @@
+package migratingstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aberlorn/scs/v2"
+)
+
+// DefaultMigrationTTL is the default value of MigratingStore.MigrationTTL,
+// matching the default session lifetime.
+const DefaultMigrationTTL = 24 * time.Hour
+
+// MigratingStore represents the session store. It moves sessions from an old
+// (secondary) store to a new (primary) store as they are used, so that the
+// session store can be changed without logging everyone out.
+type MigratingStore struct {
+	// MigrationTTL sets the expiry used when a session found in the secondary
+	// store is copied to the primary store, if the secondary store doesn't
+	// implement scs.ExpiryStore to report when the session expires. The
+	// session's absolute deadline is still enforced when it is loaded, and
+	// the expiry is corrected the next time the session is committed. The
+	// default value is DefaultMigrationTTL.
+	MigrationTTL time.Duration
+
+	// StrictSecondary sets whether errors from the secondary store are
+	// returned. By default they are treated as a miss in Find and ignored in
+	// Delete, so that the secondary store can be retired while the
+	// MigratingStore is still in use.
+	StrictSecondary bool
+
+	primary   scs.Store
+	secondary scs.Store
+}
+
+var (
+	_ scs.Store    = (*MigratingStore)(nil)
+	_ scs.Pingable = (*MigratingStore)(nil)
+)
+
+// New returns a new MigratingStore instance which commits sessions to the
+// primary store, and reads sessions from the secondary store when they are not
+// in the primary store yet.
+//
+// Only Find, Commit, Delete and Ping are passed on to the stores (along with
+// FindWithExpiry on a secondary store implementing scs.ExpiryStore). The
+// optional interfaces scs.VersionedStore, scs.ExpiryStore,
+// scs.TouchableStore, scs.CountableStore and scs.GarbageCollectable are not
+// implemented by MigratingStore, so the session works as if the primary store
+// didn't implement them. New panics if either store implements
+// scs.ContextStore, as such a store needs the request context for every
+// operation.
+func New(primary, secondary scs.Store) *MigratingStore {
+	for _, store := range []scs.Store{primary, secondary} {
+		if _, ok := store.(scs.ContextStore); ok {
+			panic(fmt.Errorf("migratingstore: cannot wrap %T, which implements scs.ContextStore", store))
+		}
+	}
+	return &MigratingStore{
+		MigrationTTL: DefaultMigrationTTL,
+		primary:      primary,
+		secondary:    secondary,
+	}
+}
+
+// Find returns the data for a given session token from the primary store, or
+// from the secondary store if it isn't in the primary store. Sessions found
+// in the secondary store are copied to the primary store. If the session
+// token is not found or is expired, the returned exists flag will be set to
+// false.
+func (m *MigratingStore) Find(token string) ([]byte, bool, error) {
+	b, found, err := m.primary.Find(token)
+	if err != nil || found {
+		return b, found, err
+	}
+
+	expiry := time.Now().Add(m.MigrationTTL)
+	if es, ok := m.secondary.(scs.ExpiryStore); ok {
+		b, expiry, found, err = es.FindWithExpiry(token)
+	} else {
+		b, found, err = m.secondary.Find(token)
+	}
+	if err != nil {
+		if m.StrictSecondary {
+			return nil, false, err
+		}
+		return nil, false, nil
+	}
+	if !found {
+		return nil, false, nil
+	}
+
+	err = m.primary.Commit(token, b, expiry)
+	if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// Commit adds a session token and data to the primary store with the given
+// expiry time. If the session token already exists, then the data and expiry
+// time are updated.
+func (m *MigratingStore) Commit(token string, b []byte, expiry time.Time) error {
+	return m.primary.Commit(token, b, expiry)
+}
+
+// Delete removes a session token and corresponding data from both the primary
+// and secondary stores.
+func (m *MigratingStore) Delete(token string) error {
+	err := m.primary.Delete(token)
+	if err != nil {
+		return err
+	}
+
+	err = m.secondary.Delete(token)
+	if err != nil && m.StrictSecondary {
+		return err
+	}
+	return nil
+}
+
+// Ping checks that the primary store is reachable, and the secondary store if
+// StrictSecondary is set, for the stores which implement scs.Pingable.
+func (m *MigratingStore) Ping(ctx context.Context) error {
+	if ps, ok := m.primary.(scs.Pingable); ok {
+		if err := ps.Ping(ctx); err != nil {
+			return err
+		}
+	}
+	if ps, ok := m.secondary.(scs.Pingable); ok && m.StrictSecondary {
+		return ps.Ping(ctx)
+	}
+	return nil
+}
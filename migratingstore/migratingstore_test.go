@@ -0,0 +1,234 @@
+package migratingstore
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aberlorn/scs/v2"
+	"github.com/aberlorn/scs/v2/cookiestore"
+	"github.com/aberlorn/scs/v2/memstore"
+)
+
+// brokenStore is a store whose operations all fail.
+type brokenStore struct{}
+
+var errBroken = errors.New("store unavailable")
+
+func (brokenStore) Find(token string) ([]byte, bool, error)               { return nil, false, errBroken }
+func (brokenStore) Commit(token string, b []byte, expiry time.Time) error { return errBroken }
+func (brokenStore) Delete(token string) error                             { return errBroken }
+
+func TestFindPrimary(t *testing.T) {
+	primary := memstore.NewWithCleanupInterval(0)
+	secondary := memstore.NewWithCleanupInterval(0)
+	m := New(primary, secondary)
+
+	err := primary.Commit("session_token", []byte("primary_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = secondary.Commit("session_token", []byte("secondary_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, found, err := m.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+	if bytes.Equal(b, []byte("primary_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("primary_data"))
+	}
+}
+
+func TestFindMigrates(t *testing.T) {
+	primary := memstore.NewWithCleanupInterval(0)
+	secondary := memstore.NewWithCleanupInterval(0)
+	m := New(primary, secondary)
+
+	expiry := time.Now().Add(time.Minute)
+	err := secondary.Commit("session_token", []byte("encoded_data"), expiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, found, err := m.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+	if bytes.Equal(b, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("encoded_data"))
+	}
+
+	// The session has been copied to the primary store, keeping the expiry
+	// reported by the secondary store.
+	b, migratedExpiry, found, err := primary.FindWithExpiry("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+	if bytes.Equal(b, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("encoded_data"))
+	}
+	if !migratedExpiry.Equal(expiry) {
+		t.Fatalf("got %v: expected %v", migratedExpiry, expiry)
+	}
+}
+
+func TestFindMigratesWithTTL(t *testing.T) {
+	primary := memstore.NewWithCleanupInterval(0)
+	secondary := memstore.NewWithCleanupInterval(0)
+	// Hide FindWithExpiry, so the expiry is unknown.
+	m := New(primary, struct{ scs.Store }{secondary})
+	m.MigrationTTL = time.Hour
+
+	err := secondary.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = m.Find("session_token"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, expiry, found, err := primary.FindWithExpiry("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+	if d := time.Until(expiry); d < 59*time.Minute || d > time.Hour {
+		t.Fatalf("got %v: expected about %v", d, time.Hour)
+	}
+}
+
+func TestFindMissing(t *testing.T) {
+	m := New(memstore.NewWithCleanupInterval(0), memstore.NewWithCleanupInterval(0))
+
+	_, found, err := m.Find("missing_session_token")
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestCommit(t *testing.T) {
+	primary := memstore.NewWithCleanupInterval(0)
+	secondary := memstore.NewWithCleanupInterval(0)
+	m := New(primary, secondary)
+
+	err := m.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, found, err := primary.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+	_, found, err = secondary.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	primary := memstore.NewWithCleanupInterval(0)
+	secondary := memstore.NewWithCleanupInterval(0)
+	m := New(primary, secondary)
+
+	err := secondary.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = m.Find("session_token"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Delete("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, found, err := m.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestSecondaryErrors(t *testing.T) {
+	primary := memstore.NewWithCleanupInterval(0)
+	m := New(primary, brokenStore{})
+
+	// By default a failing secondary store is treated as a miss.
+	_, found, err := m.Find("session_token")
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+	err = m.Delete("session_token")
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+
+	m.StrictSecondary = true
+	_, _, err = m.Find("session_token")
+	if err != errBroken {
+		t.Fatalf("got %v: expected %v", err, errBroken)
+	}
+	err = m.Delete("session_token")
+	if err != errBroken {
+		t.Fatalf("got %v: expected %v", err, errBroken)
+	}
+
+	// Sessions in the primary store don't need the secondary store.
+	err = m.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, found, err = m.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+}
+
+func TestContextStoreRejected(t *testing.T) {
+	for _, stores := range [][2]scs.Store{
+		{cookiestore.New([]byte("secret")), memstore.NewWithCleanupInterval(0)},
+		{memstore.NewWithCleanupInterval(0), cookiestore.New([]byte("secret"))},
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("got no panic: expected New(%T, %T) to panic", stores[0], stores[1])
+				}
+			}()
+			New(stores[0], stores[1])
+		}()
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/aberlorn/scs/mongodbstore
+
+go 1.13
+
+require (
+	github.com/aberlorn/scs/v2 v2.0.0
+	go.mongodb.org/mongo-driver v1.12.1
+)
+
+replace github.com/aberlorn/scs/v2 => ../
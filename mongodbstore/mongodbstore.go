@@ -0,0 +1,78 @@
+package mongodbstore
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoDBStore represents the session store.
+type MongoDBStore struct {
+	collection *mongo.Collection
+}
+
+// item is the document stored for each session.
+type item struct {
+	Token  string    `bson:"_id"`
+	Data   []byte    `bson:"data"`
+	Expiry time.Time `bson:"expiry"`
+}
+
+// New returns a new MongoDBStore instance which stores sessions in the named
+// collection of db. Call EnsureTTLIndex once so that MongoDB deletes expired
+// sessions automatically.
+func New(db *mongo.Database, collection string) *MongoDBStore {
+	return &MongoDBStore{
+		collection: db.Collection(collection),
+	}
+}
+
+// EnsureTTLIndex creates a TTL index on the expiry field of the collection,
+// if it does not already exist, so that expired sessions are deleted by
+// MongoDB.
+func (m *MongoDBStore) EnsureTTLIndex(ctx context.Context) error {
+	_, err := m.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiry", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// Find returns the data for a given session token from the MongoDBStore
+// instance. If the session token is not found or is expired, the returned
+// exists flag will be set to false. MongoDB's TTL monitor only runs every 60
+// seconds, so the expiry is checked here as well.
+func (m *MongoDBStore) Find(token string) ([]byte, bool, error) {
+	filter := bson.M{"_id": token, "expiry": bson.M{"$gt": time.Now()}}
+
+	var it item
+	err := m.collection.FindOne(context.Background(), filter).Decode(&it)
+	if err == mongo.ErrNoDocuments {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return it.Data, true, nil
+}
+
+// Commit adds a session token and data to the MongoDBStore instance with the
+// given expiry time. If the session token already exists then the data and
+// expiry time are updated.
+func (m *MongoDBStore) Commit(token string, b []byte, expiry time.Time) error {
+	_, err := m.collection.ReplaceOne(context.Background(),
+		bson.M{"_id": token},
+		item{Token: token, Data: b, Expiry: expiry},
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// Delete removes a session token and corresponding data from the MongoDBStore
+// instance.
+func (m *MongoDBStore) Delete(token string) error {
+	_, err := m.collection.DeleteOne(context.Background(), bson.M{"_id": token})
+	return err
+}
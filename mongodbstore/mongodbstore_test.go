@@ -0,0 +1,161 @@
+//go:build mongodb
+// +build mongodb
+
+package mongodbstore
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const testCollection = "scs_sessions_test"
+
+// The tests need a MongoDB server and are only built with the mongodb build
+// tag:
+//
+//	SCS_MONGODB_TEST_URI=mongodb://localhost:27017 go test -tags mongodb
+func newTestStore(t *testing.T) *MongoDBStore {
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(os.Getenv("SCS_MONGODB_TEST_URI")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(ctx) })
+
+	db := client.Database("scs_test")
+	if err = db.Collection(testCollection).Drop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New(db, testCollection)
+	if err = m.EnsureTTLIndex(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestFind(t *testing.T) {
+	m := newTestStore(t)
+
+	err := m.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, found, err := m.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+	if bytes.Equal(b, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("encoded_data"))
+	}
+}
+
+func TestFindMissing(t *testing.T) {
+	m := newTestStore(t)
+
+	_, found, err := m.Find("missing_session_token")
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestSaveNew(t *testing.T) {
+	m := newTestStore(t)
+	expiry := time.Now().Add(time.Minute)
+
+	err := m.Commit("session_token", []byte("encoded_data"), expiry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var it item
+	err = m.collection.FindOne(context.Background(), bson.M{"_id": "session_token"}).Decode(&it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(it.Data, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", it.Data, []byte("encoded_data"))
+	}
+	if it.Expiry.Unix() != expiry.Unix() {
+		t.Fatalf("got %v: expected %v", it.Expiry, expiry)
+	}
+}
+
+func TestSaveUpdated(t *testing.T) {
+	m := newTestStore(t)
+
+	err := m.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Commit("session_token", []byte("new_encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _, err := m.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(b, []byte("new_encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("new_encoded_data"))
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	m := newTestStore(t)
+
+	err := m.Commit("session_token", []byte("encoded_data"), time.Now().Add(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, found, _ := m.Find("session_token")
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+
+	// The TTL monitor will not have run yet, so Find must ignore the
+	// expired document itself.
+	time.Sleep(101 * time.Millisecond)
+	_, found, _ = m.Find("session_token")
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	m := newTestStore(t)
+
+	err := m.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Delete("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, found, err := m.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
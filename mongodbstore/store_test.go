@@ -0,0 +1,5 @@
+package mongodbstore
+
+import "github.com/aberlorn/scs/v2"
+
+var _ scs.Store = (*MongoDBStore)(nil)
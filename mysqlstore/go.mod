@@ -3,6 +3,9 @@ module github.com/alexedwards/scs/mysqlstore
 go 1.12
 
 require (
+	github.com/aberlorn/scs/v2 v2.0.0
 	github.com/go-sql-driver/mysql v1.4.1
 	google.golang.org/appengine v1.5.0 // indirect
 )
+
+replace github.com/aberlorn/scs/v2 => ../
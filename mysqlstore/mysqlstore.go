@@ -1,6 +1,7 @@
 package mysqlstore
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"strconv"
@@ -32,7 +33,7 @@ func NewWithCleanupInterval(db *sql.DB, cleanupInterval time.Duration) *MySQLSto
 	}
 
 	if cleanupInterval > 0 {
-		go m.startCleanup(cleanupInterval)
+		m.StartCleanup(cleanupInterval)
 	}
 
 	return m
@@ -79,8 +80,39 @@ func (m *MySQLStore) Delete(token string) error {
 	return err
 }
 
-func (m *MySQLStore) startCleanup(interval time.Duration) {
+// PingableStore is a MySQLStore which implements scs.Pingable, so that
+// Session.Ping can check that the database is reachable. MySQLStore doesn't
+// implement it itself, as its Ping method is the Ping method of the embedded
+// *sql.DB.
+type PingableStore struct {
+	*MySQLStore
+}
+
+// Pinger returns m wrapped in a PingableStore. Use it as the session store
+// when Session.Ping is used for health checks:
+//
+//	session.Store = mysqlstore.Pinger(mysqlstore.New(db))
+func Pinger(m *MySQLStore) *PingableStore {
+	return &PingableStore{MySQLStore: m}
+}
+
+// Ping checks that the database is reachable.
+func (p *PingableStore) Ping(ctx context.Context) error {
+	return p.DB.PingContext(ctx)
+}
+
+// StartCleanup starts a background goroutine which deletes expired session
+// data from the sessions table every interval. This is useful when the store
+// was created with NewWithCleanupInterval(db, 0) and the cleanup should begin
+// later. A cleanup goroutine which is already running is stopped first. Use
+// StopCleanup to terminate the goroutine.
+func (m *MySQLStore) StartCleanup(interval time.Duration) {
+	m.StopCleanup()
 	m.stopCleanup = make(chan bool)
+	go m.startCleanup(interval, m.stopCleanup)
+}
+
+func (m *MySQLStore) startCleanup(interval time.Duration, stop chan bool) {
 	ticker := time.NewTicker(interval)
 	for {
 		select {
@@ -89,7 +121,7 @@ func (m *MySQLStore) startCleanup(interval time.Duration) {
 			if err != nil {
 				log.Println(err)
 			}
-		case <-m.stopCleanup:
+		case <-stop:
 			ticker.Stop()
 			return
 		}
@@ -109,6 +141,7 @@ func (m *MySQLStore) startCleanup(interval time.Duration) {
 func (m *MySQLStore) StopCleanup() {
 	if m.stopCleanup != nil {
 		m.stopCleanup <- true
+		m.stopCleanup = nil
 	}
 }
 
@@ -8,11 +8,31 @@ import (
 	"testing"
 	"time"
 
+	"github.com/aberlorn/scs/v2"
 	_ "github.com/go-sql-driver/mysql"
 )
 
-func TestFind(t *testing.T) {
+var (
+	_ scs.Store    = (*MySQLStore)(nil)
+	_ scs.Store    = (*PingableStore)(nil)
+	_ scs.Pingable = (*PingableStore)(nil)
+
+	// The Ping method of the embedded *sql.DB is still promoted.
+	_ interface{ Ping() error } = (*MySQLStore)(nil)
+)
+
+// testDSN returns the DSN of the test database, skipping the test when the
+// SCS_MYSQL_TEST_DSN environment variable is not set.
+func testDSN(t *testing.T) string {
 	dsn := os.Getenv("SCS_MYSQL_TEST_DSN")
+	if dsn == "" {
+		t.Skip("SCS_MYSQL_TEST_DSN is not set")
+	}
+	return dsn
+}
+
+func TestFind(t *testing.T) {
+	dsn := testDSN(t)
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		t.Fatal(err)
@@ -45,7 +65,7 @@ func TestFind(t *testing.T) {
 }
 
 func TestFindMissing(t *testing.T) {
-	dsn := os.Getenv("SCS_MYSQL_TEST_DSN")
+	dsn := testDSN(t)
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		t.Fatal(err)
@@ -71,7 +91,7 @@ func TestFindMissing(t *testing.T) {
 }
 
 func TestSaveNew(t *testing.T) {
-	dsn := os.Getenv("SCS_MYSQL_TEST_DSN")
+	dsn := testDSN(t)
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		t.Fatal(err)
@@ -104,7 +124,7 @@ func TestSaveNew(t *testing.T) {
 }
 
 func TestSaveUpdated(t *testing.T) {
-	dsn := os.Getenv("SCS_MYSQL_TEST_DSN")
+	dsn := testDSN(t)
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		t.Fatal(err)
@@ -141,7 +161,7 @@ func TestSaveUpdated(t *testing.T) {
 }
 
 func TestExpiry(t *testing.T) {
-	dsn := os.Getenv("SCS_MYSQL_TEST_DSN")
+	dsn := testDSN(t)
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		t.Fatal(err)
@@ -175,7 +195,7 @@ func TestExpiry(t *testing.T) {
 }
 
 func TestDelete(t *testing.T) {
-	dsn := os.Getenv("SCS_MYSQL_TEST_DSN")
+	dsn := testDSN(t)
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		t.Fatal(err)
@@ -212,7 +232,7 @@ func TestDelete(t *testing.T) {
 }
 
 func TestCleanup(t *testing.T) {
-	dsn := os.Getenv("SCS_MYSQL_TEST_DSN")
+	dsn := testDSN(t)
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		t.Fatal(err)
@@ -256,7 +276,7 @@ func TestCleanup(t *testing.T) {
 }
 
 func TestStopNilCleanup(t *testing.T) {
-	dsn := os.Getenv("SCS_MYSQL_TEST_DSN")
+	dsn := testDSN(t)
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		t.Fatal(err)
@@ -271,3 +291,39 @@ func TestStopNilCleanup(t *testing.T) {
 	// A send to a nil channel will block forever
 	m.StopCleanup()
 }
+
+func TestStartCleanup(t *testing.T) {
+	dsn := testDSN(t)
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err = db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("TRUNCATE TABLE sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewWithCleanupInterval(db, 0)
+	m.StartCleanup(200 * time.Millisecond)
+	defer m.StopCleanup()
+
+	err = m.Commit("session_token", []byte("encoded_data"), time.Now().Add(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	row := db.QueryRow("SELECT COUNT(*) FROM sessions WHERE token = 'session_token'")
+	var count int
+	err = row.Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("got %d: expected %d", count, 0)
+	}
+}
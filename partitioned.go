@@ -0,0 +1,28 @@
+//go:build go1.23
+// +build go1.23
+
+package scs
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// checkPartitioned returns an error if Partitioned is set on a cookie which
+// browsers would not store as partitioned.
+func (sc SessionCookie) checkPartitioned() error {
+	if !sc.Partitioned {
+		return nil
+	}
+	if !sc.Secure {
+		return fmt.Errorf("scs: cookie %q with Partitioned must be Secure", sc.Name)
+	}
+	if sc.SameSite != http.SameSiteNoneMode {
+		return fmt.Errorf("scs: cookie %q with Partitioned must have SameSite=None", sc.Name)
+	}
+	return nil
+}
+
+func setPartitioned(cookie *http.Cookie, partitioned bool) {
+	cookie.Partitioned = partitioned
+}
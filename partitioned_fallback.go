@@ -0,0 +1,20 @@
+//go:build !go1.23
+// +build !go1.23
+
+package scs
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// checkPartitioned returns an error if Partitioned is set, as http.Cookie has
+// no Partitioned attribute before Go 1.23.
+func (sc SessionCookie) checkPartitioned() error {
+	if sc.Partitioned {
+		return fmt.Errorf("scs: cookie %q with Partitioned requires Go 1.23 or newer", sc.Name)
+	}
+	return nil
+}
+
+func setPartitioned(cookie *http.Cookie, partitioned bool) {}
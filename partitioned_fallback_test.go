@@ -0,0 +1,23 @@
+//go:build !go1.23
+// +build !go1.23
+
+package scs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPartitionedUnsupported(t *testing.T) {
+	session := NewSession()
+	session.Cookie.Partitioned = true
+	session.Cookie.Secure = true
+
+	c := newTestContext()
+	if err := session.LoadCheck(c); err == nil {
+		t.Errorf("got %v: expected an error from LoadCheck", err)
+	}
+	if err := session.WriteSessionCookie(c, "token", time.Now().Add(time.Hour)); err == nil {
+		t.Errorf("got %v: expected an error from WriteSessionCookie", err)
+	}
+}
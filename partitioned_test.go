@@ -0,0 +1,64 @@
+//go:build go1.23
+// +build go1.23
+
+package scs
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPartitioned(t *testing.T) {
+	session := NewSession()
+	session.Cookie.Partitioned = true
+	session.Cookie.Secure = true
+	session.Cookie.SameSite = http.SameSiteNoneMode
+
+	c := newTestContext()
+	if err := session.WriteSessionCookie(c, "token", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	cookie := c.Response().Header().Get("Set-Cookie")
+	if strings.Contains(cookie, "; Partitioned") == false {
+		t.Fatalf("got %q: expected a Partitioned attribute", cookie)
+	}
+
+	session.Cookie.Partitioned = false
+	c = newTestContext()
+	if err := session.WriteSessionCookie(c, "token", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	cookie = c.Response().Header().Get("Set-Cookie")
+	if strings.Contains(cookie, "Partitioned") {
+		t.Fatalf("got %q: expected no Partitioned attribute", cookie)
+	}
+}
+
+func TestPartitionedValidation(t *testing.T) {
+	tests := map[string]func(*SessionCookie){
+		"insecure":      func(sc *SessionCookie) { sc.Secure = false },
+		"samesite lax":  func(sc *SessionCookie) { sc.SameSite = http.SameSiteLaxMode },
+		"samesite omit": func(sc *SessionCookie) { sc.SameSite = 0 },
+	}
+
+	for name, modify := range tests {
+		session := NewSession()
+		session.Cookie.Partitioned = true
+		session.Cookie.Secure = true
+		session.Cookie.SameSite = http.SameSiteNoneMode
+		modify(&session.Cookie)
+
+		c := newTestContext()
+		if err := session.LoadCheck(c); err == nil {
+			t.Errorf("%s: got %v: expected an error from LoadCheck", name, err)
+		}
+		if err := session.WriteSessionCookie(c, "token", time.Now().Add(time.Hour)); err == nil {
+			t.Errorf("%s: got %v: expected an error from WriteSessionCookie", name, err)
+		}
+		if cookie := c.Response().Header().Get("Set-Cookie"); cookie != "" {
+			t.Errorf("%s: got %q: expected no cookie", name, cookie)
+		}
+	}
+}
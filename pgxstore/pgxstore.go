@@ -0,0 +1,167 @@
+package pgxstore
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aberlorn/scs/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PgxStore represents the session store.
+type PgxStore struct {
+	pool        *pgxpool.Pool
+	stopCleanup chan bool
+}
+
+var (
+	_ scs.Store        = (*PgxStore)(nil)
+	_ scs.Pingable     = (*PgxStore)(nil)
+	_ scs.ContextStore = (*PgxStore)(nil)
+)
+
+// New returns a new PgxStore instance, with a background cleanup goroutine
+// that runs every 5 minutes to remove expired session data.
+func New(pool *pgxpool.Pool) *PgxStore {
+	return NewWithCleanupInterval(pool, 5*time.Minute)
+}
+
+// NewWithCleanupInterval returns a new PgxStore instance. The cleanupInterval
+// parameter controls how frequently expired session data is removed by the
+// background cleanup goroutine. Setting it to 0 prevents the cleanup goroutine
+// from running (i.e. expired sessions will not be removed).
+func NewWithCleanupInterval(pool *pgxpool.Pool, cleanupInterval time.Duration) *PgxStore {
+	p := &PgxStore{pool: pool}
+	if cleanupInterval > 0 {
+		go p.startCleanup(cleanupInterval)
+	}
+	return p
+}
+
+// Find returns the data for a given session token from the PgxStore instance.
+// If the session token is not found or is expired, the returned exists flag will
+// be set to false.
+func (p *PgxStore) Find(token string) ([]byte, bool, error) {
+	return p.FindCtx(context.Background(), token)
+}
+
+// Commit adds a session token and data to the PgxStore instance with the
+// given expiry time. If the session token already exists, then the data and expiry
+// time are updated.
+func (p *PgxStore) Commit(token string, b []byte, expiry time.Time) error {
+	return p.CommitCtx(context.Background(), token, b, expiry)
+}
+
+// Delete removes a session token and corresponding data from the PgxStore
+// instance.
+func (p *PgxStore) Delete(token string) error {
+	return p.DeleteCtx(context.Background(), token)
+}
+
+// FindCtx is like Find but uses ctx for the query.
+func (p *PgxStore) FindCtx(ctx context.Context, token string) (b []byte, exists bool, err error) {
+	row := p.pool.QueryRow(ctx, "SELECT data FROM sessions WHERE token = $1 AND current_timestamp < expiry", token)
+	err = row.Scan(&b)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// CommitCtx is like Commit but uses ctx for the query.
+func (p *PgxStore) CommitCtx(ctx context.Context, token string, b []byte, expiry time.Time) error {
+	_, err := p.pool.Exec(ctx, "INSERT INTO sessions (token, data, expiry) VALUES ($1, $2, $3) ON CONFLICT (token) DO UPDATE SET data = EXCLUDED.data, expiry = EXCLUDED.expiry", token, b, expiry)
+	return err
+}
+
+// DeleteCtx is like Delete but uses ctx for the query.
+func (p *PgxStore) DeleteCtx(ctx context.Context, token string) error {
+	_, err := p.pool.Exec(ctx, "DELETE FROM sessions WHERE token = $1", token)
+	return err
+}
+
+// DeleteBatch removes several session tokens and their data from the
+// PgxStore instance in a single round trip to the database.
+func (p *PgxStore) DeleteBatch(ctx context.Context, tokens ...string) error {
+	batch := &pgx.Batch{}
+	for _, token := range tokens {
+		batch.Queue("DELETE FROM sessions WHERE token = $1", token)
+	}
+	return p.pool.SendBatch(ctx, batch).Close()
+}
+
+// Ping checks that the database is reachable.
+func (p *PgxStore) Ping(ctx context.Context) error {
+	return p.pool.Ping(ctx)
+}
+
+// FindContext implements scs.ContextStore, using the context of the current
+// request (if available) for the query.
+func (p *PgxStore) FindContext(c scs.SessionContext, token string) ([]byte, bool, error) {
+	return p.FindCtx(requestContext(c), token)
+}
+
+// CommitContext implements scs.ContextStore, using the context of the current
+// request (if available) for the query.
+func (p *PgxStore) CommitContext(c scs.SessionContext, token string, b []byte, expiry time.Time) error {
+	return p.CommitCtx(requestContext(c), token, b, expiry)
+}
+
+// DeleteContext implements scs.ContextStore, using the context of the current
+// request (if available) for the query.
+func (p *PgxStore) DeleteContext(c scs.SessionContext, token string) error {
+	return p.DeleteCtx(requestContext(c), token)
+}
+
+// requestContext returns the context of the request in c, such as an
+// echo.Context, or context.Background if there is none.
+func requestContext(c scs.SessionContext) context.Context {
+	if rc, ok := c.(interface{ Request() *http.Request }); ok && rc.Request() != nil {
+		return rc.Request().Context()
+	}
+	return context.Background()
+}
+
+func (p *PgxStore) startCleanup(interval time.Duration) {
+	p.stopCleanup = make(chan bool)
+	ticker := time.NewTicker(interval)
+	for {
+		select {
+		case <-ticker.C:
+			err := p.deleteExpired()
+			if err != nil {
+				log.Println(err)
+			}
+		case <-p.stopCleanup:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// StopCleanup terminates the background cleanup goroutine for the PgxStore
+// instance. It's rare to terminate this; generally PgxStore instances and
+// their cleanup goroutines are intended to be long-lived and run for the lifetime
+// of your application.
+//
+// There may be occasions though when your use of the PgxStore is transient.
+// An example is creating a new PgxStore instance in a test function. In this
+// scenario, the cleanup goroutine (which will run forever) will prevent the
+// PgxStore object from being garbage collected even after the test function
+// has finished. You can prevent this by manually calling StopCleanup.
+func (p *PgxStore) StopCleanup() {
+	if p.stopCleanup != nil {
+		p.stopCleanup <- true
+	}
+}
+
+func (p *PgxStore) deleteExpired() error {
+	_, err := p.pool.Exec(context.Background(), "DELETE FROM sessions WHERE expiry < current_timestamp")
+	return err
+}
@@ -0,0 +1,216 @@
+//go:build postgres
+// +build postgres
+
+package pgxstore
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// The tests need a PostgreSQL database with a sessions table (see the README)
+// and are only built with the postgres build tag:
+//
+//	SCS_POSTGRES_TEST_DSN=postgres://... go test -tags postgres
+func newTestPool(t *testing.T) *pgxpool.Pool {
+	pool, err := pgxpool.New(context.Background(), os.Getenv("SCS_POSTGRES_TEST_DSN"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	_, err = pool.Exec(context.Background(), "TRUNCATE TABLE sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+func TestFind(t *testing.T) {
+	pool := newTestPool(t)
+	_, err := pool.Exec(context.Background(), "INSERT INTO sessions VALUES('session_token', 'encoded_data', current_timestamp + interval '1 minute')")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewWithCleanupInterval(pool, 0)
+
+	b, found, err := p.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+	if bytes.Equal(b, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("encoded_data"))
+	}
+}
+
+func TestFindMissing(t *testing.T) {
+	p := NewWithCleanupInterval(newTestPool(t), 0)
+
+	_, found, err := p.Find("missing_session_token")
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestFindExpired(t *testing.T) {
+	pool := newTestPool(t)
+	_, err := pool.Exec(context.Background(), "INSERT INTO sessions VALUES('session_token', 'encoded_data', current_timestamp - interval '1 minute')")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewWithCleanupInterval(pool, 0)
+
+	_, found, err := p.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestSaveNew(t *testing.T) {
+	pool := newTestPool(t)
+	p := NewWithCleanupInterval(pool, 0)
+
+	err := p.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data []byte
+	err = pool.QueryRow(context.Background(), "SELECT data FROM sessions WHERE token = 'session_token'").Scan(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(data, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", data, []byte("encoded_data"))
+	}
+}
+
+func TestSaveUpdated(t *testing.T) {
+	pool := newTestPool(t)
+	_, err := pool.Exec(context.Background(), "INSERT INTO sessions VALUES('session_token', 'encoded_data', current_timestamp + interval '1 minute')")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewWithCleanupInterval(pool, 0)
+
+	err = p.CommitCtx(context.Background(), "session_token", []byte("new_encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data []byte
+	err = pool.QueryRow(context.Background(), "SELECT data FROM sessions WHERE token = 'session_token'").Scan(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(data, []byte("new_encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", data, []byte("new_encoded_data"))
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	p := NewWithCleanupInterval(newTestPool(t), 0)
+
+	err := p.Commit("session_token", []byte("encoded_data"), time.Now().Add(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, found, _ := p.Find("session_token")
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+
+	time.Sleep(101 * time.Millisecond)
+	_, found, _ = p.Find("session_token")
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	pool := newTestPool(t)
+	_, err := pool.Exec(context.Background(), "INSERT INTO sessions VALUES('session_token', 'encoded_data', current_timestamp + interval '1 minute')")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewWithCleanupInterval(pool, 0)
+
+	err = p.Delete("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	err = pool.QueryRow(context.Background(), "SELECT COUNT(*) FROM sessions WHERE token = 'session_token'").Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("got %d: expected %d", n, 0)
+	}
+}
+
+func TestDeleteBatch(t *testing.T) {
+	pool := newTestPool(t)
+	_, err := pool.Exec(context.Background(), "INSERT INTO sessions VALUES('session_token_1', 'encoded_data', current_timestamp + interval '1 minute'), ('session_token_2', 'encoded_data', current_timestamp + interval '1 minute')")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewWithCleanupInterval(pool, 0)
+
+	err = p.DeleteBatch(context.Background(), "session_token_1", "session_token_2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var n int
+	err = pool.QueryRow(context.Background(), "SELECT COUNT(*) FROM sessions").Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("got %d: expected %d", n, 0)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	pool := newTestPool(t)
+
+	p := NewWithCleanupInterval(pool, 200*time.Millisecond)
+	defer p.StopCleanup()
+
+	err := p.Commit("session_token", []byte("encoded_data"), time.Now().Add(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	var n int
+	err = pool.QueryRow(context.Background(), "SELECT COUNT(*) FROM sessions WHERE token = 'session_token'").Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("got %d: expected %d", n, 0)
+	}
+}
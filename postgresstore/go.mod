@@ -2,4 +2,9 @@ module github.com/alexedwards/scs/postgresstore
 
 go 1.12
 
-require github.com/lib/pq v1.1.0
+require (
+	github.com/aberlorn/scs/v2 v2.0.0
+	github.com/lib/pq v1.1.0
+)
+
+replace github.com/aberlorn/scs/v2 => ../
@@ -1,6 +1,7 @@
 package postgresstore
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"time"
@@ -62,6 +63,11 @@ func (p *PostgresStore) Delete(token string) error {
 	return err
 }
 
+// Ping checks that the database is reachable.
+func (p *PostgresStore) Ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
 func (p *PostgresStore) startCleanup(interval time.Duration) {
 	p.stopCleanup = make(chan bool)
 	ticker := time.NewTicker(interval)
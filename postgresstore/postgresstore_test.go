@@ -8,9 +8,16 @@ import (
 	"testing"
 	"time"
 
+	"github.com/aberlorn/scs/v2"
 	_ "github.com/lib/pq"
 )
 
+var (
+	_ scs.Store          = (*PostgresStore)(nil)
+	_ scs.Pingable       = (*PostgresStore)(nil)
+	_ scs.VersionedStore = (*VersionedPostgresStore)(nil)
+)
+
 func TestFind(t *testing.T) {
 	dsn := os.Getenv("SCS_POSTGRES_TEST_DSN")
 	db, err := sql.Open("postgres", dsn)
@@ -271,3 +278,84 @@ func TestStopNilCleanup(t *testing.T) {
 	// A send to a nil channel will block forever
 	p.StopCleanup()
 }
+
+func TestCommitIfUnchanged(t *testing.T) {
+	dsn := os.Getenv("SCS_POSTGRES_TEST_DSN")
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err = db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("TRUNCATE TABLE sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewVersionedWithCleanupInterval(db, 0)
+
+	version, ok, err := p.CommitIfUnchanged("session_token", []byte("encoded_data"), time.Now().Add(time.Minute), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok != true || version != 1 {
+		t.Fatalf("got %d, %v: expected %d, %v", version, ok, 1, true)
+	}
+
+	// Two requests load the same version of the session.
+	_, loaded, found, err := p.FindVersion("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true || loaded != 1 {
+		t.Fatalf("got %d, %v: expected %d, %v", loaded, found, 1, true)
+	}
+
+	version, ok, err = p.CommitIfUnchanged("session_token", []byte("first_data"), time.Now().Add(time.Minute), loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok != true || version != 2 {
+		t.Fatalf("got %d, %v: expected %d, %v", version, ok, 2, true)
+	}
+
+	// The second commit conflicts with the first and writes nothing.
+	_, ok, err = p.CommitIfUnchanged("session_token", []byte("second_data"), time.Now().Add(time.Minute), loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok != false {
+		t.Fatalf("got %v: expected %v", ok, false)
+	}
+	b, version, _, err := p.FindVersion("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(b, []byte("first_data")) == false || version != 2 {
+		t.Fatalf("got %s, %d: expected %s, %d", b, version, "first_data", 2)
+	}
+
+	// A new session can't overwrite an existing one.
+	_, ok, err = p.CommitIfUnchanged("session_token", []byte("new_data"), time.Now().Add(time.Minute), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok != false {
+		t.Fatalf("got %v: expected %v", ok, false)
+	}
+
+	// An unconditional commit still increments the version.
+	err = p.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, version, _, err = p.FindVersion("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 3 {
+		t.Fatalf("got %d: expected %d", version, 3)
+	}
+}
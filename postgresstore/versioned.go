@@ -0,0 +1,75 @@
+package postgresstore
+
+import (
+	"database/sql"
+	"time"
+)
+
+// VersionedPostgresStore is a PostgresStore which keeps a version number with
+// each session, so that a commit fails instead of overwriting changes made by
+// another request since the session was loaded. It implements the
+// scs.VersionedStore interface, and requires a version column in the
+// sessions table (see the README).
+type VersionedPostgresStore struct {
+	*PostgresStore
+}
+
+// NewVersioned returns a new VersionedPostgresStore instance, with a
+// background cleanup goroutine that runs every 5 minutes to remove expired
+// session data.
+func NewVersioned(db *sql.DB) *VersionedPostgresStore {
+	return NewVersionedWithCleanupInterval(db, 5*time.Minute)
+}
+
+// NewVersionedWithCleanupInterval returns a new VersionedPostgresStore
+// instance. The cleanupInterval parameter controls how frequently expired
+// session data is removed by the background cleanup goroutine. Setting it to
+// 0 prevents the cleanup goroutine from running (i.e. expired sessions will
+// not be removed).
+func NewVersionedWithCleanupInterval(db *sql.DB, cleanupInterval time.Duration) *VersionedPostgresStore {
+	return &VersionedPostgresStore{NewWithCleanupInterval(db, cleanupInterval)}
+}
+
+// FindVersion returns the data and version for a given session token from the
+// VersionedPostgresStore instance. If the session token is not found or is
+// expired, the returned exists flag will be set to false.
+func (p *VersionedPostgresStore) FindVersion(token string) (b []byte, version int64, exists bool, err error) {
+	row := p.db.QueryRow("SELECT data, version FROM sessions WHERE token = $1 AND current_timestamp < expiry", token)
+	err = row.Scan(&b, &version)
+	if err == sql.ErrNoRows {
+		return nil, 0, false, nil
+	} else if err != nil {
+		return nil, 0, false, err
+	}
+	return b, version, true, nil
+}
+
+// Commit adds a session token and data to the VersionedPostgresStore instance
+// with the given expiry time, regardless of its version. If the session token
+// already exists, then the data and expiry time are updated and the version
+// is incremented.
+func (p *VersionedPostgresStore) Commit(token string, b []byte, expiry time.Time) error {
+	_, err := p.db.Exec("INSERT INTO sessions (token, data, expiry, version) VALUES ($1, $2, $3, 1) ON CONFLICT (token) DO UPDATE SET data = EXCLUDED.data, expiry = EXCLUDED.expiry, version = sessions.version + 1", token, b, expiry)
+	return err
+}
+
+// CommitIfUnchanged adds or updates a session token and data in the
+// VersionedPostgresStore instance with the given expiry time, if the stored
+// version is still expectedVersion. An expectedVersion of 0 means the session
+// token must not exist (or must have expired). The returned ok flag is false
+// if the version did not match and nothing was written.
+func (p *VersionedPostgresStore) CommitIfUnchanged(token string, b []byte, expiry time.Time, expectedVersion int64) (newVersion int64, ok bool, err error) {
+	var row *sql.Row
+	if expectedVersion == 0 {
+		row = p.db.QueryRow("INSERT INTO sessions (token, data, expiry, version) VALUES ($1, $2, $3, 1) ON CONFLICT (token) DO UPDATE SET data = EXCLUDED.data, expiry = EXCLUDED.expiry, version = sessions.version + 1 WHERE sessions.expiry <= current_timestamp RETURNING version", token, b, expiry)
+	} else {
+		row = p.db.QueryRow("UPDATE sessions SET data = $2, expiry = $3, version = version + 1 WHERE token = $1 AND version = $4 AND current_timestamp < expiry RETURNING version", token, b, expiry, expectedVersion)
+	}
+	err = row.Scan(&newVersion)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return newVersion, true, nil
+}
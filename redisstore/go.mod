@@ -2,4 +2,9 @@ module github.com/alexedwards/scs/redisstore
 
 go 1.12
 
-require github.com/gomodule/redigo v2.0.0+incompatible
+require (
+	github.com/aberlorn/scs/v2 v2.0.0
+	github.com/gomodule/redigo v2.0.0+incompatible
+)
+
+replace github.com/aberlorn/scs/v2 => ../
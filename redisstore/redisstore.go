@@ -1,6 +1,7 @@
 package redisstore
 
 import (
+	"context"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
@@ -67,6 +68,20 @@ func (r *RedisStore) Commit(token string, b []byte, expiry time.Time) error {
 	return err
 }
 
+// Touch updates the expiry time of a session token in the RedisStore instance
+// with a PEXPIREAT command, without rewriting the data. If the session token
+// is not found or is expired, the returned found flag will be set to false.
+func (r *RedisStore) Touch(token string, expiry time.Time) (found bool, err error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	n, err := redis.Int(conn.Do("PEXPIREAT", r.prefix+token, makeMillisecondTimestamp(expiry)))
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
 // Delete removes a session token and corresponding data from the RedisStore
 // instance.
 func (r *RedisStore) Delete(token string) error {
@@ -77,6 +92,19 @@ func (r *RedisStore) Delete(token string) error {
 	return err
 }
 
+// Ping checks that a connection to Redis can be made and that it responds to
+// a PING command.
+func (r *RedisStore) Ping(ctx context.Context) error {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("PING")
+	return err
+}
+
 func makeMillisecondTimestamp(t time.Time) int64 {
 	return t.UnixNano() / (int64(time.Millisecond) / int64(time.Nanosecond))
 }
@@ -7,9 +7,16 @@ import (
 	"testing"
 	"time"
 
+	"github.com/aberlorn/scs/v2"
 	"github.com/gomodule/redigo/redis"
 )
 
+var (
+	_ scs.Store          = (*RedisStore)(nil)
+	_ scs.Pingable       = (*RedisStore)(nil)
+	_ scs.TouchableStore = (*RedisStore)(nil)
+)
+
 func TestFind(t *testing.T) {
 	redisPool := redis.NewPool(func() (redis.Conn, error) {
 		addr := os.Getenv("SCS_REDIS_TEST_DSN")
@@ -223,3 +230,60 @@ func TestDelete(t *testing.T) {
 		t.Fatalf("got %v: expected %v", data, nil)
 	}
 }
+
+func TestTouch(t *testing.T) {
+	redisPool := redis.NewPool(func() (redis.Conn, error) {
+		addr := os.Getenv("SCS_REDIS_TEST_DSN")
+		conn, err := redis.Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return conn, err
+	}, 1)
+	defer redisPool.Close()
+
+	conn := redisPool.Get()
+	defer conn.Close()
+	_, err := conn.Do("FLUSHDB")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := New(redisPool)
+
+	err = r.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := r.Touch("session_token", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+
+	ttl, err := redis.Int64(conn.Do("PTTL", r.prefix+"session_token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl < int64(59*time.Minute/time.Millisecond) {
+		t.Fatalf("got %d: expected about %d", ttl, int64(time.Hour/time.Millisecond))
+	}
+	b, found, err := r.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true || bytes.Equal(b, []byte("encoded_data")) == false {
+		t.Fatalf("got %v, %v: expected %v, %v", b, found, []byte("encoded_data"), true)
+	}
+
+	found, err = r.Touch("missing_session_token", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
@@ -0,0 +1,200 @@
+package scs
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// TypeRegistry maps the types of session values to names, and holds the
+// functions used to marshal and unmarshal values of each type, for use by a
+// RegistryCodec. Unlike gob.Register, registration is scoped to the registry,
+// so two session managers with different registries don't interfere with each
+// other. It is safe for concurrent use.
+type TypeRegistry struct {
+	mu     sync.RWMutex
+	byName map[string]*typeEntry
+	byType map[reflect.Type]*typeEntry
+}
+
+type typeEntry struct {
+	name      string
+	typ       reflect.Type
+	marshal   func(v interface{}) ([]byte, error)
+	unmarshal func(b []byte) (interface{}, error)
+}
+
+// NewTypeRegistry returns a new TypeRegistry with the basic types already
+// registered under their Go names: string, bool, the integer and float types,
+// []byte, []string, map[string]string, time.Time and time.Duration.
+func NewTypeRegistry() *TypeRegistry {
+	r := &TypeRegistry{
+		byName: make(map[string]*typeEntry),
+		byType: make(map[reflect.Type]*typeEntry),
+	}
+	for _, v := range []interface{}{
+		"", false,
+		int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+		float32(0), float64(0),
+		[]byte(nil), []string(nil), map[string]string(nil),
+		time.Time{}, time.Duration(0),
+	} {
+		name := reflect.TypeOf(v).String()
+		if err := r.Register(name, v); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+// Register registers the type of v under name, marshaling values of the type
+// with encoding/json. v should be a representative value of the type, such as
+// its zero value. An error is returned if the name or the type has already
+// been registered.
+func (r *TypeRegistry) Register(name string, v interface{}) error {
+	typ := reflect.TypeOf(v)
+	unmarshal := func(b []byte) (interface{}, error) {
+		p := reflect.New(typ)
+		if err := json.Unmarshal(b, p.Interface()); err != nil {
+			return nil, err
+		}
+		return p.Elem().Interface(), nil
+	}
+	return r.RegisterFunc(name, v, json.Marshal, unmarshal)
+}
+
+// RegisterFunc registers the type of v under name, marshaling values of the
+// type with marshal and unmarshaling them with unmarshal, which must return a
+// value of the same type. An error is returned if the name or the type has
+// already been registered.
+func (r *TypeRegistry) RegisterFunc(name string, v interface{}, marshal func(v interface{}) ([]byte, error), unmarshal func(b []byte) (interface{}, error)) error {
+	typ := reflect.TypeOf(v)
+	if typ == nil {
+		return fmt.Errorf("scs: can't register the type of a nil value as %q", name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if te, ok := r.byName[name]; ok {
+		return fmt.Errorf("scs: type name %q is already registered for %v", name, te.typ)
+	}
+	if te, ok := r.byType[typ]; ok {
+		return fmt.Errorf("scs: type %v is already registered as %q", typ, te.name)
+	}
+
+	te := &typeEntry{name: name, typ: typ, marshal: marshal, unmarshal: unmarshal}
+	r.byName[name] = te
+	r.byType[typ] = te
+	return nil
+}
+
+func (r *TypeRegistry) lookupType(typ reflect.Type) (*typeEntry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	te, ok := r.byType[typ]
+	return te, ok
+}
+
+func (r *TypeRegistry) lookupName(name string) (*typeEntry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	te, ok := r.byName[name]
+	return te, ok
+}
+
+// RegistryCodec is used for encoding/decoding session data to and from a
+// byte slice, storing each value with the name of its type in a TypeRegistry
+// rather than relying on gob.Register. Values of types which are not in the
+// registry can't be encoded, and data holding a type name which is not in the
+// registry can't be decoded; both are reported as errors.
+type RegistryCodec struct {
+	Registry *TypeRegistry
+}
+
+// NewRegistryCodec returns a new RegistryCodec using registry.
+func NewRegistryCodec(registry *TypeRegistry) *RegistryCodec {
+	return &RegistryCodec{Registry: registry}
+}
+
+// taggedValue is a session value marshaled by its registered type. A nil
+// value has an empty Type.
+type taggedValue struct {
+	Type string
+	Data []byte
+}
+
+// Encode converts a session deadline and values into a byte slice.
+func (rc *RegistryCodec) Encode(deadline time.Time, values map[string]interface{}) ([]byte, error) {
+	aux := &struct {
+		Deadline time.Time
+		Values   map[string]taggedValue
+	}{
+		Deadline: deadline,
+		Values:   make(map[string]taggedValue, len(values)),
+	}
+
+	for key, val := range values {
+		if val == nil {
+			aux.Values[key] = taggedValue{}
+			continue
+		}
+		te, ok := rc.Registry.lookupType(reflect.TypeOf(val))
+		if !ok {
+			return nil, fmt.Errorf("scs: type %T of session value %q is not registered", val, key)
+		}
+		b, err := te.marshal(val)
+		if err != nil {
+			return nil, fmt.Errorf("scs: session value %q can't be marshaled: %v", key, err)
+		}
+		aux.Values[key] = taggedValue{Type: te.name, Data: b}
+	}
+
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(&aux)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// Decode converts a byte slice into a session deadline and values.
+func (rc *RegistryCodec) Decode(b []byte) (time.Time, map[string]interface{}, error) {
+	aux := &struct {
+		Deadline time.Time
+		Values   map[string]taggedValue
+	}{}
+
+	r := bytes.NewReader(b)
+	err := gob.NewDecoder(r).Decode(&aux)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+
+	values := make(map[string]interface{}, len(aux.Values))
+	for key, tv := range aux.Values {
+		if tv.Type == "" {
+			values[key] = nil
+			continue
+		}
+		te, ok := rc.Registry.lookupName(tv.Type)
+		if !ok {
+			return time.Time{}, nil, fmt.Errorf("scs: type name %q of session value %q is not registered", tv.Type, key)
+		}
+		val, err := te.unmarshal(tv.Data)
+		if err != nil {
+			return time.Time{}, nil, fmt.Errorf("scs: session value %q can't be unmarshaled: %v", key, err)
+		}
+		values[key] = val
+	}
+
+	return aux.Deadline, values, nil
+}
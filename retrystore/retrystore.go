@@ -0,0 +1,170 @@
+package retrystore
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/aberlorn/scs/v2"
+)
+
+// RetryStore represents the session store. It wraps another store, retrying
+// Find, Commit and Delete with exponential backoff when they fail, so that a
+// transient error (such as a dropped connection to Redis or a database)
+// doesn't lose the change to a session.
+type RetryStore struct {
+	// Retryable reports whether an operation which failed with err should be
+	// retried. Errors for which it returns false, such as a malformed query,
+	// are returned straight away. If it is nil, every error is retried.
+	Retryable func(err error) bool
+
+	inner    scs.Store
+	attempts int
+	backoff  time.Duration
+}
+
+var (
+	_ scs.Store    = (*RetryStore)(nil)
+	_ scs.Pingable = (*RetryStore)(nil)
+)
+
+// New returns a new RetryStore instance wrapping inner. Each operation is
+// tried at most attempts times, waiting backoff after the first failure and
+// twice as long after each failure after that. An attempts value of less
+// than 1 means 1, so operations aren't retried.
+//
+// If inner implements scs.ContextStore, use NewContext instead so that the
+// context of the current request is used.
+func New(inner scs.Store, attempts int, backoff time.Duration) *RetryStore {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return &RetryStore{
+		inner:    inner,
+		attempts: attempts,
+		backoff:  backoff,
+	}
+}
+
+// Find returns the data for a given session token from the inner store,
+// retrying if it fails.
+func (r *RetryStore) Find(token string) ([]byte, bool, error) {
+	var b []byte
+	var found bool
+	err := r.retry(context.Background(), func() (err error) {
+		b, found, err = r.inner.Find(token)
+		return err
+	})
+	return b, found, err
+}
+
+// Commit adds a session token and data to the inner store with the given
+// expiry time, retrying if it fails.
+func (r *RetryStore) Commit(token string, b []byte, expiry time.Time) error {
+	return r.retry(context.Background(), func() error {
+		return r.inner.Commit(token, b, expiry)
+	})
+}
+
+// Delete removes a session token and corresponding data from the inner store,
+// retrying if it fails.
+func (r *RetryStore) Delete(token string) error {
+	return r.retry(context.Background(), func() error {
+		return r.inner.Delete(token)
+	})
+}
+
+// Ping checks that the inner store is reachable, if it implements
+// scs.Pingable. It is not retried.
+func (r *RetryStore) Ping(ctx context.Context) error {
+	if ps, ok := r.inner.(scs.Pingable); ok {
+		return ps.Ping(ctx)
+	}
+	return nil
+}
+
+// retry calls fn until it succeeds, fails with an error which is not
+// retryable, or has been called r.attempts times, returning the last error.
+// It gives up early if ctx is done, or its deadline would pass while waiting
+// to try again.
+func (r *RetryStore) retry(ctx context.Context, fn func() error) error {
+	backoff := r.backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= r.attempts {
+			return err
+		}
+		if r.Retryable != nil && !r.Retryable(err) {
+			return err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// ContextRetryStore represents the session store. It is a RetryStore for an
+// inner store which implements scs.ContextStore, and stops retrying when the
+// context of the current request is cancelled or its deadline would pass.
+type ContextRetryStore struct {
+	*RetryStore
+
+	inner scs.ContextStore
+}
+
+var _ scs.ContextStore = (*ContextRetryStore)(nil)
+
+// NewContext returns a new ContextRetryStore instance wrapping inner, with
+// attempts and backoff as for New.
+func NewContext(inner scs.ContextStore, attempts int, backoff time.Duration) *ContextRetryStore {
+	return &ContextRetryStore{
+		RetryStore: New(inner, attempts, backoff),
+		inner:      inner,
+	}
+}
+
+// FindContext implements scs.ContextStore, retrying FindContext on the inner
+// store if it fails.
+func (r *ContextRetryStore) FindContext(c scs.SessionContext, token string) ([]byte, bool, error) {
+	var b []byte
+	var found bool
+	err := r.retry(requestContext(c), func() (err error) {
+		b, found, err = r.inner.FindContext(c, token)
+		return err
+	})
+	return b, found, err
+}
+
+// CommitContext implements scs.ContextStore, retrying CommitContext on the
+// inner store if it fails.
+func (r *ContextRetryStore) CommitContext(c scs.SessionContext, token string, b []byte, expiry time.Time) error {
+	return r.retry(requestContext(c), func() error {
+		return r.inner.CommitContext(c, token, b, expiry)
+	})
+}
+
+// DeleteContext implements scs.ContextStore, retrying DeleteContext on the
+// inner store if it fails.
+func (r *ContextRetryStore) DeleteContext(c scs.SessionContext, token string) error {
+	return r.retry(requestContext(c), func() error {
+		return r.inner.DeleteContext(c, token)
+	})
+}
+
+// requestContext returns the context of the request in c, such as an
+// echo.Context, or context.Background if there is none.
+func requestContext(c scs.SessionContext) context.Context {
+	if rc, ok := c.(interface{ Request() *http.Request }); ok && rc.Request() != nil {
+		return rc.Request().Context()
+	}
+	return context.Background()
+}
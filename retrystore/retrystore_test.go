@@ -0,0 +1,196 @@
+package retrystore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aberlorn/scs/v2"
+	"github.com/aberlorn/scs/v2/memstore"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	errFlaky     = errors.New("connection reset")
+	errPermanent = errors.New("syntax error")
+)
+
+// flakyStore is a memstore whose operations fail with err the first failures
+// times they are called.
+type flakyStore struct {
+	*memstore.MemStore
+	failures int
+	err      error
+	calls    int
+}
+
+func newFlakyStore(failures int, err error) *flakyStore {
+	return &flakyStore{MemStore: memstore.NewWithCleanupInterval(0), failures: failures, err: err}
+}
+
+func (f *flakyStore) fail() error {
+	f.calls++
+	if f.calls <= f.failures {
+		return f.err
+	}
+	return nil
+}
+
+func (f *flakyStore) Find(token string) ([]byte, bool, error) {
+	if err := f.fail(); err != nil {
+		return nil, false, err
+	}
+	return f.MemStore.Find(token)
+}
+
+func (f *flakyStore) Commit(token string, b []byte, expiry time.Time) error {
+	if err := f.fail(); err != nil {
+		return err
+	}
+	return f.MemStore.Commit(token, b, expiry)
+}
+
+func (f *flakyStore) Delete(token string) error {
+	if err := f.fail(); err != nil {
+		return err
+	}
+	return f.MemStore.Delete(token)
+}
+
+// flakyContextStore is a flakyStore which implements scs.ContextStore.
+type flakyContextStore struct {
+	*flakyStore
+}
+
+func (f flakyContextStore) FindContext(c scs.SessionContext, token string) ([]byte, bool, error) {
+	return f.Find(token)
+}
+
+func (f flakyContextStore) CommitContext(c scs.SessionContext, token string, b []byte, expiry time.Time) error {
+	return f.Commit(token, b, expiry)
+}
+
+func (f flakyContextStore) DeleteContext(c scs.SessionContext, token string) error {
+	return f.Delete(token)
+}
+
+func TestCommitRetried(t *testing.T) {
+	inner := newFlakyStore(2, errFlaky)
+	r := New(inner, 3, time.Millisecond)
+
+	err := r.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+	if inner.calls != 3 {
+		t.Fatalf("got %d: expected %d", inner.calls, 3)
+	}
+
+	inner.calls = 0
+	b, found, err := r.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+	if bytes.Equal(b, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("encoded_data"))
+	}
+
+	inner.calls = 0
+	if err := r.Delete("session_token"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := inner.MemStore.Find("session_token"); found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestAttemptsExhausted(t *testing.T) {
+	inner := newFlakyStore(5, errFlaky)
+	r := New(inner, 3, time.Millisecond)
+
+	err := r.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != errFlaky {
+		t.Fatalf("got %v: expected %v", err, errFlaky)
+	}
+	if inner.calls != 3 {
+		t.Fatalf("got %d: expected %d", inner.calls, 3)
+	}
+}
+
+func TestPermanentError(t *testing.T) {
+	inner := newFlakyStore(5, errPermanent)
+	r := New(inner, 3, time.Millisecond)
+	r.Retryable = func(err error) bool {
+		return err != errPermanent
+	}
+
+	err := r.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != errPermanent {
+		t.Fatalf("got %v: expected %v", err, errPermanent)
+	}
+	if inner.calls != 1 {
+		t.Fatalf("got %d: expected %d", inner.calls, 1)
+	}
+}
+
+func TestContextDeadline(t *testing.T) {
+	inner := flakyContextStore{newFlakyStore(2, errFlaky)}
+	r := NewContext(inner, 3, time.Millisecond)
+
+	req := httptest.NewRequest(echo.GET, "/", nil)
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+	err := r.CommitContext(c, "session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+	if inner.calls != 3 {
+		t.Fatalf("got %d: expected %d", inner.calls, 3)
+	}
+
+	// Retrying stops if the request deadline would pass while waiting.
+	inner.calls = 0
+	r = NewContext(inner, 3, time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c = echo.New().NewContext(req.WithContext(ctx), httptest.NewRecorder())
+	err = r.CommitContext(c, "session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != errFlaky {
+		t.Fatalf("got %v: expected %v", err, errFlaky)
+	}
+	if inner.calls != 1 {
+		t.Fatalf("got %d: expected %d", inner.calls, 1)
+	}
+}
+
+func TestSession(t *testing.T) {
+	inner := newFlakyStore(1, errFlaky)
+	session := scs.NewSession()
+	session.Store = New(inner, 3, time.Millisecond)
+
+	req := httptest.NewRequest(echo.GET, "/", nil)
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+	if _, err := session.Load(c, ""); err != nil {
+		t.Fatal(err)
+	}
+	session.Put(c, "foo", "bar")
+	token, _, err := session.Commit(c)
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+
+	inner.calls = 0
+	inner.failures = 1
+	c = echo.New().NewContext(req, httptest.NewRecorder())
+	if _, err := session.Load(c, token); err != nil {
+		t.Fatal(err)
+	}
+	if got := session.GetString(c, "foo"); got != "bar" {
+		t.Fatalf("got %q: expected %q", got, "bar")
+	}
+}
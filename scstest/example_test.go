@@ -0,0 +1,88 @@
+package scstest_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aberlorn/scs/v2"
+	"github.com/aberlorn/scs/v2/memstore"
+	"github.com/aberlorn/scs/v2/scstest"
+)
+
+func ExampleWithSession() {
+	session := scs.NewSession()
+	c := scstest.WithSession(session)
+
+	session.Put(c, "message", "Hello from a session!")
+	fmt.Println(session.GetString(c, "message"))
+	// Output: Hello from a session!
+}
+
+func ExampleContext_AddCookie() {
+	session := scs.NewSession()
+
+	// Save a session, then load it again with the session cookie.
+	c := scstest.NewContext()
+	session.LoadCheck(c)
+	session.Put(c, "message", "Hello again!")
+	session.SaveCheck(c)
+
+	next := scstest.NewContext()
+	for _, cookie := range c.ResponseCookies() {
+		next.AddCookie(cookie)
+	}
+	session.LoadCheck(next)
+	fmt.Println(session.GetString(next, "message"))
+	// Output: Hello again!
+}
+
+func ExampleSequentialTokens() {
+	session := scs.NewSession()
+	session.TokenGenerator = scstest.SequentialTokens("token")
+
+	for i := 0; i < 2; i++ {
+		c := scstest.WithSession(session)
+		session.Put(c, "message", "Hello!")
+		token, _, _ := session.Commit(c)
+		fmt.Println(token)
+	}
+
+	// Renewing the token of a session also uses the generator.
+	c := scstest.WithSession(session)
+	session.Put(c, "message", "Hello!")
+	session.Commit(c)
+	session.RenewToken(c)
+	token, _, _ := session.Commit(c)
+	fmt.Println(token)
+	// Output:
+	// token-1
+	// token-2
+	// token-4
+}
+
+func ExampleClock() {
+	clock := scstest.NewClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	session := scs.NewSession()
+	session.Clock = clock
+	session.IdleTimeout = 20 * time.Minute
+	session.Lifetime = time.Hour
+
+	c := scstest.WithSession(session)
+	fmt.Println(session.ExpiryTime(c).Format("15:04"))
+
+	// The idle timeout slides forward, but not past the lifetime.
+	clock.Advance(30 * time.Minute)
+	fmt.Println(session.ExpiryTime(c).Format("15:04"))
+	clock.Advance(time.Hour)
+	fmt.Println(session.ExpiryTime(c).Format("15:04"))
+	// Output:
+	// 12:20
+	// 12:50
+	// 13:00
+}
+
+func ExampleStoreInterfaces() {
+	store := memstore.NewWithConfig(memstore.Config{CleanupInterval: -1})
+	fmt.Println(scstest.StoreInterfaces(store))
+	// Output: [CountableStore GarbageCollectable ExpiryStore]
+}
@@ -0,0 +1,176 @@
+// Package scstest provides a lightweight scs.SessionContext for testing code
+// which uses sessions, without needing an echo context, a deterministic
+// session token generator and a fake clock.
+package scstest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aberlorn/scs/v2"
+)
+
+// Context is an in-memory implementation of scs.SessionContext. Values are
+// held in a map, request cookies are added with AddCookie and response
+// headers (such as the session cookie) are written to Recorder.
+type Context struct {
+	// Recorder records the response headers written by the session.
+	Recorder *httptest.ResponseRecorder
+
+	values  map[string]interface{}
+	cookies map[string]*http.Cookie
+}
+
+// NewContext returns a new, empty Context.
+func NewContext() *Context {
+	return &Context{
+		Recorder: httptest.NewRecorder(),
+		values:   make(map[string]interface{}),
+		cookies:  make(map[string]*http.Cookie),
+	}
+}
+
+// WithSession returns a new Context with a new, empty session from s already
+// loaded into it, so the session can be used straight away.
+func WithSession(s *scs.Session) *Context {
+	c := NewContext()
+	if _, err := s.Load(c, ""); err != nil {
+		// Loading a new session does not use the store, so this should
+		// never happen.
+		panic(err)
+	}
+	return c
+}
+
+// Get returns the value for key.
+func (c *Context) Get(key string) interface{} {
+	return c.values[key]
+}
+
+// Set stores val under key.
+func (c *Context) Set(key string, val interface{}) {
+	c.values[key] = val
+}
+
+// Cookie returns the named request cookie, or http.ErrNoCookie if it has not
+// been added.
+func (c *Context) Cookie(name string) (*http.Cookie, error) {
+	cookie, ok := c.cookies[name]
+	if !ok {
+		return nil, http.ErrNoCookie
+	}
+	return cookie, nil
+}
+
+// AddCookie adds a request cookie to the Context, replacing any cookie with
+// the same name. This can be used to pass a session cookie from an earlier
+// response to LoadCheck.
+func (c *Context) AddCookie(cookie *http.Cookie) {
+	c.cookies[cookie.Name] = cookie
+}
+
+// ResponseCookies returns the cookies written to Recorder.
+func (c *Context) ResponseCookies() []*http.Cookie {
+	return (&http.Response{Header: c.Recorder.Header()}).Cookies()
+}
+
+// HeaderWriter returns the Recorder header as an scs.HeaderWriter.
+func (c *Context) HeaderWriter() scs.HeaderWriter {
+	return responseHeader(c.Recorder.Header())
+}
+
+type responseHeader http.Header
+
+func (h responseHeader) Add(key, value string) {
+	http.Header(h).Add(key, value)
+}
+
+func (h responseHeader) Values(key string) []string {
+	return http.Header(h)[http.CanonicalHeaderKey(key)]
+}
+
+// SequentialTokens returns a generator for Session.TokenGenerator which
+// creates the predictable tokens prefix-1, prefix-2 and so on, so that tests
+// can assert on token values. It is safe for concurrent use. Never use it
+// outside of tests, as the tokens are trivial to guess.
+func SequentialTokens(prefix string) func() (string, error) {
+	var n uint64
+	return func() (string, error) {
+		return prefix + "-" + strconv.FormatUint(atomic.AddUint64(&n, 1), 10), nil
+	}
+}
+
+// Clock is a fake scs.Clock for Session.Clock, which only moves when it is
+// told to, so tests can check idle timeouts and lifetimes without sleeping.
+// It is safe for concurrent use. Session stores check expiry against the real
+// time, so keep the clock close to it when using a store such as memstore.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a new Clock set to now.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the current time of the Clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the Clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set sets the current time of the Clock to now.
+func (c *Clock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// storeInterfaces are the optional interfaces which a session store can
+// implement, in the order they are reported by StoreInterfaces.
+var storeInterfaces = []struct {
+	name string
+	typ  reflect.Type
+}{
+	{"CountableStore", reflect.TypeOf((*scs.CountableStore)(nil)).Elem()},
+	{"GarbageCollectable", reflect.TypeOf((*scs.GarbageCollectable)(nil)).Elem()},
+	{"VersionedStore", reflect.TypeOf((*scs.VersionedStore)(nil)).Elem()},
+	{"ExpiryStore", reflect.TypeOf((*scs.ExpiryStore)(nil)).Elem()},
+	{"Pingable", reflect.TypeOf((*scs.Pingable)(nil)).Elem()},
+	{"TouchableStore", reflect.TypeOf((*scs.TouchableStore)(nil)).Elem()},
+	{"ContextStore", reflect.TypeOf((*scs.ContextStore)(nil)).Elem()},
+	{"ClientSideStore", reflect.TypeOf((*scs.ClientSideStore)(nil)).Elem()},
+}
+
+// StoreInterfaces returns the names of the optional scs interfaces, such as
+// "ExpiryStore" and "Pingable", which store implements. It is intended for
+// debugging a custom store, or for asserting in its tests that a method
+// signature has not been got subtly wrong; compile-time checks such as
+//
+//	var _ scs.ExpiryStore = (*MyStore)(nil)
+//
+// should be used as well where the store can import scs.
+func StoreInterfaces(store scs.Store) []string {
+	typ := reflect.TypeOf(store)
+	names := []string{}
+	for _, si := range storeInterfaces {
+		if typ.Implements(si.typ) {
+			names = append(names, si.name)
+		}
+	}
+	return names
+}
@@ -1,11 +1,19 @@
 package scs
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aberlorn/scs/v2/memstore"
+	"github.com/labstack/echo/v4"
 )
 
 // Session holds the configuration settings for your sessions.
@@ -13,21 +21,208 @@ type Session struct {
 	// IdleTimeout controls the maximum length of time a session can be inactive
 	// before it expires. For example, some applications may wish to set this so
 	// there is a timeout after 20 minutes of inactivity.  By default IdleTimeout
-	// is not set and there is no inactivity timeout.
+	// is not set and there is no inactivity timeout. It can be overridden for
+	// individual sessions with SetIdleTimeout.
 	IdleTimeout time.Duration
 
+	// RefreshInterval controls how often an unmodified session is re-committed
+	// to refresh its idle timeout. When set, SaveCheck only refreshes the
+	// session once its remaining idle time has dropped below RefreshInterval,
+	// rather than on every request, which saves store writes for read-only
+	// requests. It has no effect unless IdleTimeout is set. By default
+	// RefreshInterval is not set and the session is refreshed on every request.
+	RefreshInterval time.Duration
+
+	// RotateInterval controls how often the session token is renewed
+	// automatically, limiting how long a stolen token can be used for. When
+	// set, LoadCheck renews the token of a session which has had the same
+	// token for at least RotateInterval (see RotateIfDue). Unlike RenewToken,
+	// this does not extend the session lifetime. By default RotateInterval is
+	// not set and tokens are only renewed by RenewToken or Renew.
+	RotateInterval time.Duration
+
+	// RenewGracePeriod controls how long the old token stays valid after the
+	// token is renewed by RenewToken or RotateIfDue, so that requests already
+	// in flight with the old cookie still find their session. When set, the
+	// data stored under the old token is re-committed to expire after
+	// RenewGracePeriod (but no later than its deadline) instead of being
+	// deleted. A request which commits the session under the old token during
+	// the grace period stores it again with the usual expiry, so keep the
+	// period short; a few seconds is enough to cover parallel requests. It is
+	// ignored for a ClientSideStore, and Renew always deletes the old token. By
+	// default RenewGracePeriod is not set and the old token is deleted as soon
+	// as the session has been committed under the new one.
+	RenewGracePeriod time.Duration
+
 	// Lifetime controls the maximum length of time that a session is valid for
 	// before it expires. The lifetime is an 'absolute expiry' which is set when
 	// the session is first created and does not change. The default value is 24
-	// hours.
+	// hours. It can be overridden for individual sessions with SetLifetime.
+	// The deadline is an instant (stored in UTC) rather than a wall-clock
+	// time, so a session always lasts for exactly Lifetime of elapsed time,
+	// even across a daylight saving time change in the local time zone.
 	Lifetime time.Duration
 
 	// Store controls the session store where the session data is persisted.
 	Store Store
 
+	// Codec controls the encoder/decoder used to transform session data to a
+	// byte slice for use by the session store. By default session data is
+	// encoded with encoding/gob (see GobCodec).
+	Codec Codec
+
+	// AutoRegisterGob controls whether Put and PutAll register the concrete
+	// type of each value with gob (see gob.Register) the first time the type
+	// is seen, so that custom types can be stored with the GobCodec without
+	// calling RegisterType. Types which gob knows about already, such as
+	// string and int, are skipped. Note that gob registration is global to the
+	// process, not scoped to the session manager, so a registered type can't
+	// later be registered under a different name with gob.RegisterName.
+	AutoRegisterGob bool
+
+	// StrictDecode controls what happens when session data loaded from the
+	// store can't be decoded, for example after a change to the types stored
+	// in sessions or a Codec key which is no longer configured. By default the
+	// error is logged, the data is deleted from the store and a new session is
+	// started, as if the token had not been found. Set StrictDecode to return
+	// the error from Load (and LoadCheck) instead.
+	StrictDecode bool
+
+	// DataHeader controls whether a magic/version header is written before
+	// the encoded session data and verified when it is loaded. Data without a
+	// matching header (e.g. written by another application sharing the store)
+	// is treated as not found and a fresh session is created. Enabling this
+	// on an existing deployment resets sessions stored without the header.
+	// The default value is false.
+	DataHeader bool
+
+	// TokenLength sets the number of random bytes in a session token, before
+	// it is base64 encoded. It must be at least 16. The default value is 32.
+	TokenLength int
+
+	// TokenEncoding sets how the random bytes of a session token are encoded.
+	// The default value is Base64URL. It has no effect on tokens from a
+	// TokenGenerator.
+	TokenEncoding TokenEncoding
+
+	// TokenGenerator, if set, is used instead of the default random token
+	// generator to create new session tokens (e.g. for prefixed tokens or
+	// UUIDs). The tokens it returns must be unpredictable and unique.
+	TokenGenerator func() (string, error)
+
+	// SerializeByToken controls whether requests in this process which carry
+	// the same session token are serialized. When set, LoadCheck blocks until
+	// no other request holds the token, and the lock is held until Release is
+	// called (the Sessions middleware calls it after the handler returns).
+	// LoadCheck implementations which read the token from elsewhere, such as
+	// a header, take the lock with LoadToken.
+	// This stops parallel requests from overwriting each other's changes, at
+	// the cost of handling them one at a time. It has no effect across
+	// processes. The default value is false.
+	SerializeByToken bool
+
 	// Cookie contains the configuration settings for session cookies.
 	Cookie SessionCookie     `json:"cookie"`
 
+	// MaxCookieSize sets the maximum length in bytes of the serialized
+	// session cookie. Browsers silently drop cookies longer than about 4KB,
+	// so WriteSessionCookie returns ErrCookieTooLarge instead of writing a
+	// longer cookie. A value of 0 disables the check. NewSession sets it to
+	// 4096.
+	MaxCookieSize int
+
+	// ManageCacheHeaders controls whether WriteSessionCookie adds
+	// Cache-Control and Vary headers (unless they are set already) so that
+	// responses setting the session cookie aren't cached by shared caches.
+	// Set it to false if your application manages caching headers itself.
+	// NewSession sets it to true.
+	ManageCacheHeaders bool
+
+	// CacheControl sets the value of the Cache-Control header added by
+	// WriteSessionCookie when ManageCacheHeaders is true. The default value
+	// is DefaultCacheControl; some proxies mishandle its quoted field name,
+	// in which case a value such as "private" can be used instead.
+	CacheControl string
+
+	// MaxEncodedSize sets the maximum length in bytes of the encoded session
+	// data, as returned by the Codec. Commit returns ErrSessionTooLarge
+	// instead of writing longer data to the store, which guards against a bug
+	// or abuse filling a session with data that is then stored and decoded on
+	// every request. A value of 0 (the default) disables the check.
+	MaxEncodedSize int
+
+	// TokenQueryParam sets the name of a query parameter which LoadCheck
+	// reads the session token from when the request has no session cookie,
+	// such as "sid" for WebSocket upgrades or webhook callbacks which can't
+	// send cookies. The token is never written back to a query string; it is
+	// still sent to the client in the session cookie. Tokens in URLs can leak
+	// into server logs and Referer headers, so this is disabled by default.
+	TokenQueryParam string
+
+	// Metrics records counters for session activity and store errors. The
+	// default value is NoopMetrics.
+	Metrics Metrics
+
+	// Logger receives structured log events for session activity and store
+	// errors. The default value is NoopLogger.
+	Logger Logger
+
+	// Clock is the source of the current time for session deadlines, idle
+	// timeouts and token rotation. The default value is SystemClock; tests
+	// can set a fake Clock to check expiry without sleeping.
+	Clock Clock
+
+	// OnLoad is called after Load has loaded the session data for a request,
+	// with the session token and whether the session is new (in which case
+	// the token is empty).
+	OnLoad func(token string, isNew bool)
+
+	// OnCommit is called after the session data has been committed to the
+	// session store successfully, with the session token and expiry time.
+	OnCommit func(token string, expiry time.Time)
+
+	// OnDestroy is called after Destroy has deleted the session data from the
+	// session store successfully, with the token the session had.
+	//
+	// The OnLoad, OnCommit and OnDestroy hooks are called after the session
+	// data lock has been released, so they may safely use the session. They
+	// are useful for auditing and cache invalidation.
+	OnDestroy func(token string)
+
+	// OnKeyChange is called when Put, Remove or Pop (or their variants)
+	// changes the value of one of the WatchKeys. The old or new value is nil
+	// when the key was absent or removed. It is called after the session data
+	// lock has been released, so it may safely use the session. This is useful
+	// for invalidating application caches derived from session values.
+	OnKeyChange func(key string, oldVal, newVal interface{})
+
+	// WatchKeys controls which keys OnKeyChange is called for. Only watching
+	// the keys of interest avoids any overhead for other keys.
+	WatchKeys []string
+
+	// BindValidator returns the fingerprint of the client for the current
+	// request, such as a hash of the User-Agent header and/or a truncated IP
+	// address. If it is set, sessions bound with Bind are destroyed when the
+	// fingerprint changes, which helps to detect stolen session cookies.
+	BindValidator func(c echo.Context) string
+
+	// types holds the values passed to RegisterType.
+	types []interface{}
+
+	// cookieSecrets are the keys used to sign and verify the session cookie
+	// (see SignCookie). The cookie is not signed if there are none.
+	cookieSecrets [][]byte
+
+	// stopCleanup stops the goroutine started by StartCleanup.
+	stopCleanup chan bool
+
+	// tokenLocks holds the per-token locks used when SerializeByToken is set.
+	tokenLocks tokenLocks
+
+	// userIndexMu serializes updates to the user session indexes (see
+	// RegisterUserSession).
+	userIndexMu sync.Mutex
+
 	// contextKey is the key used to set and retrieve the session data from a
 	// context.Context. It's automatically generated to ensure uniqueness.
 	contextKey contextKey
@@ -60,11 +255,13 @@ type SessionCookie struct {
 	// The default value is true, which means that the session cookie will not
 	// be destroyed when the user closes their browser and the appropriate
 	// 'Expires' and 'MaxAge' values will be added to the session cookie.
+	// Session.RememberMe overrides this setting for an individual session.
 	Persist bool `json:"persist"`
 
 	// SameSite controls the value of the 'SameSite' attribute on the session
 	// cookie. By default this is set to 'SameSite=Lax'. If you want no SameSite
-	// attribute or value in the session cookie then you should set this to 0.
+	// attribute or value in the session cookie then you should set this to 0
+	// (http.SameSiteDefaultMode is treated the same way).
 	SameSite http.SameSite `json:"sameSite"`
 
 	// Secure sets the 'Secure' attribute on the session cookie. The default
@@ -72,16 +269,159 @@ type SessionCookie struct {
 	// requests over HTTPS in production environments.
 	// See https://github.com/OWASP/CheatSheetSeries/blob/master/cheatsheets/Session_Management_Cheat_Sheet.md#transport-layer-security.
 	Secure bool `json:"secure"`
+
+	// HostPrefix sets whether the '__Host-' prefix is added to the session
+	// cookie name. Browsers only accept such cookies when they are Secure, have
+	// a Path of "/" and have no Domain, which stops them being set or
+	// overwritten by other subdomains. LoadCheck and SaveCheck return an error
+	// if Secure, Path or Domain do not meet these requirements. The default
+	// value is false.
+	HostPrefix bool `json:"hostPrefix"`
+
+	// Partitioned sets the 'Partitioned' attribute on the session cookie, so
+	// that browsers supporting CHIPS store it separately for each top-level
+	// site it is embedded in. Browsers ignore the attribute unless the cookie
+	// is also Secure with 'SameSite=None', so LoadCheck and SaveCheck return
+	// an error if Secure or SameSite do not meet these requirements. The
+	// attribute needs Go 1.23 or newer; with older versions Partitioned is
+	// always rejected. The default value is false.
+	Partitioned bool `json:"partitioned"`
+}
+
+// ValidateName returns an error if Name is not a valid cookie name as per
+// RFC6265: it must not be empty, and must only contain printable ASCII
+// characters other than whitespace and the separators ()<>@,;:\"/[]?={}.
+// Browsers reject cookies with invalid names, so this is best checked at
+// startup; EchoSessionSCS.Initialize calls it.
+func (sc SessionCookie) ValidateName() error {
+	if sc.Name == "" {
+		return errors.New("scs: cookie name must not be empty")
+	}
+	for _, r := range sc.Name {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune(`()<>@,;:\"/[]?={}`, r) {
+			return fmt.Errorf("scs: cookie name %q must not contain %q", sc.Name, r)
+		}
+	}
+	return nil
+}
+
+// TokenEncoding is the encoding of the random bytes in a session token.
+type TokenEncoding int
+
+const (
+	// Base64URL encodes tokens with unpadded URL-safe base64, giving 43
+	// characters for the default TokenLength.
+	Base64URL TokenEncoding = iota
+
+	// Hex encodes tokens as lowercase hexadecimal, giving 64 characters for
+	// the default TokenLength. This suits systems which only accept
+	// [0-9a-f] tokens.
+	Hex
+)
+
+// Validate returns an error if e is not a known TokenEncoding.
+func (e TokenEncoding) Validate() error {
+	switch e {
+	case Base64URL, Hex:
+		return nil
+	}
+	return fmt.Errorf("scs: unknown TokenEncoding %d", int(e))
+}
+
+// defaultMaxCookieSize is the default value of Session.MaxCookieSize.
+const defaultMaxCookieSize = 4096
+
+// ErrCookieTooLarge is returned by WriteSessionCookie when the serialized
+// session cookie is longer than Session.MaxCookieSize.
+var ErrCookieTooLarge = errors.New("scs: session cookie exceeds the maximum size")
+
+// ErrSessionTooLarge is returned by Commit when the encoded session data is
+// longer than Session.MaxEncodedSize. The session data is not written to the
+// store.
+var ErrSessionTooLarge = errors.New("scs: session data exceeds the maximum size")
+
+// DefaultCacheControl is the default value of Session.CacheControl.
+const DefaultCacheControl = `no-cache="Set-Cookie"`
+
+// hostPrefix is the cookie name prefix used when SessionCookie.HostPrefix is
+// set.
+const hostPrefix = "__Host-"
+
+// cookieName returns the name of the session cookie, adding the '__Host-'
+// prefix if HostPrefix is set. An error is returned if the cookie settings
+// are not valid for a prefixed or partitioned cookie.
+func (s *Session) cookieName() (string, error) {
+	return s.Cookie.prefixedName()
+}
+
+func (sc SessionCookie) prefixedName() (string, error) {
+	if err := sc.checkPartitioned(); err != nil {
+		return "", err
+	}
+	if !sc.HostPrefix {
+		return sc.Name, nil
+	}
+	if !sc.Secure {
+		return "", fmt.Errorf("scs: cookie %q with HostPrefix must be Secure", sc.Name)
+	}
+	if sc.Path != "/" {
+		return "", fmt.Errorf("scs: cookie %q with HostPrefix must have a Path of \"/\" but has %q", sc.Name, sc.Path)
+	}
+	if sc.Domain != "" {
+		return "", fmt.Errorf("scs: cookie %q with HostPrefix must not have a Domain but has %q", sc.Name, sc.Domain)
+	}
+	return hostPrefix + sc.Name, nil
+}
+
+// OverrideCookie sets the cookie settings used by WriteSessionCookie for the
+// current request only, in place of s.Cookie. This is useful when a single
+// session manager serves several domains, for example. If the Name of cookie
+// is empty the name from s.Cookie is used; note that the session is always
+// read from the cookie named by s.Cookie. An error is returned, and the
+// override is not set, if the settings are not valid.
+func (s *Session) OverrideCookie(c SessionContext, cookie SessionCookie) error {
+	if cookie.Name == "" {
+		cookie.Name = s.Cookie.Name
+	}
+	if err := cookie.ValidateName(); err != nil {
+		return err
+	}
+	if _, err := cookie.prefixedName(); err != nil {
+		return err
+	}
+	c.Set(s.cookieOverrideKey(), &cookie)
+	return nil
+}
+
+// requestCookie returns the cookie settings for the current request: the
+// override set by OverrideCookie if there is one, or s.Cookie otherwise.
+func (s *Session) requestCookie(c SessionContext) SessionCookie {
+	if sc, ok := c.Get(s.cookieOverrideKey()).(*SessionCookie); ok && sc != nil {
+		return *sc
+	}
+	return s.Cookie
+}
+
+func (s *Session) cookieOverrideKey() string {
+	return string(s.contextKey) + ".cookie"
 }
 
 // NewSession returns a new session manager with the default options. It is
 // safe for concurrent use.
 func NewSession() *Session {
 	s := &Session{
-		IdleTimeout: 0,
-		Lifetime:    24 * time.Hour,
-		Store:       memstore.New(),
-		contextKey:  generateContextKey(),
+		IdleTimeout:        0,
+		Lifetime:           24 * time.Hour,
+		Store:              memstore.New(),
+		Codec:              GobCodec{},
+		Metrics:            NoopMetrics{},
+		Logger:             NoopLogger{},
+		Clock:              SystemClock{},
+		TokenLength:        defaultTokenLength,
+		MaxCookieSize:      defaultMaxCookieSize,
+		ManageCacheHeaders: true,
+		CacheControl:       DefaultCacheControl,
+		contextKey:         generateContextKey(),
 		Cookie: SessionCookie{
 			Name:     "session",
 			Domain:   "",
@@ -95,20 +435,168 @@ func NewSession() *Session {
 	return s
 }
 
+// Count returns the number of active sessions in the session store. It
+// returns ErrNotSupported if the store does not implement CountableStore.
+func (s *Session) Count() (int, error) {
+	cs, ok := s.Store.(CountableStore)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	return cs.Len()
+}
+
+// Ping checks that the session store is reachable, for example in a readiness
+// probe. It returns the error from the store if it implements Pingable, and
+// nil otherwise, as such a store (e.g. memstore) has no backend to lose.
+func (s *Session) Ping(ctx context.Context) error {
+	ps, ok := s.Store.(Pingable)
+	if !ok {
+		return nil
+	}
+	return ps.Ping(ctx)
+}
+
+// StoredExpiry returns the expiry time of the session data for token in the
+// session store, for example to show in an admin listing of sessions. If the
+// store implements ExpiryStore the expiry it reports is returned. Otherwise
+// the session data is decoded and its absolute deadline is returned, which
+// may be later than the store expiry when an idle timeout is used. The found
+// return value is false if the token is not in the store.
+func (s *Session) StoredExpiry(token string) (expiry time.Time, found bool, err error) {
+	if es, ok := s.Store.(ExpiryStore); ok {
+		_, expiry, found, err = es.FindWithExpiry(token)
+		return expiry, found, err
+	}
+
+	b, found, err := s.Store.Find(token)
+	if err != nil || !found {
+		return time.Time{}, false, err
+	}
+	b, found = s.stripDataHeader(b)
+	if !found {
+		return time.Time{}, false, nil
+	}
+	deadline, _, err := s.Codec.Decode(b)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return deadline, true, nil
+}
+
+// StartCleanup starts a background goroutine which calls DeleteExpired on the
+// session store every interval, for stores which do not expire sessions
+// automatically. Any cleanup goroutine already started is stopped first.
+// Errors are passed to the session Logger. It returns ErrNotSupported if the
+// store does not implement GarbageCollectable.
+func (s *Session) StartCleanup(interval time.Duration) error {
+	gc, ok := s.Store.(GarbageCollectable)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	s.StopCleanup()
+	stop := make(chan bool)
+	s.stopCleanup = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := gc.DeleteExpired(); err != nil {
+					s.logger().Error("scs: expired sessions could not be deleted", "error", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// StopCleanup terminates the goroutine started by StartCleanup. It is a
+// no-op if no cleanup goroutine is running.
+func (s *Session) StopCleanup() {
+	if s.stopCleanup != nil {
+		s.stopCleanup <- true
+		s.stopCleanup = nil
+	}
+}
+
+// SignCookie makes WriteSessionCookie append an HMAC-SHA256 signature of the
+// token to the session cookie value (as "<token>.<signature>") using secret.
+// LoadCheck verifies and strips the signature, so forged or truncated cookies
+// are rejected without a store lookup; a request whose cookie has a missing
+// or invalid signature is treated as having no session. Enabling this on an
+// existing deployment resets sessions with unsigned cookies.
+//
+// Cookies signed with any of oldSecrets are also accepted, while new cookies
+// are always signed with secret. This allows the secret to be rotated without
+// resetting every session: pass the previous secret in oldSecrets until the
+// cookies signed with it have expired.
+func (s *Session) SignCookie(secret []byte, oldSecrets ...[]byte) {
+	s.cookieSecrets = append([][]byte{secret}, oldSecrets...)
+}
+
+// signToken returns the session cookie value for token.
+func (s *Session) signToken(token string) string {
+	if len(s.cookieSecrets) == 0 || token == "" {
+		return token
+	}
+	return token + "." + base64.RawURLEncoding.EncodeToString(tokenSignature(s.cookieSecrets[0], token))
+}
+
+// verifyToken returns the token from a session cookie value, or an empty
+// string if the cookie should be signed and the signature is not valid.
+func (s *Session) verifyToken(value string) string {
+	if len(s.cookieSecrets) == 0 {
+		return value
+	}
+	i := strings.LastIndexByte(value, '.')
+	if i < 0 {
+		return ""
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(value[i+1:])
+	if err != nil {
+		return ""
+	}
+	for _, secret := range s.cookieSecrets {
+		if hmac.Equal(sig, tokenSignature(secret, value[:i])) {
+			return value[:i]
+		}
+	}
+	return ""
+}
+
+func tokenSignature(secret []byte, token string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(token))
+	return h.Sum(nil)
+}
+
 // LoadCheck automatically loads session data for the current `echo` request
 // from the client cookie. Call this within middleware or your handlers to
 // initialize a new session.
 // Override this function to implement non-cookie sessions (eg "X-SESSION")
 func (s *Session) LoadCheck(c SessionContext) error {
+	name, err := s.cookieName()
+	if err != nil {
+		return err
+	}
+
 	var token string
-	cookie, err := c.Cookie(s.Cookie.Name)
+	cookie, err := c.Cookie(name)
 	if err == nil {
-		token = cookie.Value
+		token = s.verifyToken(cookie.Value)
+	} else if s.TokenQueryParam != "" {
+		if v := QueryParam(c, s.TokenQueryParam); v != "" {
+			token = s.verifyToken(v)
+		}
 	}
 
-	_, err = s.Load(c, token)
-	if err != nil {
-		return fmt.Errorf("func s.Load failed in Session.LoadFromMiddleware; %v", err)
+	if err := s.LoadToken(c, token); err != nil {
+		return fmt.Errorf("func s.LoadToken failed in Session.LoadFromMiddleware; %v", err)
 	}
 
 	// Always require a token.
@@ -120,14 +608,82 @@ func (s *Session) LoadCheck(c SessionContext) error {
 	return nil
 }
 
-// SaveCheck automatically saves the current echo-scs session if the session state
-// is Status or Destroyed  and communicates the session token to
-// the client in a cookie. Call this function after putting data in order to
-// save the session in storage. Place in middleware and call it prior to
-// specialized echo functions that may commit header changes before SaveCheck
-// writes to the header.
+// LoadToken loads the session data for token, as LoadCheck does once it has
+// read the token from the request: when SerializeByToken is set the lock on
+// the token is taken first, and then the session binding is verified and the
+// token rotated if due. It is for implementations of LoadCheck which read the
+// token from somewhere other than the session cookie, such as a header. If an
+// error is returned, the lock on the token has been released again.
+func (s *Session) LoadToken(c SessionContext, token string) error {
+	// The lock is taken before the session data is loaded, so that the data
+	// committed by the previous request with the token is seen.
+	var unlock func()
+	if _, loaded := s.lookupSessionData(c); s.SerializeByToken && token != "" && !loaded {
+		unlock = s.tokenLocks.lock(token)
+	}
+
+	sd, err := s.Load(c, token)
+	if err != nil {
+		if unlock != nil {
+			unlock()
+		}
+		return fmt.Errorf("func s.Load failed; %v", err)
+	}
+	if unlock != nil {
+		sd.mu.Lock()
+		sd.unlock = unlock
+		sd.mu.Unlock()
+	}
+
+	err = s.VerifyBinding(c)
+	if err != nil {
+		s.Release(c)
+		return fmt.Errorf("func s.VerifyBinding failed; %v", err)
+	}
+
+	err = s.RotateIfDue(c)
+	if err != nil {
+		s.Release(c)
+		return fmt.Errorf("func s.RotateIfDue failed; %v", err)
+	}
+	return nil
+}
+
+// Release releases the lock on the session token taken by LoadCheck when
+// SerializeByToken is set, allowing the next request with the token to load
+// the session. Call it once the session has been saved; the Sessions
+// middleware does this after the handler returns. It is a no-op if no lock
+// is held, so it is safe to call more than once.
+func (s *Session) Release(c SessionContext) {
+	sd, ok := s.lookupSessionData(c)
+	if !ok {
+		return
+	}
+
+	sd.mu.Lock()
+	unlock := sd.unlock
+	sd.unlock = nil
+	sd.mu.Unlock()
+
+	if unlock != nil {
+		unlock()
+	}
+}
+
+// SaveCheck automatically saves the current echo-scs session if the session
+// state is Status or Destroyed and communicates the session token to the
+// client in a cookie. When an idle timeout is being used, an Unmodified
+// session with a token is refreshed with Touch so its expiry slides forward
+// without re-encoding the session data (see also RefreshInterval). Call this
+// function after putting data in order to save the session in storage. Place
+// in middleware and call it prior to specialized echo functions that may
+// commit header changes before SaveCheck writes to the header.
 // Override this function to implement non-cookie sessions (eg "X-SESSION")
 func (s *Session) SaveCheck(c SessionContext) error {
+	if s.Status(c) == Unmodified && s.NeedsRefresh(c) {
+		s.Touch(c)
+	}
+
 	switch s.Status(c) {
 	case Modified:
 		token, expiry, err := s.Commit(c)
@@ -136,9 +692,9 @@ func (s *Session) SaveCheck(c SessionContext) error {
 			// http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return err
 		}
-		s.WriteSessionCookie(c, token, expiry)
+		return s.WriteSessionCookie(c, token, expiry)
 	case Destroyed:
-		s.WriteSessionCookie(c, "", time.Time{})
+		return s.WriteSessionCookie(c, "", time.Time{})
 	}
 	return nil
 }
@@ -147,37 +703,97 @@ func (s *Session) SaveCheck(c SessionContext) error {
 // In echo, this must be written before a echo.Redirect.
 // It is a public function in case the developer wants override
 // this functionality or access from an overridden SaveFromMiddleware.
-func (s *Session) WriteSessionCookie(c SessionContext, token string, expiry time.Time) {
+// Cache-Control and Vary headers are added too, unless ManageCacheHeaders is
+// false.
+// The settings set by OverrideCookie are used in place of s.Cookie if there
+// are any. An error is returned if the cookie settings are not valid (see
+// SessionCookie.HostPrefix and SessionCookie.Partitioned), ErrCookieTooLarge
+// if the cookie would be longer than MaxCookieSize, or ErrNoResponseHeader if
+// c has no response header to write the cookie to.
+func (s *Session) WriteSessionCookie(c SessionContext, token string, expiry time.Time) error {
+	sc := s.requestCookie(c)
+	name, err := sc.prefixedName()
+	if err != nil {
+		return err
+	}
+
 	cookie := &http.Cookie{
-		Name:     s.Cookie.Name,
-		Value:    token,
-		Path:     s.Cookie.Path,
-		Domain:   s.Cookie.Domain,
-		Secure:   s.Cookie.Secure,
-		HttpOnly: s.Cookie.HttpOnly,
-		SameSite: s.Cookie.SameSite,
+		Name:     name,
+		Value:    s.signToken(token),
+		Path:     sc.Path,
+		Domain:   sc.Domain,
+		Secure:   sc.Secure,
+		HttpOnly: sc.HttpOnly,
+	}
+	setPartitioned(cookie, sc.Partitioned)
+
+	// A SameSite value of 0 means the attribute should be omitted entirely.
+	// SameSiteDefaultMode is treated the same way, because older Go versions
+	// render it as a bare 'SameSite' attribute.
+	if sc.SameSite != 0 && sc.SameSite != http.SameSiteDefaultMode {
+		cookie.SameSite = sc.SameSite
 	}
 
 	if expiry.IsZero() {
 		cookie.Expires = time.Unix(1, 0)
 		cookie.MaxAge = -1
-	} else if s.Cookie.Persist {
-		cookie.Expires = time.Unix(expiry.Unix()+1, 0)        // Round up to the nearest second.
-		cookie.MaxAge = int(time.Until(expiry).Seconds() + 1) // Round up to the nearest second.
+	} else if s.persistCookie(c) {
+		cookie.Expires = time.Unix(expiry.Unix()+1, 0)                 // Round up to the nearest second.
+		cookie.MaxAge = int(expiry.Sub(s.clock().Now()).Seconds() + 1) // Round up to the nearest second.
+	}
+
+	v := cookie.String()
+	if s.MaxCookieSize > 0 && len(v) > s.MaxCookieSize {
+		return ErrCookieTooLarge
 	}
 
-	// https://blog.fortrabbit.com/mastering-http-caching
-	c.Response().Header().Add("Set-Cookie", cookie.String())
-	AddHeaderIfMissing(c, "Cache-Control", `no-cache="Set-Cookie"`)
-	AddHeaderIfMissing(c, "Vary", "Cookie")
+	header, err := ResponseHeader(c)
+	if err != nil {
+		return err
+	}
+	header.Add("Set-Cookie", v)
+	if s.ManageCacheHeaders {
+		// https://blog.fortrabbit.com/mastering-http-caching
+		cacheControl := s.CacheControl
+		if cacheControl == "" {
+			cacheControl = DefaultCacheControl
+		}
+		addHeaderIfMissing(header, "Cache-Control", cacheControl)
+		addHeaderIfMissing(header, "Vary", "Cookie")
+	}
+	return nil
+}
+
+// QueryParam returns the value of the named query parameter of the request in
+// c, such as an echo.Context, or "" if there is no such parameter or request.
+func QueryParam(c SessionContext, name string) string {
+	switch rc := c.(type) {
+	case interface{ QueryParam(name string) string }:
+		return rc.QueryParam(name)
+	case interface{ Request() *http.Request }:
+		if r := rc.Request(); r != nil {
+			return r.URL.Query().Get(name)
+		}
+	}
+	return ""
 }
 
 // Add if the key/value pair is not found in the response header.
-func AddHeaderIfMissing(c SessionContext, key, value string) {
-	for _, h := range c.Response().Header()[key] {
+// ErrNoResponseHeader is returned if c has no response header.
+func AddHeaderIfMissing(c SessionContext, key, value string) error {
+	header, err := ResponseHeader(c)
+	if err != nil {
+		return err
+	}
+	addHeaderIfMissing(header, key, value)
+	return nil
+}
+
+func addHeaderIfMissing(header HeaderWriter, key, value string) {
+	for _, h := range header.Values(key) {
 		if h == value {
 			return
 		}
 	}
-	c.Response().Header().Add(key, value)
+	header.Add(key, value)
 }
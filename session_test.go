@@ -1,20 +1,59 @@
 package scs
 
 import (
+	"context"
+	"encoding/gob"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/aberlorn/scs/v2/memstore"
+	"github.com/labstack/echo/v4"
 )
 
 type testServer struct {
 	*httptest.Server
 }
 
+func newTestContext() echo.Context {
+	e := echo.New()
+	req := httptest.NewRequest(echo.GET, "/", nil)
+	rec := httptest.NewRecorder()
+	return e.NewContext(req, rec)
+}
+
+// loadAndSave mirrors the middleware package: the session is loaded before the
+// handler runs and saved just before the response header is written.
+func loadAndSave(s *Session) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if err := s.LoadCheck(c); err != nil {
+				return err
+			}
+
+			var saveErr error
+			c.Response().Before(func() {
+				saveErr = s.SaveCheck(c)
+			})
+
+			if err := next(c); err != nil {
+				return err
+			}
+			if !c.Response().Committed {
+				return s.SaveCheck(c)
+			}
+			return saveErr
+		}
+	}
+}
+
 func newTestServer(t *testing.T, h http.Handler) *testServer {
 	ts := httptest.NewTLSServer(h)
 
@@ -54,16 +93,18 @@ func extractTokenFromCookie(c string) string {
 func TestEnable(t *testing.T) {
 	session := NewSession()
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/put", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		session.Put(r.Context(), "foo", "bar")
-	}))
-	mux.HandleFunc("/get", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		s := session.Get(r.Context(), "foo").(string)
-		w.Write([]byte(s))
-	}))
+	e := echo.New()
+	e.Use(loadAndSave(session))
+	e.GET("/put", func(c echo.Context) error {
+		session.Put(c, "foo", "bar")
+		return nil
+	})
+	e.GET("/get", func(c echo.Context) error {
+		s := session.Get(c, "foo").(string)
+		return c.String(http.StatusOK, s)
+	})
 
-	ts := newTestServer(t, session.LoadAndSave(mux))
+	ts := newTestServer(t, e)
 	defer ts.Close()
 
 	header, _ := ts.execute(t, "/put")
@@ -84,24 +125,50 @@ func TestEnable(t *testing.T) {
 	}
 }
 
+func TestIsNew(t *testing.T) {
+	session := NewSession()
+
+	e := echo.New()
+	e.Use(loadAndSave(session))
+	e.GET("/", func(c echo.Context) error {
+		isNew := session.IsNew(c)
+		session.Put(c, "visited", true)
+		return c.String(http.StatusOK, fmt.Sprint(isNew))
+	})
+
+	ts := newTestServer(t, e)
+	defer ts.Close()
+
+	_, body := ts.execute(t, "/")
+	if body != "true" {
+		t.Errorf("got %q: expected %q", body, "true")
+	}
+
+	_, body = ts.execute(t, "/")
+	if body != "false" {
+		t.Errorf("got %q: expected %q", body, "false")
+	}
+}
+
 func TestLifetime(t *testing.T) {
 	session := NewSession()
 	session.Lifetime = 500 * time.Millisecond
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/put", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		session.Put(r.Context(), "foo", "bar")
-	}))
-	mux.HandleFunc("/get", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		v := session.Get(r.Context(), "foo")
+	e := echo.New()
+	e.Use(loadAndSave(session))
+	e.GET("/put", func(c echo.Context) error {
+		session.Put(c, "foo", "bar")
+		return nil
+	})
+	e.GET("/get", func(c echo.Context) error {
+		v := session.Get(c, "foo")
 		if v == nil {
-			http.Error(w, "foo does not exist in session", 500)
-			return
+			return c.String(http.StatusInternalServerError, "foo does not exist in session\n")
 		}
-		w.Write([]byte(v.(string)))
-	}))
+		return c.String(http.StatusOK, v.(string))
+	})
 
-	ts := newTestServer(t, session.LoadAndSave(mux))
+	ts := newTestServer(t, e)
 	defer ts.Close()
 
 	ts.execute(t, "/put")
@@ -123,20 +190,21 @@ func TestIdleTimeout(t *testing.T) {
 	session.IdleTimeout = 200 * time.Millisecond
 	session.Lifetime = time.Second
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/put", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		session.Put(r.Context(), "foo", "bar")
-	}))
-	mux.HandleFunc("/get", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		v := session.Get(r.Context(), "foo")
+	e := echo.New()
+	e.Use(loadAndSave(session))
+	e.GET("/put", func(c echo.Context) error {
+		session.Put(c, "foo", "bar")
+		return nil
+	})
+	e.GET("/get", func(c echo.Context) error {
+		v := session.Get(c, "foo")
 		if v == nil {
-			http.Error(w, "foo does not exist in session", 500)
-			return
+			return c.String(http.StatusInternalServerError, "foo does not exist in session\n")
 		}
-		w.Write([]byte(v.(string)))
-	}))
+		return c.String(http.StatusOK, v.(string))
+	})
 
-	ts := newTestServer(t, session.LoadAndSave(mux))
+	ts := newTestServer(t, e)
 	defer ts.Close()
 
 	ts.execute(t, "/put")
@@ -157,30 +225,141 @@ func TestIdleTimeout(t *testing.T) {
 	}
 }
 
+func TestExpiryTime(t *testing.T) {
+	tests := map[string]func(*Session){
+		"deadline":     func(s *Session) {},
+		"idle timeout": func(s *Session) { s.IdleTimeout = 10 * time.Minute },
+	}
+
+	for name, configure := range tests {
+		session := NewSession()
+		configure(session)
+
+		c := newTestContext()
+		if err := session.LoadCheck(c); err != nil {
+			t.Fatal(err)
+		}
+		session.Put(c, "foo", "bar")
+
+		expiry := session.ExpiryTime(c)
+		if session.Status(c) != Modified {
+			t.Errorf("%s: got %v: expected %v", name, session.Status(c), Modified)
+		}
+		if session.Token(c) != "" {
+			t.Errorf("%s: got %q: expected the session not to be committed", name, session.Token(c))
+		}
+
+		if err := session.SaveCheck(c); err != nil {
+			t.Fatal(err)
+		}
+		cookies := (&http.Response{Header: c.Response().Header()}).Cookies()
+		if len(cookies) != 1 {
+			t.Fatalf("%s: got %v: expected a session cookie", name, cookies)
+		}
+		// The cookie expiry is rounded up to the next second.
+		want := time.Unix(expiry.Unix()+1, 0)
+		if d := cookies[0].Expires.Sub(want); d < 0 || d > time.Second {
+			t.Errorf("%s: got %v: expected %v", name, cookies[0].Expires, want)
+		}
+	}
+}
+
+type pingStore struct {
+	Store
+	err error
+}
+
+func (p *pingStore) Ping(ctx context.Context) error {
+	return p.err
+}
+
+func TestPing(t *testing.T) {
+	session := NewSession()
+	if err := session.Ping(context.Background()); err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+
+	store := &pingStore{Store: session.Store}
+	session.Store = store
+	if err := session.Ping(context.Background()); err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+
+	store.err = errors.New("connection refused")
+	if err := session.Ping(context.Background()); err != store.err {
+		t.Fatalf("got %v: expected %v", err, store.err)
+	}
+}
+
+func TestStoredExpiry(t *testing.T) {
+	session := NewSession()
+	session.IdleTimeout = 10 * time.Minute
+
+	c := newTestContext()
+	sd, err := session.Load(c, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Put(c, "foo", "bar")
+	token, expiry, err := session.Commit(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// memstore reports the expiry the session was committed with.
+	got, found, err := session.StoredExpiry(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || !got.Equal(expiry) {
+		t.Errorf("got %v, %v: expected %v, %v", got, found, expiry, true)
+	}
+
+	// Other stores fall back to the deadline in the session data.
+	deadline := sd.Deadline
+	session.Store = struct{ Store }{session.Store}
+	got, found, err = session.StoredExpiry(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || !got.Equal(deadline) {
+		t.Errorf("got %v, %v: expected %v, %v", got, found, deadline, true)
+	}
+
+	_, found, err = session.StoredExpiry("missing_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found {
+		t.Errorf("got %v: expected %v", found, false)
+	}
+}
+
 func TestDestroy(t *testing.T) {
 	session := NewSession()
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/put", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		session.Put(r.Context(), "foo", "bar")
-	}))
-	mux.HandleFunc("/destroy", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		err := session.Destroy(r.Context())
+	e := echo.New()
+	e.Use(loadAndSave(session))
+	e.GET("/put", func(c echo.Context) error {
+		session.Put(c, "foo", "bar")
+		return nil
+	})
+	e.GET("/destroy", func(c echo.Context) error {
+		err := session.Destroy(c)
 		if err != nil {
-			http.Error(w, err.Error(), 500)
-			return
+			return c.String(http.StatusInternalServerError, err.Error())
 		}
-	}))
-	mux.HandleFunc("/get", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		v := session.Get(r.Context(), "foo")
+		return nil
+	})
+	e.GET("/get", func(c echo.Context) error {
+		v := session.Get(c, "foo")
 		if v == nil {
-			http.Error(w, "foo does not exist in session", 500)
-			return
+			return c.String(http.StatusInternalServerError, "foo does not exist in session\n")
 		}
-		w.Write([]byte(v.(string)))
-	}))
+		return c.String(http.StatusOK, v.(string))
+	})
 
-	ts := newTestServer(t, session.LoadAndSave(mux))
+	ts := newTestServer(t, e)
 	defer ts.Close()
 
 	ts.execute(t, "/put")
@@ -202,3 +381,1092 @@ func TestDestroy(t *testing.T) {
 		t.Errorf("want %q; got %q", "foo does not exist in session\n", body)
 	}
 }
+
+func TestSameSiteOmitted(t *testing.T) {
+	for _, sameSite := range []http.SameSite{0, http.SameSiteDefaultMode} {
+		session := NewSession()
+		session.Cookie.SameSite = sameSite
+
+		c := newTestContext()
+		session.WriteSessionCookie(c, "token", time.Now().Add(time.Hour))
+
+		cookie := c.Response().Header().Get("Set-Cookie")
+		if strings.Contains(cookie, "SameSite") {
+			t.Errorf("got %q: expected no SameSite attribute", cookie)
+		}
+	}
+
+	session := NewSession()
+	c := newTestContext()
+	session.WriteSessionCookie(c, "token", time.Now().Add(time.Hour))
+
+	cookie := c.Response().Header().Get("Set-Cookie")
+	if !strings.Contains(cookie, "SameSite=Lax") {
+		t.Errorf("got %q: expected to contain %q", cookie, "SameSite=Lax")
+	}
+}
+
+func TestManageCacheHeaders(t *testing.T) {
+	session := NewSession()
+
+	c := newTestContext()
+	if err := session.WriteSessionCookie(c, "token", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	header := c.Response().Header()
+	if got := header.Get("Cache-Control"); got != `no-cache="Set-Cookie"` {
+		t.Errorf("got %q: expected %q", got, `no-cache="Set-Cookie"`)
+	}
+	if got := header.Get("Vary"); got != "Cookie" {
+		t.Errorf("got %q: expected %q", got, "Cookie")
+	}
+
+	session.CacheControl = "private"
+	c = newTestContext()
+	if err := session.WriteSessionCookie(c, "token", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Response().Header().Get("Cache-Control"); got != "private" {
+		t.Errorf("got %q: expected %q", got, "private")
+	}
+
+	session.ManageCacheHeaders = false
+	c = newTestContext()
+	if err := session.WriteSessionCookie(c, "token", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	header = c.Response().Header()
+	if header.Get("Set-Cookie") == "" {
+		t.Errorf("got %q: expected a session cookie", header.Get("Set-Cookie"))
+	}
+	for _, key := range []string{"Cache-Control", "Vary"} {
+		if got, ok := header[key]; ok {
+			t.Errorf("got %s %q: expected no header", key, got)
+		}
+	}
+}
+
+func TestHostPrefix(t *testing.T) {
+	session := NewSession()
+	session.Cookie.HostPrefix = true
+	session.Cookie.Secure = true
+
+	e := echo.New()
+	e.Use(loadAndSave(session))
+	e.GET("/put", func(c echo.Context) error {
+		session.Put(c, "foo", "bar")
+		return nil
+	})
+	e.GET("/get", func(c echo.Context) error {
+		return c.String(http.StatusOK, session.GetString(c, "foo"))
+	})
+
+	ts := newTestServer(t, e)
+	defer ts.Close()
+
+	header, _ := ts.execute(t, "/put")
+	cookie := header.Get("Set-Cookie")
+	if strings.HasPrefix(cookie, "__Host-session=") == false {
+		t.Fatalf("got %q: expected prefix %q", cookie, "__Host-session=")
+	}
+	if strings.Contains(cookie, "Domain=") {
+		t.Fatalf("got %q: expected no Domain attribute", cookie)
+	}
+
+	_, body := ts.execute(t, "/get")
+	if body != "bar" {
+		t.Errorf("want %q; got %q", "bar", body)
+	}
+}
+
+func TestHostPrefixValidation(t *testing.T) {
+	tests := map[string]func(*SessionCookie){
+		"insecure":    func(sc *SessionCookie) { sc.Secure = false },
+		"path":        func(sc *SessionCookie) { sc.Path = "/app" },
+		"empty path":  func(sc *SessionCookie) { sc.Path = "" },
+		"with domain": func(sc *SessionCookie) { sc.Domain = "example.com" },
+	}
+
+	for name, modify := range tests {
+		session := NewSession()
+		session.Cookie.HostPrefix = true
+		session.Cookie.Secure = true
+		modify(&session.Cookie)
+
+		c := newTestContext()
+		if err := session.LoadCheck(c); err == nil {
+			t.Errorf("%s: got %v: expected an error from LoadCheck", name, err)
+		}
+		if err := session.WriteSessionCookie(c, "token", time.Now().Add(time.Hour)); err == nil {
+			t.Errorf("%s: got %v: expected an error from WriteSessionCookie", name, err)
+		}
+		if cookie := c.Response().Header().Get("Set-Cookie"); cookie != "" {
+			t.Errorf("%s: got %q: expected no cookie", name, cookie)
+		}
+	}
+}
+
+func TestOverrideCookie(t *testing.T) {
+	session := NewSession()
+	session.Cookie.Domain = "example.com"
+
+	c := newTestContext()
+	err := session.OverrideCookie(c, SessionCookie{Domain: "example.org", Path: "/", HttpOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.WriteSessionCookie(c, "token", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	cookie := c.Response().Header().Get("Set-Cookie")
+	if !strings.HasPrefix(cookie, "session=") || !strings.Contains(cookie, "Domain=example.org") {
+		t.Fatalf("got %q: expected the overridden Domain", cookie)
+	}
+
+	// The override applies to that request only.
+	c = newTestContext()
+	if err := session.WriteSessionCookie(c, "token", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	cookie = c.Response().Header().Get("Set-Cookie")
+	if !strings.Contains(cookie, "Domain=example.com") {
+		t.Fatalf("got %q: expected the global Domain", cookie)
+	}
+
+	// An invalid override is rejected and not set.
+	c = newTestContext()
+	err = session.OverrideCookie(c, SessionCookie{Domain: "example.org", Path: "/", HostPrefix: true, Secure: true})
+	if err == nil {
+		t.Fatalf("got %v: expected an error", err)
+	}
+	err = session.OverrideCookie(c, SessionCookie{Name: "bad name"})
+	if err == nil {
+		t.Fatalf("got %v: expected an error", err)
+	}
+	if err := session.WriteSessionCookie(c, "token", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	cookie = c.Response().Header().Get("Set-Cookie")
+	if !strings.Contains(cookie, "Domain=example.com") {
+		t.Fatalf("got %q: expected the global Domain", cookie)
+	}
+}
+
+func TestTokenQueryParam(t *testing.T) {
+	session := NewSession()
+
+	c := newTestContext()
+	if err := session.LoadCheck(c); err != nil {
+		t.Fatal(err)
+	}
+	session.Put(c, "foo", "bar")
+	token, _, err := session.Commit(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newQueryContext := func() echo.Context {
+		req := httptest.NewRequest(echo.GET, "/ws?sid="+token, nil)
+		return echo.New().NewContext(req, httptest.NewRecorder())
+	}
+
+	// The query parameter is ignored unless TokenQueryParam is set.
+	c = newQueryContext()
+	if err := session.LoadCheck(c); err != nil {
+		t.Fatal(err)
+	}
+	if session.Token(c) != "" {
+		t.Fatalf("got %q: expected %q", session.Token(c), "")
+	}
+
+	session.TokenQueryParam = "sid"
+	c = newQueryContext()
+	if err := session.LoadCheck(c); err != nil {
+		t.Fatal(err)
+	}
+	if session.GetString(c, "foo") != "bar" {
+		t.Fatalf("got %q: expected %q", session.GetString(c, "foo"), "bar")
+	}
+
+	// A session cookie takes precedence over the query parameter.
+	c = newQueryContext()
+	c.Request().AddCookie(&http.Cookie{Name: session.Cookie.Name, Value: "other_token"})
+	if err := session.LoadCheck(c); err != nil {
+		t.Fatal(err)
+	}
+	if session.Token(c) != "" {
+		t.Fatalf("got %q: expected %q", session.Token(c), "")
+	}
+}
+
+func TestMaxCookieSize(t *testing.T) {
+	session := NewSession()
+	session.TokenGenerator = func() (string, error) {
+		return strings.Repeat("x", 5000), nil
+	}
+
+	c := newTestContext()
+	if err := session.LoadCheck(c); err != nil {
+		t.Fatal(err)
+	}
+	session.Put(c, "foo", "bar")
+	if err := session.SaveCheck(c); err != ErrCookieTooLarge {
+		t.Errorf("got %v: expected %v", err, ErrCookieTooLarge)
+	}
+	if cookie := c.Response().Header().Get("Set-Cookie"); cookie != "" {
+		t.Errorf("got %q: expected no cookie", cookie)
+	}
+
+	// A cookie within the limit is written.
+	if err := session.WriteSessionCookie(c, "token", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if cookie := c.Response().Header().Get("Set-Cookie"); !strings.HasPrefix(cookie, "session=token;") {
+		t.Errorf("got %q: expected a session cookie", cookie)
+	}
+
+	// The check can be disabled.
+	session.MaxCookieSize = 0
+	c = newTestContext()
+	if err := session.WriteSessionCookie(c, strings.Repeat("x", 5000), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if cookie := c.Response().Header().Get("Set-Cookie"); len(cookie) <= 5000 {
+		t.Errorf("got %d bytes: expected the cookie to be written", len(cookie))
+	}
+}
+
+func TestMaxEncodedSize(t *testing.T) {
+	session := NewSession()
+	session.MaxEncodedSize = 1024
+
+	c := newTestContext()
+	if err := session.LoadCheck(c); err != nil {
+		t.Fatal(err)
+	}
+	session.Put(c, "foo", strings.Repeat("x", 100))
+	size, err := session.EncodedSize(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size <= 100 || size > session.MaxEncodedSize {
+		t.Fatalf("got %d: expected between %d and %d", size, 100, session.MaxEncodedSize)
+	}
+	if _, _, err := session.Commit(c); err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+
+	c = newTestContext()
+	if err := session.LoadCheck(c); err != nil {
+		t.Fatal(err)
+	}
+	session.Put(c, "foo", strings.Repeat("x", 2000))
+	if size, _ := session.EncodedSize(c); size <= session.MaxEncodedSize {
+		t.Fatalf("got %d: expected more than %d", size, session.MaxEncodedSize)
+	}
+	if _, _, err := session.Commit(c); err != ErrSessionTooLarge {
+		t.Fatalf("got %v: expected %v", err, ErrSessionTooLarge)
+	}
+	n, err := session.Store.(CountableStore).Len()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("got %d: expected %d", n, 1)
+	}
+}
+
+func TestPopDeferred(t *testing.T) {
+	session := NewSession()
+
+	e := echo.New()
+	e.Use(loadAndSave(session))
+	e.GET("/put", func(c echo.Context) error {
+		session.Put(c, "foo", "bar")
+		return nil
+	})
+	e.GET("/pop", func(c echo.Context) error {
+		v1, _ := session.PopDeferred(c, "foo").(string)
+		v2 := session.GetString(c, "foo")
+		return c.String(http.StatusOK, v1+":"+v2)
+	})
+	e.GET("/get", func(c echo.Context) error {
+		v := session.Get(c, "foo")
+		if v == nil {
+			return c.String(http.StatusInternalServerError, "foo does not exist in session\n")
+		}
+		return c.String(http.StatusOK, v.(string))
+	})
+
+	ts := newTestServer(t, e)
+	defer ts.Close()
+
+	ts.execute(t, "/put")
+
+	_, body := ts.execute(t, "/pop")
+	if body != "bar:bar" {
+		t.Errorf("want %q; got %q", "bar:bar", body)
+	}
+
+	_, body = ts.execute(t, "/get")
+	if body != "foo does not exist in session\n" {
+		t.Errorf("want %q; got %q", "foo does not exist in session\n", body)
+	}
+}
+
+// headerContext is a SessionContext which is not backed by echo.
+type headerContext struct {
+	values  map[string]interface{}
+	cookies map[string]*http.Cookie
+	header  http.Header
+}
+
+func newHeaderContext() *headerContext {
+	return &headerContext{
+		values:  make(map[string]interface{}),
+		cookies: make(map[string]*http.Cookie),
+		header:  make(http.Header),
+	}
+}
+
+func (c *headerContext) Get(key string) interface{} {
+	return c.values[key]
+}
+
+func (c *headerContext) Set(key string, val interface{}) {
+	c.values[key] = val
+}
+
+func (c *headerContext) Cookie(name string) (*http.Cookie, error) {
+	cookie, ok := c.cookies[name]
+	if !ok {
+		return nil, http.ErrNoCookie
+	}
+	return cookie, nil
+}
+
+func (c *headerContext) HeaderWriter() HeaderWriter {
+	return httpHeader(c.header)
+}
+
+func TestHeaderWriterContext(t *testing.T) {
+	session := NewSession()
+
+	c := newHeaderContext()
+	if err := session.LoadCheck(c); err != nil {
+		t.Fatal(err)
+	}
+	session.Put(c, "foo", "bar")
+	if err := session.SaveCheck(c); err != nil {
+		t.Fatal(err)
+	}
+
+	cookie := c.header.Get("Set-Cookie")
+	if !strings.HasPrefix(cookie, "session=") {
+		t.Fatalf("got %q: expected prefix %q", cookie, "session=")
+	}
+	if c.header.Get("Vary") != "Cookie" {
+		t.Errorf("got %q: expected %q", c.header.Get("Vary"), "Cookie")
+	}
+
+	c2 := newHeaderContext()
+	c2.cookies["session"] = &http.Cookie{Name: "session", Value: extractTokenFromCookie(cookie)}
+	if err := session.LoadCheck(c2); err != nil {
+		t.Fatal(err)
+	}
+	if session.GetString(c2, "foo") != "bar" {
+		t.Errorf("got %q: expected %q", session.GetString(c2, "foo"), "bar")
+	}
+}
+
+// bareContext is a SessionContext with no response header.
+type bareContext struct {
+	values map[string]interface{}
+}
+
+func (c *bareContext) Get(key string) interface{} {
+	return c.values[key]
+}
+
+func (c *bareContext) Set(key string, val interface{}) {
+	c.values[key] = val
+}
+
+func (c *bareContext) Cookie(name string) (*http.Cookie, error) {
+	return nil, http.ErrNoCookie
+}
+
+func TestNoResponseHeader(t *testing.T) {
+	session := NewSession()
+
+	c := &bareContext{values: make(map[string]interface{})}
+	if err := session.LoadCheck(c); err != nil {
+		t.Fatal(err)
+	}
+	session.Put(c, "foo", "bar")
+	if err := session.SaveCheck(c); err != ErrNoResponseHeader {
+		t.Fatalf("got %v: expected %v", err, ErrNoResponseHeader)
+	}
+	if err := AddHeaderIfMissing(c, "Vary", "Cookie"); err != ErrNoResponseHeader {
+		t.Fatalf("got %v: expected %v", err, ErrNoResponseHeader)
+	}
+}
+
+func TestRememberMe(t *testing.T) {
+	session := NewSession()
+	session.Cookie.Persist = true
+
+	e := echo.New()
+	e.Use(loadAndSave(session))
+	e.GET("/login", func(c echo.Context) error {
+		session.RememberMe(c, c.QueryParam("remember") == "true")
+		return nil
+	})
+	e.GET("/put", func(c echo.Context) error {
+		session.Put(c, "foo", "bar")
+		return nil
+	})
+
+	ts := newTestServer(t, e)
+	defer ts.Close()
+
+	header, _ := ts.execute(t, "/login?remember=false")
+	cookie := header.Get("Set-Cookie")
+	if strings.Contains(cookie, "Max-Age") || strings.Contains(cookie, "Expires") {
+		t.Errorf("got %q: expected a session cookie", cookie)
+	}
+
+	// The flag is persisted in the store and applies to later responses.
+	header, _ = ts.execute(t, "/put")
+	cookie = header.Get("Set-Cookie")
+	if strings.Contains(cookie, "Max-Age") || strings.Contains(cookie, "Expires") {
+		t.Errorf("got %q: expected a session cookie", cookie)
+	}
+
+	header, _ = ts.execute(t, "/login?remember=true")
+	cookie = header.Get("Set-Cookie")
+	if !strings.Contains(cookie, "Max-Age") || !strings.Contains(cookie, "Expires") {
+		t.Errorf("got %q: expected a persistent cookie", cookie)
+	}
+}
+
+func TestRememberMeOverridesPersist(t *testing.T) {
+	session := NewSession()
+	session.Cookie.Persist = false
+
+	c := newTestContext()
+	if _, err := session.Load(c, ""); err != nil {
+		t.Fatal(err)
+	}
+	session.RememberMe(c, true)
+	session.WriteSessionCookie(c, "token", time.Now().Add(time.Hour))
+
+	cookie := c.Response().Header().Get("Set-Cookie")
+	if !strings.Contains(cookie, "Max-Age") {
+		t.Errorf("got %q: expected a persistent cookie", cookie)
+	}
+
+	if len(session.Keys(c)) != 0 {
+		t.Errorf("got %v: expected no keys", session.Keys(c))
+	}
+}
+
+// plainStore implements only the Store interface.
+type plainStore struct {
+	Store
+}
+
+func TestCount(t *testing.T) {
+	session := NewSession()
+
+	for i := 0; i < 3; i++ {
+		c := newTestContext()
+		if _, err := session.Load(c, ""); err != nil {
+			t.Fatal(err)
+		}
+		session.Put(c, "foo", "bar")
+		if _, _, err := session.Commit(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := session.Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("got %d: expected %d", n, 3)
+	}
+
+	session.Store = plainStore{session.Store}
+	_, err = session.Count()
+	if err != ErrNotSupported {
+		t.Errorf("got %v: expected %v", err, ErrNotSupported)
+	}
+}
+
+func TestStartCleanup(t *testing.T) {
+	store := memstore.NewWithCleanupInterval(0)
+	session := NewSession()
+	session.Store = store
+
+	for _, token := range []string{"expired_token_1", "expired_token_2"} {
+		if err := store.Commit(token, []byte("encoded_data"), time.Now().Add(-time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Commit("live_token", []byte("encoded_data"), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.StartCleanup(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	defer session.StopCleanup()
+
+	// The cleanup runs alongside live sessions.
+	for i := 0; i < 10; i++ {
+		c := newTestContext()
+		if _, err := session.Load(c, ""); err != nil {
+			t.Fatal(err)
+		}
+		session.Put(c, "foo", "bar")
+		if _, _, err := session.Commit(c); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	n, err := store.DeleteExpired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("got %d: expected %d", n, 0)
+	}
+	_, found, _ := store.Find("live_token")
+	if !found {
+		t.Errorf("got %v: expected %v", found, true)
+	}
+	n, err = store.Len()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 11 {
+		t.Errorf("got %d: expected %d", n, 11)
+	}
+
+	session.Store = plainStore{store}
+	if err = session.StartCleanup(time.Minute); err != ErrNotSupported {
+		t.Errorf("got %v: expected %v", err, ErrNotSupported)
+	}
+}
+
+// failingCleanupStore is a GarbageCollectable store whose DeleteExpired
+// always fails.
+type failingCleanupStore struct {
+	Store
+}
+
+func (failingCleanupStore) DeleteExpired() (int, error) {
+	return 0, errors.New("connection refused")
+}
+
+func TestStartCleanupLogsErrors(t *testing.T) {
+	session := NewSession()
+	session.Store = failingCleanupStore{session.Store}
+	cl := &capturingLogger{}
+	session.Logger = cl
+
+	if err := session.StartCleanup(5 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	session.StopCleanup()
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if len(cl.events) == 0 {
+		t.Fatalf("got %d: expected at least %d", len(cl.events), 1)
+	}
+	e := cl.events[0]
+	if e.level != "error" {
+		t.Errorf("got %q: expected %q", e.level, "error")
+	}
+	if got := fmt.Sprint(e.value("error")); got != "connection refused" {
+		t.Errorf("got %q: expected %q", got, "connection refused")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	gob.Register(map[string]int{})
+	session := NewSession()
+
+	e := echo.New()
+	e.Use(loadAndSave(session))
+	e.GET("/put", func(c echo.Context) error {
+		session.Put(c, c.QueryParam("key"), map[string]int{"a": 1, "b": 2, "c": 3})
+		return nil
+	})
+	e.GET("/fingerprint", func(c echo.Context) error {
+		fp, err := session.Fingerprint(c)
+		if err != nil {
+			return err
+		}
+		return c.String(http.StatusOK, fp)
+	})
+
+	ts := newTestServer(t, e)
+	defer ts.Close()
+
+	ts.execute(t, "/put?key=foo")
+
+	_, fp1 := ts.execute(t, "/fingerprint")
+	_, fp2 := ts.execute(t, "/fingerprint")
+	if fp1 != fp2 {
+		t.Errorf("want fingerprints to be the same; got %q and %q", fp1, fp2)
+	}
+
+	ts.execute(t, "/put?key=bar")
+	_, fp3 := ts.execute(t, "/fingerprint")
+	if fp3 == fp2 {
+		t.Errorf("want fingerprints to be different")
+	}
+}
+
+type fingerprintItem struct {
+	Name string
+}
+
+type fingerprintValue struct {
+	Created time.Time
+	Item    *fingerprintItem
+	Tags    map[string]*fingerprintItem
+}
+
+func TestFingerprintRoundTrip(t *testing.T) {
+	gob.Register(fingerprintValue{})
+	session := NewSession()
+
+	e := echo.New()
+	e.Use(loadAndSave(session))
+	e.GET("/put", func(c echo.Context) error {
+		session.Put(c, "foo", fingerprintValue{
+			Created: time.Now(),
+			Item:    &fingerprintItem{Name: "bar"},
+			Tags:    map[string]*fingerprintItem{"a": {Name: "baz"}, "b": {Name: "qux"}},
+		})
+		fp, err := session.Fingerprint(c)
+		if err != nil {
+			return err
+		}
+		return c.String(http.StatusOK, fp)
+	})
+	e.GET("/fingerprint", func(c echo.Context) error {
+		fp, err := session.Fingerprint(c)
+		if err != nil {
+			return err
+		}
+		return c.String(http.StatusOK, fp)
+	})
+
+	ts := newTestServer(t, e)
+	defer ts.Close()
+
+	// The pointers and the location of the time change when the value is
+	// decoded, but the fingerprint doesn't.
+	_, fp1 := ts.execute(t, "/put")
+	_, fp2 := ts.execute(t, "/fingerprint")
+	_, fp3 := ts.execute(t, "/fingerprint")
+	if fp1 != fp2 || fp2 != fp3 {
+		t.Errorf("want fingerprints to be the same; got %q, %q and %q", fp1, fp2, fp3)
+	}
+}
+
+func TestSignCookie(t *testing.T) {
+	session := NewSession()
+	session.SignCookie([]byte("secret"))
+
+	loadWithCookie := func(value string) echo.Context {
+		req := httptest.NewRequest(echo.GET, "/", nil)
+		req.AddCookie(&http.Cookie{Name: session.Cookie.Name, Value: value})
+		c := echo.New().NewContext(req, httptest.NewRecorder())
+		if err := session.LoadCheck(c); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	c := newTestContext()
+	if err := session.LoadCheck(c); err != nil {
+		t.Fatal(err)
+	}
+	session.Put(c, "foo", "bar")
+	if err := session.SaveCheck(c); err != nil {
+		t.Fatal(err)
+	}
+	value := extractTokenFromCookie(c.Response().Header().Get("Set-Cookie"))
+	token := session.Token(c)
+	if !strings.HasPrefix(value, token+".") {
+		t.Fatalf("got %q: expected a signed %q", value, token)
+	}
+
+	c = loadWithCookie(value)
+	if session.GetString(c, "foo") != "bar" {
+		t.Errorf("got %q: expected %q", session.GetString(c, "foo"), "bar")
+	}
+
+	tampered := []byte(value)
+	if tampered[len(tampered)-2] == 'A' {
+		tampered[len(tampered)-2] = 'B'
+	} else {
+		tampered[len(tampered)-2] = 'A'
+	}
+	for _, v := range []string{string(tampered), value[:len(value)-5], token} {
+		c = loadWithCookie(v)
+		if session.Token(c) != "" {
+			t.Errorf("got %q: expected no session for cookie %q", session.Token(c), v)
+		}
+		if session.Exists(c, "foo") {
+			t.Errorf("got %v: expected %v", session.Exists(c, "foo"), false)
+		}
+	}
+}
+
+func TestSignCookieKeyRotation(t *testing.T) {
+	oldSession := NewSession()
+	oldSession.SignCookie([]byte("old-secret"))
+
+	c := newTestContext()
+	if err := oldSession.LoadCheck(c); err != nil {
+		t.Fatal(err)
+	}
+	oldSession.Put(c, "foo", "bar")
+	if err := oldSession.SaveCheck(c); err != nil {
+		t.Fatal(err)
+	}
+	oldValue := extractTokenFromCookie(c.Response().Header().Get("Set-Cookie"))
+	token := oldSession.Token(c)
+
+	// The rotated session shares the store, but not the context key.
+	session := NewSession()
+	session.Store = oldSession.Store
+	session.SignCookie([]byte("new-secret"), []byte("old-secret"))
+
+	req := httptest.NewRequest(echo.GET, "/", nil)
+	req.AddCookie(&http.Cookie{Name: session.Cookie.Name, Value: oldValue})
+	c = echo.New().NewContext(req, httptest.NewRecorder())
+	if err := session.LoadCheck(c); err != nil {
+		t.Fatal(err)
+	}
+	if session.Token(c) != token {
+		t.Fatalf("got %q: expected %q", session.Token(c), token)
+	}
+
+	// New cookies are signed with the new secret.
+	session.Put(c, "foo", "baz")
+	if err := session.SaveCheck(c); err != nil {
+		t.Fatal(err)
+	}
+	newValue := extractTokenFromCookie(c.Response().Header().Get("Set-Cookie"))
+	if newValue == oldValue {
+		t.Fatalf("got %q: expected a cookie signed with the new secret", newValue)
+	}
+	if oldSession.verifyToken(newValue) != "" {
+		t.Errorf("got %q: expected the old secret to be rejected", oldSession.verifyToken(newValue))
+	}
+
+	newOnly := NewSession()
+	newOnly.SignCookie([]byte("new-secret"))
+	if newOnly.verifyToken(newValue) != token {
+		t.Errorf("got %q: expected %q", newOnly.verifyToken(newValue), token)
+	}
+	if newOnly.verifyToken(oldValue) != "" {
+		t.Errorf("got %q: expected %q", newOnly.verifyToken(oldValue), "")
+	}
+}
+
+func TestRefreshInterval(t *testing.T) {
+	for _, tc := range []struct {
+		refreshInterval time.Duration
+		wantCommits     int
+	}{
+		// The remaining idle time stays above the refresh interval.
+		{30 * time.Minute, 1},
+		// The remaining idle time is always below the refresh interval.
+		{2 * time.Hour, 4},
+	} {
+		session := NewSession()
+		session.IdleTimeout = time.Hour
+		session.RefreshInterval = tc.refreshInterval
+		store := &expiryStore{Store: session.Store}
+		session.Store = store
+
+		e := echo.New()
+		e.Use(loadAndSave(session))
+		e.GET("/put", func(c echo.Context) error {
+			session.Put(c, "foo", "bar")
+			return nil
+		})
+		e.GET("/get", func(c echo.Context) error {
+			return c.String(http.StatusOK, session.GetString(c, "foo"))
+		})
+
+		ts := newTestServer(t, e)
+
+		ts.execute(t, "/put")
+		for i := 0; i < 3; i++ {
+			header, body := ts.execute(t, "/get")
+			if body != "bar" {
+				t.Errorf("want %q; got %q", "bar", body)
+			}
+			if tc.wantCommits == 1 && header.Get("Set-Cookie") != "" {
+				t.Errorf("want %q; got %q", "", header.Get("Set-Cookie"))
+			}
+		}
+		ts.Close()
+
+		if store.commits != tc.wantCommits {
+			t.Errorf("got %d: expected %d store writes", store.commits, tc.wantCommits)
+		}
+	}
+}
+
+func TestRotateInterval(t *testing.T) {
+	session := NewSession()
+	session.RotateInterval = time.Hour
+
+	e := echo.New()
+	e.Use(loadAndSave(session))
+	e.GET("/put", func(c echo.Context) error {
+		session.Put(c, "foo", "bar")
+		return nil
+	})
+	e.GET("/get", func(c echo.Context) error {
+		return c.String(http.StatusOK, session.GetString(c, "foo"))
+	})
+
+	ts := newTestServer(t, e)
+	defer ts.Close()
+
+	header, _ := ts.execute(t, "/put")
+	token1 := extractTokenFromCookie(header.Get("Set-Cookie"))
+
+	// The token is kept until RotateInterval has passed.
+	header, _ = ts.execute(t, "/get")
+	if header.Get("Set-Cookie") != "" {
+		t.Fatalf("got %q: expected no cookie", header.Get("Set-Cookie"))
+	}
+
+	// Move the last rotation time back past the interval.
+	c := newTestContext()
+	sd, err := session.Load(c, token1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := sd.Deadline
+	sd.Values[lastRotatedKey] = time.Now().Add(-2 * time.Hour).UnixNano()
+	sd.markModified()
+	if _, _, err = session.Commit(c); err != nil {
+		t.Fatal(err)
+	}
+
+	header, body := ts.execute(t, "/get")
+	if body != "bar" {
+		t.Errorf("want %q; got %q", "bar", body)
+	}
+	token2 := extractTokenFromCookie(header.Get("Set-Cookie"))
+	if token2 == token1 {
+		t.Fatalf("got %q: expected a new token", token2)
+	}
+	_, found, _ := session.Store.Find(token1)
+	if found != false {
+		t.Errorf("got %v: expected %v", found, false)
+	}
+
+	// Automatic rotation keeps the session deadline.
+	c = newTestContext()
+	sd, err = session.Load(c, token2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sd.Deadline.Equal(deadline) {
+		t.Errorf("got %v: expected %v", sd.Deadline, deadline)
+	}
+
+	header, _ = ts.execute(t, "/get")
+	if header.Get("Set-Cookie") != "" {
+		t.Fatalf("got %q: expected no cookie", header.Get("Set-Cookie"))
+	}
+}
+
+func TestBind(t *testing.T) {
+	session := NewSession()
+	session.BindValidator = func(c echo.Context) string {
+		return c.Request().UserAgent()
+	}
+
+	e := echo.New()
+	e.Use(loadAndSave(session))
+	e.GET("/login", func(c echo.Context) error {
+		session.Put(c, "user", "alice")
+		session.Bind(c, session.BindValidator(c))
+		return nil
+	})
+	e.GET("/put", func(c echo.Context) error {
+		session.Put(c, "user", "bob")
+		return nil
+	})
+	e.GET("/get", func(c echo.Context) error {
+		return c.String(http.StatusOK, session.GetString(c, "user"))
+	})
+
+	ts := newTestServer(t, e)
+	defer ts.Close()
+
+	get := func(userAgent string) (http.Header, string) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/get", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("User-Agent", userAgent)
+		rs, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rs.Body.Close()
+		body, err := ioutil.ReadAll(rs.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs.Header, string(body)
+	}
+
+	// A session which is not bound is not checked.
+	ts.execute(t, "/put")
+	_, body := get("other-agent")
+	if body != "bob" {
+		t.Errorf("want %q; got %q", "bob", body)
+	}
+
+	// A bound session is kept while the fingerprint matches.
+	ts.execute(t, "/login")
+	_, body = ts.execute(t, "/get")
+	if body != "alice" {
+		t.Errorf("want %q; got %q", "alice", body)
+	}
+
+	// A mismatch destroys the session and the request runs without it.
+	header, body := get("other-agent")
+	if body != "" {
+		t.Errorf("want %q; got %q", "", body)
+	}
+	if !strings.Contains(header.Get("Set-Cookie"), "Max-Age=0") {
+		t.Errorf("want an expired cookie; got %q", header.Get("Set-Cookie"))
+	}
+	_, body = ts.execute(t, "/get")
+	if body != "" {
+		t.Errorf("want %q; got %q", "", body)
+	}
+}
+
+func TestSerializeByToken(t *testing.T) {
+	session := NewSession()
+	session.SerializeByToken = true
+
+	ctx := newTestContext()
+	if _, err := session.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	session.Put(ctx, "counter", 0)
+	token, _, err := session.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	increment := func() error {
+		e := echo.New()
+		req := httptest.NewRequest(echo.GET, "/", nil)
+		req.AddCookie(&http.Cookie{Name: session.Cookie.Name, Value: token})
+		c := e.NewContext(req, httptest.NewRecorder())
+
+		if err := session.LoadCheck(c); err != nil {
+			return err
+		}
+		defer session.Release(c)
+
+		n := session.GetInt(c, "counter")
+		// Give the other requests a chance to load the same value.
+		time.Sleep(time.Millisecond)
+		session.Put(c, "counter", n+1)
+		return session.SaveCheck(c)
+	}
+
+	const n = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- increment()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx = newTestContext()
+	if _, err = session.Load(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if got := session.GetInt(ctx, "counter"); got != n {
+		t.Errorf("want %d; got %d", n, got)
+	}
+	if l := session.tokenLocks.len(); l != 0 {
+		t.Errorf("want %d; got %d", 0, l)
+	}
+
+	// Release is a no-op without a lock, and a second call does nothing.
+	session.Release(ctx)
+	session.Release(ctx)
+}
+
+func TestSerializeByTokenLoadError(t *testing.T) {
+	store := &failingStore{Store: memstore.New()}
+	session := NewSession()
+	session.Store = store
+	session.SerializeByToken = true
+	session.BindValidator = func(c echo.Context) string {
+		return c.Request().UserAgent()
+	}
+
+	ctx := newTestContext()
+	if _, err := session.Load(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	session.Bind(ctx, "some-agent")
+	token, _, err := session.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The binding doesn't match, and destroying the session fails.
+	store.failDelete = true
+	req := httptest.NewRequest(echo.GET, "/", nil)
+	req.AddCookie(&http.Cookie{Name: session.Cookie.Name, Value: token})
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+	if err := session.LoadCheck(c); err == nil {
+		t.Fatal("expected an error from LoadCheck")
+	}
+
+	// The lock on the token was released, so the next request isn't blocked.
+	if l := session.tokenLocks.len(); l != 0 {
+		t.Errorf("want %d; got %d", 0, l)
+	}
+}
@@ -0,0 +1,10 @@
+module github.com/aberlorn/scs/sqlite3store
+
+go 1.12
+
+require (
+	github.com/aberlorn/scs/v2 v2.0.0
+	github.com/mattn/go-sqlite3 v1.14.22
+)
+
+replace github.com/aberlorn/scs/v2 => ../
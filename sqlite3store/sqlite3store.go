@@ -0,0 +1,124 @@
+package sqlite3store
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// SQLite3Store represents the session store.
+type SQLite3Store struct {
+	db          *sql.DB
+	stopCleanup chan bool
+}
+
+// New returns a new SQLite3Store instance, with a background cleanup goroutine
+// that runs every 5 minutes to remove expired session data.
+func New(db *sql.DB) *SQLite3Store {
+	return NewWithCleanupInterval(db, 5*time.Minute)
+}
+
+// NewWithCleanupInterval returns a new SQLite3Store instance. The cleanupInterval
+// parameter controls how frequently expired session data is removed by the
+// background cleanup goroutine. Setting it to 0 prevents the cleanup goroutine
+// from running (i.e. expired sessions will not be removed).
+func NewWithCleanupInterval(db *sql.DB, cleanupInterval time.Duration) *SQLite3Store {
+	s := &SQLite3Store{db: db}
+	if cleanupInterval > 0 {
+		s.StartCleanup(cleanupInterval)
+	}
+	return s
+}
+
+// Find returns the data for a given session token from the SQLite3Store instance.
+// If the session token is not found or is expired, the returned exists flag will
+// be set to false.
+func (s *SQLite3Store) Find(token string) (b []byte, exists bool, err error) {
+	row := s.db.QueryRow("SELECT data FROM sessions WHERE token = ? AND ? < expiry", token, unixTimestamp(time.Now()))
+	err = row.Scan(&b)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// Commit adds a session token and data to the SQLite3Store instance with the
+// given expiry time. If the session token already exists, then the data and expiry
+// time are updated.
+func (s *SQLite3Store) Commit(token string, b []byte, expiry time.Time) error {
+	_, err := s.db.Exec("INSERT OR REPLACE INTO sessions (token, data, expiry) VALUES (?, ?, ?)", token, b, unixTimestamp(expiry))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Delete removes a session token and corresponding data from the SQLite3Store
+// instance.
+func (s *SQLite3Store) Delete(token string) error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE token = ?", token)
+	return err
+}
+
+// Ping checks that the database is reachable.
+func (s *SQLite3Store) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// StartCleanup starts a background goroutine which deletes expired session
+// data from the sessions table every interval. This is useful when the store
+// was created with NewWithCleanupInterval(db, 0) and the cleanup should begin
+// later. A cleanup goroutine which is already running is stopped first. Use
+// StopCleanup to terminate the goroutine.
+func (s *SQLite3Store) StartCleanup(interval time.Duration) {
+	s.StopCleanup()
+	s.stopCleanup = make(chan bool)
+	go s.startCleanup(interval, s.stopCleanup)
+}
+
+func (s *SQLite3Store) startCleanup(interval time.Duration, stop chan bool) {
+	ticker := time.NewTicker(interval)
+	for {
+		select {
+		case <-ticker.C:
+			err := s.deleteExpired()
+			if err != nil {
+				log.Println(err)
+			}
+		case <-stop:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// StopCleanup terminates the background cleanup goroutine for the SQLite3Store
+// instance. It's rare to terminate this; generally SQLite3Store instances and
+// their cleanup goroutines are intended to be long-lived and run for the lifetime
+// of your application.
+//
+// There may be occasions though when your use of the SQLite3Store is transient.
+// An example is creating a new SQLite3Store instance in a test function. In this
+// scenario, the cleanup goroutine (which will run forever) will prevent the
+// SQLite3Store object from being garbage collected even after the test function
+// has finished. You can prevent this by manually calling StopCleanup.
+func (s *SQLite3Store) StopCleanup() {
+	if s.stopCleanup != nil {
+		s.stopCleanup <- true
+		s.stopCleanup = nil
+	}
+}
+
+func (s *SQLite3Store) deleteExpired() error {
+	_, err := s.db.Exec("DELETE FROM sessions WHERE expiry < ?", unixTimestamp(time.Now()))
+	return err
+}
+
+// unixTimestamp converts t to fractional seconds since the Unix epoch, which
+// is how expiry times are stored in the sessions table.
+func unixTimestamp(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
@@ -0,0 +1,228 @@
+package sqlite3store
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aberlorn/scs/v2"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	_ scs.Store    = (*SQLite3Store)(nil)
+	_ scs.Pingable = (*SQLite3Store)(nil)
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each connection to :memory: is a separate database.
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec("CREATE TABLE sessions (token TEXT PRIMARY KEY, data BLOB NOT NULL, expiry REAL NOT NULL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestFind(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	_, err := db.Exec("INSERT INTO sessions VALUES('session_token', 'encoded_data', ?)", unixTimestamp(time.Now().Add(time.Minute)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewWithCleanupInterval(db, 0)
+
+	b, found, err := s.Find("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+	if bytes.Equal(b, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", b, []byte("encoded_data"))
+	}
+}
+
+func TestFindMissing(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	s := NewWithCleanupInterval(db, 0)
+
+	_, found, err := s.Find("missing_session_token")
+	if err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestSaveNew(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	s := NewWithCleanupInterval(db, 0)
+
+	err := s.Commit("session_token", []byte("encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row := db.QueryRow("SELECT data FROM sessions WHERE token = 'session_token'")
+	var data []byte
+	err = row.Scan(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(data, []byte("encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", data, []byte("encoded_data"))
+	}
+}
+
+func TestSaveUpdated(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	_, err := db.Exec("INSERT INTO sessions VALUES('session_token', 'encoded_data', ?)", unixTimestamp(time.Now().Add(time.Minute)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewWithCleanupInterval(db, 0)
+
+	err = s.Commit("session_token", []byte("new_encoded_data"), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row := db.QueryRow("SELECT data FROM sessions WHERE token = 'session_token'")
+	var data []byte
+	err = row.Scan(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.DeepEqual(data, []byte("new_encoded_data")) == false {
+		t.Fatalf("got %v: expected %v", data, []byte("new_encoded_data"))
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	s := NewWithCleanupInterval(db, 0)
+
+	err := s.Commit("session_token", []byte("encoded_data"), time.Now().Add(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, found, _ := s.Find("session_token")
+	if found != true {
+		t.Fatalf("got %v: expected %v", found, true)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	_, found, _ = s.Find("session_token")
+	if found != false {
+		t.Fatalf("got %v: expected %v", found, false)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	_, err := db.Exec("INSERT INTO sessions VALUES('session_token', 'encoded_data', ?)", unixTimestamp(time.Now().Add(time.Minute)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewWithCleanupInterval(db, 0)
+
+	err = s.Delete("session_token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row := db.QueryRow("SELECT COUNT(*) FROM sessions WHERE token = 'session_token'")
+	var count int
+	err = row.Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("got %d: expected %d", count, 0)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	s := NewWithCleanupInterval(db, 0)
+	s.StartCleanup(200 * time.Millisecond)
+	defer s.StopCleanup()
+
+	err := s.Commit("session_token", []byte("encoded_data"), time.Now().Add(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row := db.QueryRow("SELECT COUNT(*) FROM sessions WHERE token = 'session_token'")
+	var count int
+	err = row.Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("got %d: expected %d", count, 1)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	row = db.QueryRow("SELECT COUNT(*) FROM sessions WHERE token = 'session_token'")
+	err = row.Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Fatalf("got %d: expected %d", count, 0)
+	}
+}
+
+func TestStopNilCleanup(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	s := NewWithCleanupInterval(db, 0)
+	time.Sleep(100 * time.Millisecond)
+	// A send to a nil channel will block forever
+	s.StopCleanup()
+}
+
+func TestPing(t *testing.T) {
+	db := openTestDB(t)
+
+	s := NewWithCleanupInterval(db, 0)
+	if err := s.Ping(context.Background()); err != nil {
+		t.Fatalf("got %v: expected %v", err, nil)
+	}
+
+	db.Close()
+	if err := s.Ping(context.Background()); err == nil {
+		t.Fatalf("got %v: expected an error", err)
+	}
+}
@@ -1,10 +1,31 @@
 package scs
 
 import (
+	"context"
+	"errors"
 	"time"
 )
 
-// Store is the interface for session stores.
+// ErrNotSupported is returned by Session methods which delegate to an
+// optional store interface that the configured Store does not implement.
+var ErrNotSupported = errors.New("scs: operation not supported by the session store")
+
+// ErrConcurrentModification is returned by Commit when the store implements
+// VersionedStore and the session data was changed by another request (for
+// example on another application instance) after it was loaded. The changes
+// made in the current request have not been committed; the request can be
+// retried, loading the session data again.
+var ErrConcurrentModification = errors.New("scs: session data was modified concurrently")
+
+// Store is the interface for session stores. A store can also implement any of
+// the optional interfaces CountableStore, GarbageCollectable, VersionedStore,
+// ExpiryStore, Pingable, TouchableStore, ContextStore and ClientSideStore to
+// support more features. A compile-time assertion such as
+//
+//	var _ scs.ExpiryStore = (*MyStore)(nil)
+//
+// catches a method signature which doesn't match; otherwise the optional
+// method is silently ignored.
 type Store interface {
 	// Delete should remove the session token and corresponding data from the
 	// session store. If the token does not exist then Delete should be a no-op
@@ -23,3 +44,202 @@ type Store interface {
 	// expiry time should be overwritten.
 	Commit(token string, b []byte, expiry time.Time) (err error)
 }
+
+// CountableStore is an optional interface for session stores which can count
+// the sessions they hold.
+type CountableStore interface {
+	Store
+
+	// Len should return the number of unexpired sessions in the store, i.e.
+	// the number of tokens Find would report as found.
+	Len() (n int, err error)
+}
+
+// GarbageCollectable is an optional interface for session stores which do
+// not expire sessions automatically (see Session.StartCleanup).
+type GarbageCollectable interface {
+	Store
+
+	// DeleteExpired should remove all expired sessions from the store and
+	// return the number removed. It must be safe to call concurrently with
+	// Find, Commit and Delete.
+	DeleteExpired() (n int, err error)
+}
+
+// VersionedStore is an optional interface for session stores which keep a
+// version number with each session, so that a commit can detect the data
+// being changed by another request since it was loaded (optimistic
+// concurrency). If the configured Store implements VersionedStore, these
+// methods are used in place of Find and Commit.
+type VersionedStore interface {
+	Store
+
+	// FindVersion should behave like Find, also returning the current
+	// version of the session data.
+	FindVersion(token string) (b []byte, version int64, found bool, err error)
+
+	// CommitIfUnchanged should behave like Commit, but only if the stored
+	// version of the session data is still expectedVersion. An
+	// expectedVersion of 0 means the session token must not exist yet (or
+	// must have expired). If the data was committed, the new version should
+	// be returned with ok set to true. If the version didn't match, nothing
+	// should be written and ok should be false (with a nil err).
+	CommitIfUnchanged(token string, b []byte, expiry time.Time, expectedVersion int64) (newVersion int64, ok bool, err error)
+}
+
+// ExpiryStore is an optional interface for session stores which can report
+// the expiry time of the session data they hold (see Session.StoredExpiry).
+type ExpiryStore interface {
+	Store
+
+	// FindWithExpiry should behave like Find, also returning the expiry time
+	// the session data was committed with.
+	FindWithExpiry(token string) (b []byte, expiry time.Time, found bool, err error)
+}
+
+// Pingable is an optional interface for session stores which can check that
+// their backend is reachable (see Session.Ping).
+type Pingable interface {
+	Store
+
+	// Ping should return an error if the store can't currently be used,
+	// giving up when ctx is done.
+	Ping(ctx context.Context) (err error)
+}
+
+// TouchableStore is an optional interface for session stores which can
+// extend the expiry time of session data without rewriting it. If the
+// configured Store implements TouchableStore, Touch is used in place of
+// Commit when a session is re-committed with unchanged data just to refresh
+// its idle timeout (see Session.Touch).
+type TouchableStore interface {
+	Store
+
+	// Touch should set the expiry time of the session data for token,
+	// leaving the data unchanged. If the session token is not found or is
+	// expired, the found return value should be false (and the err return
+	// value should be nil), in which case the data is committed in full.
+	Touch(token string, expiry time.Time) (found bool, err error)
+}
+
+// ContextStore is an optional interface for session stores which need access
+// to the current request and response, such as stores which keep the session
+// data in cookies. If the configured Store implements ContextStore, these
+// methods are used in place of Find, Commit and Delete.
+type ContextStore interface {
+	Store
+
+	// FindContext should behave like Find, using c for the current request.
+	FindContext(c SessionContext, token string) (b []byte, found bool, err error)
+
+	// CommitContext should behave like Commit, using c for the current
+	// request and response.
+	CommitContext(c SessionContext, token string, b []byte, expiry time.Time) (err error)
+
+	// DeleteContext should behave like Delete, using c for the current
+	// request and response.
+	DeleteContext(c SessionContext, token string) (err error)
+}
+
+// ClientSideStore is an optional interface for session stores which keep the
+// session data on the client, such as in cookies, so the data stored under a
+// token can only be reached through the request which carries it. Features
+// which need the data of other tokens, such as RenewGracePeriod, are not
+// available with a ClientSideStore. Stores which implement ContextStore only
+// to use the request context, such as pgxstore, should not implement it.
+type ClientSideStore interface {
+	ContextStore
+
+	// ClientSide is a marker method; it is never called.
+	ClientSide()
+}
+
+// clientSideStore reports whether the configured Store keeps the session data
+// on the client (see ClientSideStore).
+func (s *Session) clientSideStore() bool {
+	_, ok := s.Store.(ClientSideStore)
+	return ok
+}
+
+// storeFind finds the session data for token, along with its version if the
+// store implements VersionedStore.
+func (s *Session) storeFind(c SessionContext, token string) (b []byte, version int64, found bool, err error) {
+	start := time.Now()
+	if cs, ok := s.Store.(ContextStore); ok {
+		b, found, err = cs.FindContext(c, token)
+	} else if vs, ok := s.Store.(VersionedStore); ok {
+		b, version, found, err = vs.FindVersion(token)
+	} else {
+		b, found, err = s.Store.Find(token)
+	}
+	if err != nil {
+		s.storeError("find", token, start, err)
+	}
+	return b, version, found, err
+}
+
+// storeCommit commits the session data for token. If the store implements
+// VersionedStore, the data is only committed if its version is still
+// version, and the new version is returned.
+func (s *Session) storeCommit(c SessionContext, token string, b []byte, expiry time.Time, version int64) (newVersion int64, err error) {
+	start := time.Now()
+	if cs, ok := s.Store.(ContextStore); ok {
+		err = cs.CommitContext(c, token, b, expiry)
+	} else if vs, ok := s.Store.(VersionedStore); ok {
+		var committed bool
+		newVersion, committed, err = vs.CommitIfUnchanged(token, b, expiry, version)
+		if err == nil && !committed {
+			return 0, ErrConcurrentModification
+		}
+	} else {
+		err = s.Store.Commit(token, b, expiry)
+	}
+	if err != nil {
+		s.storeError("commit", token, start, err)
+		return 0, err
+	}
+	s.metrics().ObserveCommitLatency(time.Since(start))
+	return newVersion, nil
+}
+
+// storeTouch extends the expiry time of the session data for token if the
+// store implements TouchableStore (and not ClientSideStore), reporting
+// whether it did. If touched is false, the data must be committed in full.
+func (s *Session) storeTouch(token string, expiry time.Time) (touched bool, err error) {
+	if s.clientSideStore() {
+		return false, nil
+	}
+	ts, ok := s.Store.(TouchableStore)
+	if !ok {
+		return false, nil
+	}
+
+	start := time.Now()
+	touched, err = ts.Touch(token, expiry)
+	if err != nil {
+		s.storeError("touch", token, start, err)
+		return false, err
+	}
+	s.metrics().ObserveCommitLatency(time.Since(start))
+	return touched, nil
+}
+
+func (s *Session) storeDelete(c SessionContext, token string) (err error) {
+	start := time.Now()
+	if cs, ok := s.Store.(ContextStore); ok {
+		err = cs.DeleteContext(c, token)
+	} else {
+		err = s.Store.Delete(token)
+	}
+	if err != nil {
+		s.storeError("delete", token, start, err)
+	}
+	return err
+}
+
+// storeError records the failure of the store operation op on token, which
+// was started at start.
+func (s *Session) storeError(op string, token string, start time.Time, err error) {
+	s.metrics().IncStoreError(op)
+	s.logger().Error("scs: session store error", "op", op, "token", tokenPrefix(token), "duration", time.Since(start), "error", err)
+}
@@ -0,0 +1,56 @@
+package scs
+
+import "sync"
+
+// tokenLocks is a set of mutexes keyed by session token, used to serialize
+// requests which share a token when Session.SerializeByToken is set. The zero
+// value is ready to use.
+type tokenLocks struct {
+	mu    sync.Mutex
+	locks map[string]*tokenLock
+}
+
+type tokenLock struct {
+	mu      sync.Mutex
+	waiters int // The number of holders and waiters, guarded by tokenLocks.mu.
+}
+
+// lock blocks until the mutex for token is held and returns a function which
+// releases it. The entry for the token is removed once nobody holds or waits
+// for it, so the map only grows with the number of concurrent requests.
+func (tl *tokenLocks) lock(token string) (unlock func()) {
+	tl.mu.Lock()
+	if tl.locks == nil {
+		tl.locks = make(map[string]*tokenLock)
+	}
+	l, ok := tl.locks[token]
+	if !ok {
+		l = &tokenLock{}
+		tl.locks[token] = l
+	}
+	l.waiters++
+	tl.mu.Unlock()
+
+	l.mu.Lock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Unlock()
+
+			tl.mu.Lock()
+			l.waiters--
+			if l.waiters == 0 {
+				delete(tl.locks, token)
+			}
+			tl.mu.Unlock()
+		})
+	}
+}
+
+// len returns the number of tokens which are locked or waited for.
+func (tl *tokenLocks) len() int {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+	return len(tl.locks)
+}
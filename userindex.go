@@ -0,0 +1,150 @@
+package scs
+
+import "time"
+
+// userIndexPrefix is the prefix of the store tokens under which the index of
+// each user's sessions is kept. Tokens with reservedKeyPrefix are never
+// loaded as sessions, so the index can't be read through a session cookie.
+const userIndexPrefix = reservedKeyPrefix + "user:"
+
+// RegisterUserSession records that the session belongs to the user with
+// userID, so that it can be revoked with RevokeUser. The session is added to
+// the user's index when it is next committed, and again whenever it is
+// committed under a new token (e.g. after RenewToken). The session data
+// status will be set to Modified.
+//
+// The index is kept in the session store under a reserved token, so it is
+// included in Count. It is not supported by stores implementing ContextStore.
+func (s *Session) RegisterUserSession(c SessionContext, userID string) {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	sd.Values[userKey] = userID
+	sd.indexedToken = ""
+	sd.markModified()
+}
+
+// UserID returns the user ID registered for the session with
+// RegisterUserSession, or "" if none has been registered.
+func (s *Session) UserID(c SessionContext) string {
+	sd := s.getSessionDataFromContext(c)
+
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	userID, _ := sd.Values[userKey].(string)
+	return userID
+}
+
+// RevokeUser deletes all the sessions registered for the user with userID
+// from the session store, along with the user's index, and returns the
+// number of sessions deleted. Sessions in the index which have already
+// expired or been destroyed are skipped. It returns ErrNotSupported if the
+// store implements ContextStore, as the sessions of other clients can't be
+// reached. Requests which are using a revoked session when it is deleted
+// may commit it again.
+func (s *Session) RevokeUser(userID string) (int, error) {
+	if _, ok := s.Store.(ContextStore); ok {
+		return 0, ErrNotSupported
+	}
+
+	s.userIndexMu.Lock()
+	defer s.userIndexMu.Unlock()
+
+	index, err := s.findUserIndex(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for token := range index {
+		_, found, err := s.Store.Find(token)
+		if err != nil {
+			return n, err
+		}
+		if !found {
+			continue
+		}
+		err = s.Store.Delete(token)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, s.Store.Delete(userIndexPrefix + userID)
+}
+
+// indexUserSession adds the token of sd to the index of the user it is
+// registered for, if it hasn't been already. The caller must hold sd.mu.
+func (s *Session) indexUserSession(sd *sessionData) error {
+	userID, ok := sd.Values[userKey].(string)
+	if !ok || sd.indexedToken == sd.token {
+		return nil
+	}
+	if _, ok := s.Store.(ContextStore); ok {
+		return ErrNotSupported
+	}
+
+	s.userIndexMu.Lock()
+	defer s.userIndexMu.Unlock()
+
+	index, err := s.findUserIndex(userID)
+	if err != nil {
+		return err
+	}
+
+	// A session can't outlive its deadline, so the index is kept until the
+	// last deadline of its sessions and sessions past their deadline are
+	// dropped.
+	now := s.clock().Now()
+	index[sd.token] = sd.Deadline.UnixNano()
+	expiry := sd.Deadline
+	for token, v := range index {
+		deadline, _ := v.(int64)
+		d := time.Unix(0, deadline)
+		if d.Before(now) {
+			delete(index, token)
+		} else if d.After(expiry) {
+			expiry = d
+		}
+	}
+
+	b, err := s.Codec.Encode(expiry, index)
+	if err != nil {
+		return err
+	}
+	err = s.Store.Commit(userIndexPrefix+userID, s.addDataHeader(b), expiry)
+	if err != nil {
+		return err
+	}
+
+	sd.indexedToken = sd.token
+	return nil
+}
+
+// findUserIndex returns the index of the user's sessions, mapping each token
+// to its deadline in Unix nanoseconds. The caller must hold s.userIndexMu.
+func (s *Session) findUserIndex(userID string) (map[string]interface{}, error) {
+	b, found, err := s.Store.Find(userIndexPrefix + userID)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		b, found = s.stripDataHeader(b)
+	}
+	if !found {
+		return make(map[string]interface{}), nil
+	}
+
+	_, index, err := s.Codec.Decode(b)
+	if err != nil {
+		return nil, err
+	}
+	if index == nil {
+		index = make(map[string]interface{})
+	}
+	return index, nil
+}
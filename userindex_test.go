@@ -0,0 +1,92 @@
+package scs
+
+import "testing"
+
+func TestRevokeUser(t *testing.T) {
+	s := NewSession()
+
+	newUserSession := func(userID string) string {
+		ctx := newTestContext()
+		if _, err := s.Load(ctx, ""); err != nil {
+			t.Fatal(err)
+		}
+		s.Put(ctx, "foo", "bar")
+		s.RegisterUserSession(ctx, userID)
+		token, _, err := s.Commit(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	exists := func(token string) bool {
+		_, found, err := s.Store.Find(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return found
+	}
+
+	alice := []string{newUserSession("alice"), newUserSession("alice"), newUserSession("alice")}
+	bob := newUserSession("bob")
+
+	// A session renewed after it was registered is indexed under its new
+	// token.
+	ctx := newTestContext()
+	if _, err := s.Load(ctx, alice[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RenewToken(ctx); err != nil {
+		t.Fatal(err)
+	}
+	renewed, _, err := s.Commit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice[0] = renewed
+
+	// A destroyed session is still in the index, and is skipped.
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, alice[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Destroy(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The index can't be loaded as a session.
+	ctx = newTestContext()
+	if _, err = s.Load(ctx, userIndexPrefix+"alice"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Token(ctx) != "" || len(s.Keys(ctx)) != 0 {
+		t.Errorf("got %q, %v: expected a new session", s.Token(ctx), s.Keys(ctx))
+	}
+
+	n, err := s.RevokeUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("got %d: expected %d", n, 2)
+	}
+	for _, token := range alice {
+		if exists(token) {
+			t.Errorf("got %v: expected session %q to be revoked", true, token)
+		}
+	}
+	if exists(userIndexPrefix + "alice") {
+		t.Errorf("got %v: expected the index to be deleted", true)
+	}
+	if !exists(bob) {
+		t.Errorf("got %v: expected %v", false, true)
+	}
+
+	// Revoking a user without sessions is a no-op.
+	n, err = s.RevokeUser("carol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("got %d: expected %d", n, 0)
+	}
+}